@@ -5,7 +5,12 @@
 package sabi
 
 import (
+	"context"
+	"math"
+	"reflect"
+	"sort"
 	"sync"
+	"time"
 )
 
 type /* error reasons */ (
@@ -29,9 +34,34 @@ type /* error reasons */ (
 	// The field Errors is a map of which keys are registered names of DaxConn
 	// which failed to commit, and of which values are Err instances holding
 	// their error reasons.
+	// The field Committed is a list of registered names of DaxConn which
+	// succeeded to commit, and the field ForcedBack is a list of registered
+	// names of DaxConn which were forced back after their commits.
 	FailToCommitDaxConn struct {
+		Errors     map[string]Err
+		Committed  []string
+		ForcedBack []string
+	}
+
+	// FailToPrepareDaxConn is an error reason which indicates that some
+	// connections failed to prepare for commit.
+	// The field Errors is a map of which keys are registered names of DaxConn
+	// which failed to prepare, and of which values are Err instances holding
+	// their error reasons.
+	FailToPrepareDaxConn struct {
 		Errors map[string]Err
 	}
+
+	// DaxConnIsNotExpectedType is an error reason which indicates that a
+	// DaxConn got by a specified name is not of an expected type.
+	// The field Name is a registered name of a DaxSrc which created the
+	// DaxConn, the field Expected is the name of the expected type, and the
+	// field Actual is the name of the type of the DaxConn.
+	DaxConnIsNotExpectedType struct {
+		Name     string
+		Expected string
+		Actual   string
+	}
 )
 
 // DaxConn is an interface which represents a connection to a data source, and
@@ -43,6 +73,67 @@ type DaxConn interface {
 	Close()
 }
 
+// PreparableDaxConn is an interface which is optionally implemented by a
+// DaxConn to take part in a two-phase commit.
+// DaxBase calls #Prepare of all PreparableDaxConn before committing, and
+// commits only if all of them succeed to prepare.
+// A DaxConn which does not implement this interface is treated as a one-phase
+// participant and is committed after all PreparableDaxConn with the same order
+// are committed.
+// Commit orders take priority over this rule, so a one-phase DaxConn with a
+// smaller order is committed before PreparableDaxConn with larger orders,
+// though all PreparableDaxConn are prepared before any DaxConn is committed.
+type PreparableDaxConn interface {
+	DaxConn
+	Prepare() Err
+}
+
+// ForceBackDaxConn is an interface which is optionally implemented by a
+// DaxConn to undo its changes which were already committed.
+// When some DaxConn failed to commit, DaxBase calls #ForceBack of DaxConn
+// which already succeeded to commit, so that a data source which is not
+// transactional can apply compensating actions.
+type ForceBackDaxConn interface {
+	DaxConn
+	ForceBack()
+}
+
+// OrderedDaxSrc is an interface which is optionally implemented by a DaxSrc
+// to specify a default order of commit, rollback and close of its DaxConn.
+// DaxConn created by DaxSrc with smaller orders are committed earlier, and
+// DaxConn with a same order are committed in parallel.
+// An order specified at registration takes priority over this default order.
+type OrderedDaxSrc interface {
+	DaxSrc
+	CommitOrder() int
+}
+
+const (
+	// LateCommitOrder is a commit order for DaxConn which are committed after
+	// ordinary DaxConn but may fail to commit, such as ones which write outputs
+	// or send requests.
+	// This is smaller than AfterCommitOrder, so that a failure of such DaxConn
+	// is detected before any side effect is delivered.
+	LateCommitOrder = math.MaxInt - 1
+
+	// AfterCommitOrder is a commit order for DaxConn which deliver side
+	// effects, such as messages or events, which cannot be undone, so that
+	// they are committed after all other DaxConn have committed.
+	AfterCommitOrder = math.MaxInt
+)
+
+// ReadOnlyDaxConn is an interface which is optionally implemented by a
+// DaxConn to be notified that it is used in a read-only transaction.
+// DaxBase calls #SetReadOnly just after creating a DaxConn in a read-only
+// transaction.
+// A DaxConn which does not implement this interface accepts writes in a
+// read-only transaction, but they are discarded because the DaxConn is
+// rollbacked instead of being committed.
+type ReadOnlyDaxConn interface {
+	DaxConn
+	SetReadOnly() Err
+}
+
 // DaxSrc is an interface which represents a data source like database, etc.,
 // and creates a DaxConn to the data source.
 // This requires a method: #CreateDaxConn to do so.
@@ -50,6 +141,29 @@ type DaxSrc interface {
 	CreateDaxConn() (DaxConn, Err)
 }
 
+// ContextDaxSrc is an interface which is optionally implemented by a DaxSrc
+// to create a DaxConn with a context.Context of a transaction.
+// If a DaxSrc implements this interface, DaxBase calls #CreateDaxConnCtx
+// instead of #CreateDaxConn.
+type ContextDaxSrc interface {
+	DaxSrc
+	CreateDaxConnCtx(ctx context.Context) (DaxConn, Err)
+}
+
+// ContextDaxConn is an interface which is optionally implemented by a DaxConn
+// to commit, rollback and close with a context.Context of a transaction.
+// If a DaxConn implements this interface, DaxBase calls #CommitCtx,
+// #RollbackCtx and #CloseCtx instead of #Commit, #Rollback and #Close.
+// A context.Context passed to #RollbackCtx and #CloseCtx has the values of the
+// context of the transaction but is never canceled, because they are called
+// also when the transaction is canceled or timed out.
+type ContextDaxConn interface {
+	DaxConn
+	CommitCtx(ctx context.Context) Err
+	RollbackCtx(ctx context.Context)
+	CloseCtx(ctx context.Context)
+}
+
 // Dax is an interface for a set of data accesses, and requires a method:
 // #GetDaxConn which gets a connection to an external data access.
 type Dax interface {
@@ -59,6 +173,7 @@ type Dax interface {
 var (
 	isGlobalDaxSrcsFixed bool              = false
 	globalDaxSrcMap      map[string]DaxSrc = make(map[string]DaxSrc)
+	globalDaxSrcOrderMap map[string]int    = make(map[string]int)
 	globalDaxSrcMutex    sync.Mutex
 )
 
@@ -70,6 +185,19 @@ func AddGlobalDaxSrc(name string, ds DaxSrc) {
 
 	if !isGlobalDaxSrcsFixed {
 		globalDaxSrcMap[name] = ds
+		delete(globalDaxSrcOrderMap, name)
+	}
+}
+
+// AddGlobalDaxSrcWithOrder registers a global DaxSrc with its name and an
+// order of commit, rollback and close of its DaxConn.
+func AddGlobalDaxSrcWithOrder(name string, ds DaxSrc, order int) {
+	globalDaxSrcMutex.Lock()
+	defer globalDaxSrcMutex.Unlock()
+
+	if !isGlobalDaxSrcsFixed {
+		globalDaxSrcMap[name] = ds
+		globalDaxSrcOrderMap[name] = order
 	}
 }
 
@@ -81,18 +209,33 @@ func FixGlobalDaxSrcs() {
 // DaxBase is a structure type which manages multiple DaxSrc and those DaxConn,
 // and also work as an implementation of Dax interface.
 type DaxBase struct {
+	ctx                 context.Context
 	isLocalDaxSrcsFixed bool
 	localDaxSrcMap      map[string]DaxSrc
+	localDaxSrcOrderMap map[string]int
 	daxConnMap          map[string]DaxConn
+	daxConnOrderMap     map[string]int
 	daxConnMutex        sync.Mutex
+	txnLog              *TxnLog
+	txnId               string
+	isTxnLogged         bool
+	savepoints          []string
+	savepointSeq        int
+	joinedBase          *DaxBase
+	isRollbackOnly      bool
+	isReadOnly          bool
+	fence               chan struct{}
 }
 
 // NewDaxBase is a function which creates a new DaxBase.
 func NewDaxBase() *DaxBase {
 	return &DaxBase{
+		ctx:                 context.Background(),
 		isLocalDaxSrcsFixed: false,
 		localDaxSrcMap:      make(map[string]DaxSrc),
+		localDaxSrcOrderMap: make(map[string]int),
 		daxConnMap:          make(map[string]DaxConn),
+		daxConnOrderMap:     make(map[string]int),
 	}
 }
 
@@ -104,51 +247,297 @@ func (base *DaxBase) AddLocalDaxSrc(name string, ds DaxSrc) {
 
 	if !base.isLocalDaxSrcsFixed {
 		base.localDaxSrcMap[name] = ds
+		delete(base.localDaxSrcOrderMap, name)
 	}
 }
 
+// AddLocalDaxSrcWithOrder is a method which registers a local DaxSrc with a
+// specified name and an order of commit, rollback and close of its DaxConn.
+func (base *DaxBase) AddLocalDaxSrcWithOrder(name string, ds DaxSrc, order int) {
+	base.daxConnMutex.Lock()
+	defer base.daxConnMutex.Unlock()
+
+	if !base.isLocalDaxSrcsFixed {
+		base.localDaxSrcMap[name] = ds
+		base.localDaxSrcOrderMap[name] = order
+	}
+}
+
+// Context is a method which returns a context.Context of a transaction
+// currently running on this DaxBase.
+// If no transaction is running, this method returns context.Background().
+func (base *DaxBase) Context() context.Context {
+	return base.ctx
+}
+
 // GetDaxConn gets a DaxConn which is a connection to a data source by
 // specified name.
 // If a DaxConn is found, this method returns it, but not found, creates a new
 // one with a local or global DaxSrc associated with same name.
 // If there are both local and global DaxSrc with same name, the local DaxSrc
 // is used.
+//
+// While this DaxBase joins a transaction of another DaxBase, this method gets
+// a DaxConn from the other DaxBase.
 func (base *DaxBase) GetDaxConn(name string) (DaxConn, Err) {
+	joinedBase := base.joinedBase
+	if joinedBase != nil {
+		return joinedBase.getDaxConn(name, base)
+	}
+	return base.getDaxConn(name, nil)
+}
+
+func (base *DaxBase) getDaxConn(name string, joiningBase *DaxBase) (DaxConn, Err) {
+	base.daxConnMutex.Lock()
+	isFenced := base.isFenced()
 	conn := base.daxConnMap[name]
+	base.daxConnMutex.Unlock()
+
+	if isFenced {
+		return nil, ErrBy(TxnIsCanceled{}, context.Canceled)
+	}
 	if conn != nil {
 		return conn, Ok()
 	}
 
 	ds := base.localDaxSrcMap[name]
+	order, hasOrder := base.localDaxSrcOrderMap[name]
+	if ds == nil && joiningBase != nil {
+		ds = joiningBase.localDaxSrcMap[name]
+		order, hasOrder = joiningBase.localDaxSrcOrderMap[name]
+	}
 	if ds == nil {
 		ds = globalDaxSrcMap[name]
+		order, hasOrder = globalDaxSrcOrderMap[name]
 	}
 	if ds == nil {
 		return nil, ErrBy(DaxSrcIsNotFound{Name: name})
 	}
+	if !hasOrder {
+		ods, ok := ds.(OrderedDaxSrc)
+		if ok {
+			order = ods.CommitOrder()
+		}
+	}
 
 	base.daxConnMutex.Lock()
 	defer base.daxConnMutex.Unlock()
 
+	if base.isFenced() {
+		return nil, ErrBy(TxnIsCanceled{}, context.Canceled)
+	}
+
 	conn = base.daxConnMap[name]
 	if conn != nil {
 		return conn, Ok()
 	}
 
+	e := base.ctx.Err()
+	if e != nil {
+		return nil, ErrBy(FailToCreateDaxConn{Name: name}, e)
+	}
+
 	var err Err
-	conn, err = ds.CreateDaxConn()
+	cds, ok := ds.(ContextDaxSrc)
+	if ok {
+		conn, err = cds.CreateDaxConnCtx(base.ctx)
+	} else {
+		conn, err = ds.CreateDaxConn()
+	}
 	if !err.IsOk() {
 		return nil, ErrBy(FailToCreateDaxConn{Name: name}, err)
 	}
 
+	if base.isReadOnly {
+		roc, ok := conn.(ReadOnlyDaxConn)
+		if ok {
+			err = roc.SetReadOnly()
+			if !err.IsOk() {
+				rollbackDaxConn(base.ctx, conn)
+				closeDaxConn(base.ctx, conn)
+				return nil, ErrBy(FailToCreateDaxConn{Name: name}, err)
+			}
+		}
+	}
+
+	err = base.setSavepoints(name, conn)
+	if !err.IsOk() {
+		rollbackDaxConn(base.ctx, conn)
+		closeDaxConn(base.ctx, conn)
+		return nil, err
+	}
+
 	base.daxConnMap[name] = conn
+	base.daxConnOrderMap[name] = order
 
 	return conn, Ok()
 }
 
-func (base *DaxBase) begin() {
+// GetDaxConn is a function which gets a DaxConn by a specified name from a
+// specified Dax, and returns it as a type specified by the type parameter.
+// If the DaxConn is not of the type, this function returns an Err having the
+// reason DaxConnIsNotExpectedType instead of panicking.
+func GetDaxConn[C DaxConn](dax Dax, name string) (C, Err) {
+	var c C
+
+	conn, err := dax.GetDaxConn(name)
+	if !err.IsOk() {
+		return c, err
+	}
+
+	c, ok := conn.(C)
+	if !ok {
+		return c, ErrBy(newDaxConnIsNotExpectedType[C](name, conn))
+	}
+	return c, Ok()
+}
+
+// CheckDaxConnType is a function which checks that a DaxSrc registered with
+// a specified name in a specified Dax or globally creates a DaxConn of a type
+// specified by the type parameter.
+// This function creates a DaxConn with the DaxSrc for checking, and rollbacks
+// and closes it immediately.
+// This function is supposed to be called at the startup of an application to
+// detect misconfigurations of DaxSrc before any transaction runs.
+// A specified Dax is required to be a DaxBase or a structure embedding a
+// DaxBase.
+func CheckDaxConnType[C DaxConn](dax Dax, name string) Err {
+	h, ok := dax.(daxBaseHolder)
+	if !ok {
+		return ErrBy(DaxBaseIsNotFound{})
+	}
+
+	ds := h.daxBase().findDaxSrc(name)
+	if ds == nil {
+		return ErrBy(DaxSrcIsNotFound{Name: name})
+	}
+
+	ctx := context.Background()
+
+	var conn DaxConn
+	var err Err
+	cds, ok := ds.(ContextDaxSrc)
+	if ok {
+		conn, err = cds.CreateDaxConnCtx(ctx)
+	} else {
+		conn, err = ds.CreateDaxConn()
+	}
+	if !err.IsOk() {
+		return ErrBy(FailToCreateDaxConn{Name: name}, err)
+	}
+	defer closeDaxConn(ctx, conn)
+	defer rollbackDaxConn(ctx, conn)
+
+	_, ok = conn.(C)
+	if !ok {
+		return ErrBy(newDaxConnIsNotExpectedType[C](name, conn))
+	}
+	return Ok()
+}
+
+func newDaxConnIsNotExpectedType[C DaxConn](
+	name string, conn DaxConn,
+) DaxConnIsNotExpectedType {
+	return DaxConnIsNotExpectedType{
+		Name:     name,
+		Expected: reflect.TypeOf((*C)(nil)).Elem().String(),
+		Actual:   reflect.TypeOf(conn).String(),
+	}
+}
+
+func (base *DaxBase) findDaxSrc(name string) DaxSrc {
+	ds, _ := base.findDaxSrcWithOrder(name)
+	return ds
+}
+
+// findDaxSrcWithOrder finds a DaxSrc in the same way as #GetDaxConn does, and
+// returns it with the commit order of its DaxConn.
+func (base *DaxBase) findDaxSrcWithOrder(name string) (DaxSrc, int) {
+	ds := base.localDaxSrcMap[name]
+	order, hasOrder := base.localDaxSrcOrderMap[name]
+	if ds == nil {
+		ds = globalDaxSrcMap[name]
+		order, hasOrder = globalDaxSrcOrderMap[name]
+	}
+	if ds != nil && !hasOrder {
+		ods, ok := ds.(OrderedDaxSrc)
+		if ok {
+			order = ods.CommitOrder()
+		}
+	}
+	return ds, order
+}
+
+type txnDaxBaseKey struct{}
+
+func getTxnDaxBase(ctx context.Context) *DaxBase {
+	base, _ := ctx.Value(txnDaxBaseKey{}).(*DaxBase)
+	return base
+}
+
+func (base *DaxBase) begin(ctx context.Context, readOnly bool) {
+	base.isReadOnly = readOnly
+	if base.txnLog != nil {
+		base.txnId = newTxnId()
+		ctx = context.WithValue(ctx, txnIdKey{}, base.txnId)
+	}
+	base.ctx = context.WithValue(ctx, txnDaxBaseKey{}, base)
 	base.isLocalDaxSrcsFixed = true
 	isGlobalDaxSrcsFixed = true
+
+	base.daxConnMutex.Lock()
+	base.fence = nil
+	base.daxConnMutex.Unlock()
+}
+
+// markRollbackOnly marks the transaction of this DaxBase not to be
+// committed, because a joined inner transaction failed.
+// This method can be called from a goroutine of a joined inner transaction,
+// so it locks daxConnMutex.
+func (base *DaxBase) markRollbackOnly() {
+	base.daxConnMutex.Lock()
+	defer base.daxConnMutex.Unlock()
+
+	base.isRollbackOnly = true
+}
+
+func (base *DaxBase) isMarkedRollbackOnly() bool {
+	base.daxConnMutex.Lock()
+	defer base.daxConnMutex.Unlock()
+
+	return base.isRollbackOnly
+}
+
+// isFenced reports whether this DaxBase is still used by a logic or a commit
+// which was abandoned by a timeout of a finished transaction.
+// This method is required to be called while daxConnMutex is locked.
+func (base *DaxBase) isFenced() bool {
+	if base.fence == nil {
+		return false
+	}
+	select {
+	case <-base.fence:
+		return false
+	default:
+		return true
+	}
+}
+
+func (base *DaxBase) waitForFence(ctx context.Context) Err {
+	base.daxConnMutex.Lock()
+	fence := base.fence
+	base.daxConnMutex.Unlock()
+
+	if fence == nil {
+		return Ok()
+	}
+
+	select {
+	case <-fence:
+		return Ok()
+	case <-ctx.Done():
+		return ErrBy(TxnIsCanceled{}, ctx.Err())
+	}
 }
 
 type namedErr struct {
@@ -157,18 +546,157 @@ type namedErr struct {
 }
 
 func (base *DaxBase) commit() Err {
-	ch := make(chan namedErr)
+	err := base.writeTxnLog(txnPhasePrepare)
+	if !err.IsOk() {
+		return err
+	}
+
+	preparableConnMap := make(map[string]DaxConn)
 
 	for name, conn := range base.daxConnMap {
+		_, ok := conn.(PreparableDaxConn)
+		if ok {
+			preparableConnMap[name] = conn
+		}
+	}
+
+	errs := runDaxConnsInParallel(preparableConnMap, func(conn DaxConn) Err {
+		return conn.(PreparableDaxConn).Prepare()
+	})
+	if len(errs) > 0 {
+		return ErrBy(FailToPrepareDaxConn{Errors: errs})
+	}
+
+	err = base.writeTxnLog(txnPhaseCommit)
+	if !err.IsOk() {
+		return err
+	}
+
+	committed, errs := commitDaxConnGroups(base.ctx, base.orderedDaxConnGroups())
+	if len(errs) > 0 {
+		sort.Strings(committed)
+		forcedBack := base.forceBack(committed)
+		return ErrBy(FailToCommitDaxConn{
+			Errors:     errs,
+			Committed:  committed,
+			ForcedBack: forcedBack,
+		})
+	}
+
+	return Ok()
+}
+
+// commitDaxConnGroups commits DaxConn group by group in order, and in each
+// group, commits DaxConn which implement PreparableDaxConn before the others.
+// If some of DaxConn failed to commit, this function stops committing and
+// returns the names of committed DaxConn and the errors.
+func commitDaxConnGroups(
+	ctx context.Context, groups []map[string]DaxConn,
+) ([]string, map[string]Err) {
+	commitFn := func(conn DaxConn) Err {
+		return commitDaxConn(ctx, conn)
+	}
+
+	committed := make([]string, 0)
+
+	for _, group := range groups {
+		twoPhaseConnMap := make(map[string]DaxConn)
+		onePhaseConnMap := make(map[string]DaxConn)
+
+		for name, conn := range group {
+			_, ok := conn.(PreparableDaxConn)
+			if ok {
+				twoPhaseConnMap[name] = conn
+			} else {
+				onePhaseConnMap[name] = conn
+			}
+		}
+
+		for _, connMap := range []map[string]DaxConn{twoPhaseConnMap, onePhaseConnMap} {
+			errs := runDaxConnsInParallel(connMap, commitFn)
+
+			for name := range connMap {
+				_, failed := errs[name]
+				if !failed {
+					committed = append(committed, name)
+				}
+			}
+
+			if len(errs) > 0 {
+				return committed, errs
+			}
+		}
+	}
+
+	return committed, nil
+}
+
+func (base *DaxBase) orderedDaxConnGroups() []map[string]DaxConn {
+	return groupDaxConnsByOrder(base.daxConnMap, base.daxConnOrderMap)
+}
+
+func groupDaxConnsByOrder(
+	connMap map[string]DaxConn, orderMap map[string]int,
+) []map[string]DaxConn {
+	groupMap := make(map[int]map[string]DaxConn)
+	orders := make([]int, 0)
+
+	for name, conn := range connMap {
+		order := orderMap[name]
+		group := groupMap[order]
+		if group == nil {
+			group = make(map[string]DaxConn)
+			groupMap[order] = group
+			orders = append(orders, order)
+		}
+		group[name] = conn
+	}
+
+	sort.Ints(orders)
+
+	groups := make([]map[string]DaxConn, len(orders))
+	for i, order := range orders {
+		groups[i] = groupMap[order]
+	}
+	return groups
+}
+
+func (base *DaxBase) forceBack(names []string) []string {
+	connMap := make(map[string]DaxConn)
+	forcedBack := make([]string, 0, len(names))
+
+	for _, name := range names {
+		conn := base.daxConnMap[name]
+		_, ok := conn.(ForceBackDaxConn)
+		if ok {
+			connMap[name] = conn
+			forcedBack = append(forcedBack, name)
+		}
+	}
+
+	runDaxConnsInParallel(connMap, func(conn DaxConn) Err {
+		conn.(ForceBackDaxConn).ForceBack()
+		return Ok()
+	})
+
+	return forcedBack
+}
+
+func runDaxConnsInParallel(
+	connMap map[string]DaxConn, fn func(DaxConn) Err,
+) map[string]Err {
+	ch := make(chan namedErr)
+
+	for name, conn := range connMap {
 		go func(name string, conn DaxConn, ch chan namedErr) {
-			err := conn.Commit()
+			err := fn(conn)
 			ne := namedErr{name: name, err: err}
 			ch <- ne
 		}(name, conn, ch)
 	}
 
 	errs := make(map[string]Err)
-	n := len(base.daxConnMap)
+	n := len(connMap)
 	for i := 0; i < n; i++ {
 		select {
 		case ne := <-ch:
@@ -178,39 +706,156 @@ func (base *DaxBase) commit() Err {
 		}
 	}
 
-	if len(errs) > 0 {
-		return ErrBy(FailToCommitDaxConn{Errors: errs})
+	return errs
+}
+
+func (base *DaxBase) rollback() {
+	base.writeTxnLog(txnPhaseRollback)
+
+	for _, group := range base.orderedDaxConnGroups() {
+		runDaxConnsInParallel(group, func(conn DaxConn) Err {
+			rollbackDaxConn(base.ctx, conn)
+			return Ok()
+		})
 	}
+}
 
-	return Ok()
+func (base *DaxBase) close() {
+	for _, group := range base.orderedDaxConnGroups() {
+		runDaxConnsInParallel(group, func(conn DaxConn) Err {
+			closeDaxConn(base.ctx, conn)
+			return Ok()
+		})
+	}
+
+	base.writeTxnLog(txnPhaseEnd)
+
+	base.daxConnMutex.Lock()
+	defer base.daxConnMutex.Unlock()
+
+	base.daxConnMap = make(map[string]DaxConn)
+	base.daxConnOrderMap = make(map[string]int)
+	base.isLocalDaxSrcsFixed = false
+	base.ctx = context.Background()
+	base.txnId = ""
+	base.isTxnLogged = false
+	base.savepoints = nil
+	base.savepointSeq = 0
+	base.isRollbackOnly = false
+	base.isReadOnly = false
 }
 
-func (base *DaxBase) rollback() {
-	var wg sync.WaitGroup
-	wg.Add(len(base.daxConnMap))
+// end rollbacks DaxConn if rollback is true and closes them.
+// If a timeout is positive, this method waits for them within the timeout,
+// and returns false if they did not finish.
+// While a logic or a commit abandoned by a timeout is running, this DaxBase
+// refuses to create a new DaxConn and to begin a next transaction, and a
+// rollback waits for the abandoned commit not to run concurrently with it.
+func (base *DaxBase) end(
+	rollback bool, logicDone, commitDone <-chan struct{}, timeout time.Duration,
+) bool {
+	if timeout <= 0 {
+		if rollback {
+			base.rollback()
+		}
+		base.close()
+		return true
+	}
+
+	fence := make(chan struct{})
+
+	base.daxConnMutex.Lock()
+	base.fence = fence
+	base.daxConnMutex.Unlock()
+
+	closed := make(chan struct{})
+
+	go func() {
+		defer close(fence)
+
+		if commitDone != nil {
+			<-commitDone
+		}
+		if rollback {
+			base.rollback()
+		}
+		base.close()
+		close(closed)
+
+		if logicDone != nil {
+			<-logicDone
+		}
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
 
-	for _, conn := range base.daxConnMap {
-		go func(conn DaxConn) {
-			defer wg.Done()
-			conn.Rollback()
-		}(conn)
+	select {
+	case <-closed:
+		return true
+	case <-timer.C:
+		return false
 	}
+}
+
+func (base *DaxBase) join(outer *DaxBase) {
+	base.daxConnMutex.Lock()
+	defer base.daxConnMutex.Unlock()
 
-	wg.Wait()
+	base.joinedBase = outer
 }
 
-func (base *DaxBase) close() {
-	var wg sync.WaitGroup
-	wg.Add(len(base.daxConnMap))
+func (base *DaxBase) leave() {
+	base.daxConnMutex.Lock()
+	defer base.daxConnMutex.Unlock()
+
+	base.joinedBase = nil
+}
+
+func commitDaxConn(ctx context.Context, conn DaxConn) Err {
+	cc, ok := conn.(ContextDaxConn)
+	if ok {
+		return cc.CommitCtx(ctx)
+	}
+	return conn.Commit()
+}
 
-	for _, conn := range base.daxConnMap {
-		go func(conn DaxConn) {
-			defer wg.Done()
-			conn.Close()
-		}(conn)
+func rollbackDaxConn(ctx context.Context, conn DaxConn) {
+	cc, ok := conn.(ContextDaxConn)
+	if ok {
+		cc.RollbackCtx(uncanceledCtx{parent: ctx})
+		return
 	}
+	conn.Rollback()
+}
 
-	wg.Wait()
+func closeDaxConn(ctx context.Context, conn DaxConn) {
+	cc, ok := conn.(ContextDaxConn)
+	if ok {
+		cc.CloseCtx(uncanceledCtx{parent: ctx})
+		return
+	}
+	conn.Close()
+}
 
-	base.isLocalDaxSrcsFixed = false
+// uncanceledCtx is a context.Context which has the values of its parent but
+// is never canceled and has no deadline.
+type uncanceledCtx struct {
+	parent context.Context
+}
+
+func (ctx uncanceledCtx) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (ctx uncanceledCtx) Done() <-chan struct{} {
+	return nil
+}
+
+func (ctx uncanceledCtx) Err() error {
+	return nil
+}
+
+func (ctx uncanceledCtx) Value(key any) any {
+	return ctx.parent.Value(key)
 }
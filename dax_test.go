@@ -2,6 +2,7 @@ package sabi
 
 import (
 	"container/list"
+	"context"
 	"github.com/stretchr/testify/assert"
 	"reflect"
 	"testing"
@@ -10,6 +11,8 @@ import (
 var logs list.List
 var WillFailToCreateFooDaxConn bool = false
 var WillFailToCommitFooDaxConn bool = false
+var WillFailToPrepareQuxDaxConn bool = false
+var WillFailToSetReadOnlyQuxDaxConn bool = false
 
 type /* error reason */ (
 	InvalidDaxConn struct{}
@@ -18,11 +21,22 @@ type /* error reason */ (
 func Clear() {
 	isGlobalDaxSrcsFixed = false
 	globalDaxSrcMap = make(map[string]DaxSrc)
+	globalDaxSrcOrderMap = make(map[string]int)
 
 	logs.Init()
 
 	WillFailToCreateFooDaxConn = false
 	WillFailToCommitFooDaxConn = false
+	WillFailToPrepareQuxDaxConn = false
+	WillFailToSetReadOnlyQuxDaxConn = false
+}
+
+func GetLogs() []string {
+	a := make([]string, 0, logs.Len())
+	for el := logs.Front(); el != nil; el = el.Next() {
+		a = append(a, el.Value.(string))
+	}
+	return a
 }
 
 type FooDaxConn struct {
@@ -182,7 +196,7 @@ func TestDaxBase_begin(t *testing.T) {
 	assert.Equal(t, len(base.localDaxSrcMap), 1)
 	assert.Equal(t, len(base.daxConnMap), 0)
 
-	base.begin()
+	base.begin(context.Background(), false)
 
 	assert.True(t, isGlobalDaxSrcsFixed)
 	assert.True(t, base.isLocalDaxSrcsFixed)
@@ -345,7 +359,7 @@ func TestDaxBase_commit(t *testing.T) {
 
 	base.AddLocalDaxSrc("foo", FooDaxSrc{})
 	base.AddLocalDaxSrc("bar", &BarDaxSrc{})
-	base.begin()
+	base.begin(context.Background(), false)
 
 	fooConn, fooErr := base.GetDaxConn("foo")
 	assert.NotNil(t, fooConn)
@@ -377,7 +391,7 @@ func TestDaxBase_commit_failed(t *testing.T) {
 	base.AddLocalDaxSrc("foo", FooDaxSrc{})
 	base.AddLocalDaxSrc("bar", &BarDaxSrc{})
 
-	base.begin()
+	base.begin(context.Background(), false)
 
 	fooConn, fooErr := base.GetDaxConn("foo")
 	assert.NotNil(t, fooConn)
@@ -411,7 +425,7 @@ func TestDaxBase_rollback(t *testing.T) {
 
 	base.AddLocalDaxSrc("foo", FooDaxSrc{})
 	base.AddLocalDaxSrc("bar", &BarDaxSrc{})
-	base.begin()
+	base.begin(context.Background(), false)
 
 	fooConn, fooErr := base.GetDaxConn("foo")
 	assert.NotNil(t, fooConn)
@@ -441,7 +455,7 @@ func TestDaxBase_close(t *testing.T) {
 
 	base.AddLocalDaxSrc("foo", FooDaxSrc{})
 	base.AddLocalDaxSrc("bar", &BarDaxSrc{})
-	base.begin()
+	base.begin(context.Background(), false)
 
 	fooConn, fooErr := base.GetDaxConn("foo")
 	assert.NotNil(t, fooConn)
@@ -472,11 +486,7 @@ func NewFooDax(dax Dax) FooDax {
 }
 
 func (dax FooDax) GetFooDaxConn(name string) (*FooDaxConn, Err) {
-	conn, err := dax.GetDaxConn(name)
-	if !err.IsOk() {
-		return nil, err
-	}
-	return conn.(*FooDaxConn), Ok()
+	return GetDaxConn[*FooDaxConn](dax, name)
 }
 
 type BarDax struct {
@@ -488,11 +498,7 @@ func NewBarDax(dax Dax) BarDax {
 }
 
 func (dax BarDax) GetBarDaxConn(name string) (*BarDaxConn, Err) {
-	conn, err := dax.GetDaxConn(name)
-	if !err.IsOk() {
-		return nil, err
-	}
-	return conn.(*BarDaxConn), Ok()
+	return GetDaxConn[*BarDaxConn](dax, name)
 }
 
 func TestDax_GetXxxConn(t *testing.T) {
@@ -503,7 +509,7 @@ func TestDax_GetXxxConn(t *testing.T) {
 	base.AddLocalDaxSrc("foo", FooDaxSrc{})
 	base.AddLocalDaxSrc("bar", &BarDaxSrc{})
 
-	base.begin()
+	base.begin(context.Background(), false)
 
 	fooDax := NewFooDax(base)
 	fooConn, fooErr := fooDax.GetFooDaxConn("foo")
@@ -515,3 +521,602 @@ func TestDax_GetXxxConn(t *testing.T) {
 	assert.True(t, barErr.IsOk())
 	assert.Equal(t, reflect.TypeOf(barConn).String(), "*sabi.BarDaxConn")
 }
+
+type CtxKey struct{}
+
+type BazDaxConn struct {
+	Ctx context.Context
+}
+
+func (conn *BazDaxConn) Commit() Err {
+	logs.PushBack("BazDaxConn#Commit")
+	return Ok()
+}
+
+func (conn *BazDaxConn) Rollback() {
+	logs.PushBack("BazDaxConn#Rollback")
+}
+
+func (conn *BazDaxConn) Close() {
+	logs.PushBack("BazDaxConn#Close")
+}
+
+func (conn *BazDaxConn) CommitCtx(ctx context.Context) Err {
+	logs.PushBack("BazDaxConn#CommitCtx:" + ctx.Value(CtxKey{}).(string))
+	return Ok()
+}
+
+func (conn *BazDaxConn) RollbackCtx(ctx context.Context) {
+	if ctx.Err() != nil {
+		logs.PushBack("BazDaxConn#RollbackCtx:" + ctx.Err().Error())
+		return
+	}
+	logs.PushBack("BazDaxConn#RollbackCtx:" + ctx.Value(CtxKey{}).(string))
+}
+
+func (conn *BazDaxConn) CloseCtx(ctx context.Context) {
+	if ctx.Err() != nil {
+		logs.PushBack("BazDaxConn#CloseCtx:" + ctx.Err().Error())
+		return
+	}
+	logs.PushBack("BazDaxConn#CloseCtx:" + ctx.Value(CtxKey{}).(string))
+}
+
+type BazDaxSrc struct{}
+
+func (ds BazDaxSrc) CreateDaxConn() (DaxConn, Err) {
+	return &BazDaxConn{Ctx: context.Background()}, Ok()
+}
+
+func (ds BazDaxSrc) CreateDaxConnCtx(ctx context.Context) (DaxConn, Err) {
+	return &BazDaxConn{Ctx: ctx}, Ok()
+}
+
+func TestDaxBase_GetDaxConn_withContextDaxSrc(t *testing.T) {
+	Clear()
+	defer Clear()
+
+	base := NewDaxBase()
+	base.AddLocalDaxSrc("baz", BazDaxSrc{})
+
+	ctx := context.WithValue(context.Background(), CtxKey{}, "v")
+	base.begin(ctx, false)
+	assert.Equal(t, base.Context().Value(CtxKey{}), "v")
+
+	conn, err := base.GetDaxConn("baz")
+	assert.True(t, err.IsOk())
+	assert.Equal(t, conn.(*BazDaxConn).Ctx, base.Context())
+
+	err = base.commit()
+	assert.True(t, err.IsOk())
+	base.rollback()
+	base.close()
+
+	assert.Equal(t, logs.Len(), 3)
+	assert.Equal(t, logs.Front().Value, "BazDaxConn#CommitCtx:v")
+	assert.Equal(t, logs.Front().Next().Value, "BazDaxConn#RollbackCtx:v")
+	assert.Equal(t, logs.Back().Value, "BazDaxConn#CloseCtx:v")
+
+	assert.Equal(t, base.Context(), context.Background())
+}
+
+func TestDaxBase_rollbackAndClose_contextIsCanceled(t *testing.T) {
+	Clear()
+	defer Clear()
+
+	base := NewDaxBase()
+	base.AddLocalDaxSrc("baz", BazDaxSrc{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ctx = context.WithValue(ctx, CtxKey{}, "v")
+	base.begin(ctx, false)
+
+	_, err := base.GetDaxConn("baz")
+	assert.True(t, err.IsOk())
+
+	cancel()
+
+	base.rollback()
+	base.close()
+
+	assert.Equal(t, logs.Len(), 2)
+	assert.Equal(t, logs.Front().Value, "BazDaxConn#RollbackCtx:v")
+	assert.Equal(t, logs.Back().Value, "BazDaxConn#CloseCtx:v")
+}
+
+func TestDaxBase_GetDaxConn_contextIsCanceled(t *testing.T) {
+	Clear()
+	defer Clear()
+
+	base := NewDaxBase()
+	base.AddLocalDaxSrc("baz", BazDaxSrc{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	base.begin(ctx, false)
+	cancel()
+
+	conn, err := base.GetDaxConn("baz")
+	assert.Nil(t, conn)
+	switch err.Reason().(type) {
+	case FailToCreateDaxConn:
+		assert.Equal(t, err.Get("Name"), "baz")
+		assert.Equal(t, err.Cause(), context.Canceled)
+	default:
+		assert.Fail(t, err.Error())
+	}
+}
+
+type QuxDaxConn struct{}
+
+func (conn *QuxDaxConn) Prepare() Err {
+	if WillFailToPrepareQuxDaxConn {
+		return ErrBy(InvalidDaxConn{})
+	}
+	logs.PushBack("QuxDaxConn#Prepare")
+	return Ok()
+}
+
+func (conn *QuxDaxConn) Commit() Err {
+	logs.PushBack("QuxDaxConn#Commit")
+	return Ok()
+}
+
+func (conn *QuxDaxConn) Rollback() {
+	logs.PushBack("QuxDaxConn#Rollback")
+}
+
+func (conn *QuxDaxConn) Close() {
+	logs.PushBack("QuxDaxConn#Close")
+}
+
+func (conn *QuxDaxConn) ForceBack() {
+	logs.PushBack("QuxDaxConn#ForceBack")
+}
+
+func (conn *QuxDaxConn) SetReadOnly() Err {
+	if WillFailToSetReadOnlyQuxDaxConn {
+		return ErrBy(InvalidDaxConn{})
+	}
+	logs.PushBack("QuxDaxConn#SetReadOnly")
+	return Ok()
+}
+
+type QuxDaxSrc struct{}
+
+func (ds QuxDaxSrc) CreateDaxConn() (DaxConn, Err) {
+	return &QuxDaxConn{}, Ok()
+}
+
+func TestDaxBase_commit_twoPhase(t *testing.T) {
+	Clear()
+	defer Clear()
+
+	base := NewDaxBase()
+
+	base.AddLocalDaxSrc("foo", FooDaxSrc{})
+	base.AddLocalDaxSrc("qux", QuxDaxSrc{})
+	base.begin(context.Background(), false)
+
+	_, fooErr := base.GetDaxConn("foo")
+	assert.True(t, fooErr.IsOk())
+
+	_, quxErr := base.GetDaxConn("qux")
+	assert.True(t, quxErr.IsOk())
+
+	err := base.commit()
+	assert.True(t, err.IsOk())
+
+	assert.Equal(t, logs.Len(), 3)
+	assert.Equal(t, logs.Front().Value, "QuxDaxConn#Prepare")
+	assert.Equal(t, logs.Front().Next().Value, "QuxDaxConn#Commit")
+	assert.Equal(t, logs.Back().Value, "FooDaxConn#Commit")
+}
+
+func TestDaxBase_commit_failToPrepare(t *testing.T) {
+	Clear()
+	defer Clear()
+
+	base := NewDaxBase()
+
+	base.AddLocalDaxSrc("foo", FooDaxSrc{})
+	base.AddLocalDaxSrc("qux", QuxDaxSrc{})
+	base.begin(context.Background(), false)
+
+	_, fooErr := base.GetDaxConn("foo")
+	assert.True(t, fooErr.IsOk())
+
+	_, quxErr := base.GetDaxConn("qux")
+	assert.True(t, quxErr.IsOk())
+
+	WillFailToPrepareQuxDaxConn = true
+
+	err := base.commit()
+	switch err.Reason().(type) {
+	case FailToPrepareDaxConn:
+		m := err.Get("Errors").(map[string]Err)
+		assert.Equal(t, len(m), 1)
+		assert.Equal(t, m["qux"].ReasonName(), "InvalidDaxConn")
+	default:
+		assert.Fail(t, err.Error())
+	}
+
+	assert.Equal(t, logs.Len(), 0)
+}
+
+func TestDaxBase_commit_forceBack(t *testing.T) {
+	Clear()
+	defer Clear()
+
+	base := NewDaxBase()
+
+	base.AddLocalDaxSrc("foo", FooDaxSrc{})
+	base.AddLocalDaxSrc("bar", &BarDaxSrc{})
+	base.AddLocalDaxSrc("qux", QuxDaxSrc{})
+	base.begin(context.Background(), false)
+
+	_, fooErr := base.GetDaxConn("foo")
+	assert.True(t, fooErr.IsOk())
+
+	_, barErr := base.GetDaxConn("bar")
+	assert.True(t, barErr.IsOk())
+
+	_, quxErr := base.GetDaxConn("qux")
+	assert.True(t, quxErr.IsOk())
+
+	WillFailToCommitFooDaxConn = true
+
+	err := base.commit()
+	switch err.Reason().(type) {
+	case FailToCommitDaxConn:
+		m := err.Get("Errors").(map[string]Err)
+		assert.Equal(t, len(m), 1)
+		assert.Equal(t, m["foo"].ReasonName(), "InvalidDaxConn")
+		assert.Equal(t, err.Get("Committed"), []string{"bar", "qux"})
+		assert.Equal(t, err.Get("ForcedBack"), []string{"qux"})
+	default:
+		assert.Fail(t, err.Error())
+	}
+
+	assert.Equal(t, logs.Len(), 4)
+	assert.Equal(t, logs.Front().Value, "QuxDaxConn#Prepare")
+	assert.Equal(t, logs.Front().Next().Value, "QuxDaxConn#Commit")
+	assert.Equal(t, logs.Front().Next().Next().Value, "BarDaxConn#Commit")
+	assert.Equal(t, logs.Back().Value, "QuxDaxConn#ForceBack")
+}
+
+type OrderedFooDaxSrc struct {
+	FooDaxSrc
+	Order int
+}
+
+func (ds OrderedFooDaxSrc) CommitOrder() int {
+	return ds.Order
+}
+
+func TestAddGlobalDaxSrcWithOrder(t *testing.T) {
+	Clear()
+	defer Clear()
+
+	AddGlobalDaxSrcWithOrder("foo", FooDaxSrc{}, 2)
+	AddGlobalDaxSrc("bar", &BarDaxSrc{})
+
+	assert.Equal(t, len(globalDaxSrcMap), 2)
+	assert.Equal(t, globalDaxSrcOrderMap, map[string]int{"foo": 2})
+
+	AddGlobalDaxSrc("foo", FooDaxSrc{})
+
+	assert.Equal(t, len(globalDaxSrcMap), 2)
+	assert.Equal(t, len(globalDaxSrcOrderMap), 0)
+
+	FixGlobalDaxSrcs()
+	AddGlobalDaxSrcWithOrder("baz", BazDaxSrc{}, 1)
+
+	assert.Equal(t, len(globalDaxSrcMap), 2)
+	assert.Equal(t, len(globalDaxSrcOrderMap), 0)
+}
+
+func TestDaxBase_commit_ordered(t *testing.T) {
+	Clear()
+	defer Clear()
+
+	AddGlobalDaxSrcWithOrder("bar", &BarDaxSrc{}, 1)
+
+	base := NewDaxBase()
+	base.AddLocalDaxSrcWithOrder("foo", FooDaxSrc{}, 2)
+	base.AddLocalDaxSrc("qux", QuxDaxSrc{})
+	base.begin(context.Background(), false)
+
+	_, fooErr := base.GetDaxConn("foo")
+	assert.True(t, fooErr.IsOk())
+
+	_, barErr := base.GetDaxConn("bar")
+	assert.True(t, barErr.IsOk())
+
+	_, quxErr := base.GetDaxConn("qux")
+	assert.True(t, quxErr.IsOk())
+
+	err := base.commit()
+	assert.True(t, err.IsOk())
+	base.rollback()
+	base.close()
+
+	var a []string
+	for e := logs.Front(); e != nil; e = e.Next() {
+		a = append(a, e.Value.(string))
+	}
+	assert.Equal(t, a, []string{
+		"QuxDaxConn#Prepare",
+		"QuxDaxConn#Commit",
+		"BarDaxConn#Commit",
+		"FooDaxConn#Commit",
+		"QuxDaxConn#Rollback",
+		"BarDaxConn#Rollback",
+		"FooDaxConn#Rollback",
+		"QuxDaxConn#Close",
+		"BarDaxConn#Close",
+		"FooDaxConn#Close",
+	})
+}
+
+func TestDaxBase_commit_orderedOverTwoPhase(t *testing.T) {
+	Clear()
+	defer Clear()
+
+	base := NewDaxBase()
+	base.AddLocalDaxSrcWithOrder("foo", FooDaxSrc{}, 1)
+	base.AddLocalDaxSrcWithOrder("qux", QuxDaxSrc{}, 2)
+	base.begin(context.Background(), false)
+
+	_, fooErr := base.GetDaxConn("foo")
+	assert.True(t, fooErr.IsOk())
+
+	_, quxErr := base.GetDaxConn("qux")
+	assert.True(t, quxErr.IsOk())
+
+	err := base.commit()
+	assert.True(t, err.IsOk())
+
+	var a []string
+	for e := logs.Front(); e != nil; e = e.Next() {
+		a = append(a, e.Value.(string))
+	}
+	assert.Equal(t, a, []string{
+		"QuxDaxConn#Prepare",
+		"FooDaxConn#Commit",
+		"QuxDaxConn#Commit",
+	})
+}
+
+func TestDaxBase_commit_orderedByDaxSrc(t *testing.T) {
+	Clear()
+	defer Clear()
+
+	base := NewDaxBase()
+	base.AddLocalDaxSrc("foo", OrderedFooDaxSrc{Order: -1})
+	base.AddLocalDaxSrc("bar", &BarDaxSrc{})
+	base.AddLocalDaxSrcWithOrder("qux", QuxDaxSrc{}, 1)
+	base.begin(context.Background(), false)
+
+	_, fooErr := base.GetDaxConn("foo")
+	assert.True(t, fooErr.IsOk())
+
+	_, barErr := base.GetDaxConn("bar")
+	assert.True(t, barErr.IsOk())
+
+	_, quxErr := base.GetDaxConn("qux")
+	assert.True(t, quxErr.IsOk())
+
+	WillFailToCommitFooDaxConn = true
+
+	err := base.commit()
+	switch err.Reason().(type) {
+	case FailToCommitDaxConn:
+		assert.Equal(t, err.Get("Committed"), []string{})
+		assert.Equal(t, err.Get("ForcedBack"), []string{})
+	default:
+		assert.Fail(t, err.Error())
+	}
+
+	assert.Equal(t, logs.Len(), 1)
+	assert.Equal(t, logs.Front().Value, "QuxDaxConn#Prepare")
+}
+
+func TestDaxBase_GetDaxConn_readOnly(t *testing.T) {
+	Clear()
+	defer Clear()
+
+	base := NewDaxBase()
+	base.AddLocalDaxSrc("foo", FooDaxSrc{})
+	base.AddLocalDaxSrc("qux", QuxDaxSrc{})
+	base.begin(context.Background(), true)
+
+	_, err := base.GetDaxConn("foo")
+	assert.True(t, err.IsOk())
+
+	_, err = base.GetDaxConn("qux")
+	assert.True(t, err.IsOk())
+
+	assert.Equal(t, GetLogs(), []string{"QuxDaxConn#SetReadOnly"})
+
+	base.close()
+	assert.False(t, base.isReadOnly)
+}
+
+func TestDaxBase_GetDaxConn_failToSetReadOnly(t *testing.T) {
+	Clear()
+	defer Clear()
+
+	base := NewDaxBase()
+	base.AddLocalDaxSrc("qux", QuxDaxSrc{})
+	base.begin(context.Background(), true)
+
+	WillFailToSetReadOnlyQuxDaxConn = true
+
+	conn, err := base.GetDaxConn("qux")
+	assert.Nil(t, conn)
+	switch err.Reason().(type) {
+	case FailToCreateDaxConn:
+		assert.Equal(t, err.Get("Name"), "qux")
+		assert.Equal(t, err.Cause().(Err).ReasonName(), "InvalidDaxConn")
+	default:
+		assert.Fail(t, err.Error())
+	}
+
+	assert.Equal(t, GetLogs(), []string{
+		"QuxDaxConn#Rollback",
+		"QuxDaxConn#Close",
+	})
+}
+
+func TestGetDaxConn(t *testing.T) {
+	Clear()
+	defer Clear()
+
+	base := NewDaxBase()
+	base.AddLocalDaxSrc("foo", FooDaxSrc{})
+	base.AddLocalDaxSrc("qux", QuxDaxSrc{})
+
+	fooConn, err := GetDaxConn[*FooDaxConn](base, "foo")
+	assert.True(t, err.IsOk())
+	assert.Equal(t, reflect.TypeOf(fooConn).String(), "*sabi.FooDaxConn")
+
+	prepConn, err := GetDaxConn[PreparableDaxConn](base, "qux")
+	assert.True(t, err.IsOk())
+	assert.Equal(t, reflect.TypeOf(prepConn).String(), "*sabi.QuxDaxConn")
+}
+
+func TestGetDaxConn_daxConnIsNotExpectedType(t *testing.T) {
+	Clear()
+	defer Clear()
+
+	base := NewDaxBase()
+	base.AddLocalDaxSrc("foo", FooDaxSrc{})
+
+	conn, err := GetDaxConn[*BarDaxConn](base, "foo")
+	assert.Nil(t, conn)
+	switch err.Reason().(type) {
+	case DaxConnIsNotExpectedType:
+		assert.Equal(t, err.Get("Name"), "foo")
+		assert.Equal(t, err.Get("Expected"), "*sabi.BarDaxConn")
+		assert.Equal(t, err.Get("Actual"), "*sabi.FooDaxConn")
+	default:
+		assert.Fail(t, err.Error())
+	}
+
+	prepConn, err := GetDaxConn[PreparableDaxConn](base, "foo")
+	assert.Nil(t, prepConn)
+	switch err.Reason().(type) {
+	case DaxConnIsNotExpectedType:
+		assert.Equal(t, err.Get("Expected"), "sabi.PreparableDaxConn")
+	default:
+		assert.Fail(t, err.Error())
+	}
+}
+
+func TestGetDaxConn_daxSrcIsNotFound(t *testing.T) {
+	Clear()
+	defer Clear()
+
+	base := NewDaxBase()
+
+	conn, err := GetDaxConn[*FooDaxConn](base, "foo")
+	assert.Nil(t, conn)
+	switch err.Reason().(type) {
+	case DaxSrcIsNotFound:
+		assert.Equal(t, err.Get("Name"), "foo")
+	default:
+		assert.Fail(t, err.Error())
+	}
+}
+
+func TestCheckDaxConnType(t *testing.T) {
+	Clear()
+	defer Clear()
+
+	AddGlobalDaxSrc("foo", FooDaxSrc{})
+
+	base := NewDaxBase()
+	base.AddLocalDaxSrc("bar", &BarDaxSrc{})
+
+	err := CheckDaxConnType[*FooDaxConn](base, "foo")
+	assert.True(t, err.IsOk())
+
+	err = CheckDaxConnType[*BarDaxConn](base, "bar")
+	assert.True(t, err.IsOk())
+
+	assert.Equal(t, len(base.daxConnMap), 0)
+	assert.Equal(t, GetLogs(), []string{
+		"FooDaxConn#Rollback",
+		"FooDaxConn#Close",
+		"BarDaxConn#Rollback",
+		"BarDaxConn#Close",
+	})
+}
+
+func TestCheckDaxConnType_daxConnIsNotExpectedType(t *testing.T) {
+	Clear()
+	defer Clear()
+
+	base := NewDaxBase()
+	base.AddLocalDaxSrc("foo", FooDaxSrc{})
+
+	err := CheckDaxConnType[*BarDaxConn](base, "foo")
+	switch err.Reason().(type) {
+	case DaxConnIsNotExpectedType:
+		assert.Equal(t, err.Get("Name"), "foo")
+		assert.Equal(t, err.Get("Expected"), "*sabi.BarDaxConn")
+		assert.Equal(t, err.Get("Actual"), "*sabi.FooDaxConn")
+	default:
+		assert.Fail(t, err.Error())
+	}
+
+	assert.Equal(t, GetLogs(), []string{
+		"FooDaxConn#Rollback",
+		"FooDaxConn#Close",
+	})
+}
+
+func TestCheckDaxConnType_daxSrcIsNotFound(t *testing.T) {
+	Clear()
+	defer Clear()
+
+	base := NewDaxBase()
+
+	err := CheckDaxConnType[*FooDaxConn](base, "foo")
+	switch err.Reason().(type) {
+	case DaxSrcIsNotFound:
+		assert.Equal(t, err.Get("Name"), "foo")
+	default:
+		assert.Fail(t, err.Error())
+	}
+}
+
+func TestCheckDaxConnType_failToCreateDaxConn(t *testing.T) {
+	Clear()
+	defer Clear()
+
+	base := NewDaxBase()
+	base.AddLocalDaxSrc("foo", FooDaxSrc{})
+
+	WillFailToCreateFooDaxConn = true
+
+	err := CheckDaxConnType[*FooDaxConn](base, "foo")
+	switch err.Reason().(type) {
+	case FailToCreateDaxConn:
+		assert.Equal(t, err.Get("Name"), "foo")
+	default:
+		assert.Fail(t, err.Error())
+	}
+}
+
+func TestCheckDaxConnType_daxIsNotDaxBase(t *testing.T) {
+	Clear()
+	defer Clear()
+
+	err := CheckDaxConnType[*FooDaxConn](NotDaxBase{}, "foo")
+	switch err.Reason().(type) {
+	case DaxBaseIsNotFound:
+	default:
+		assert.Fail(t, err.Error())
+	}
+}
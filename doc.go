@@ -72,6 +72,31 @@ And the following code is an example of a test case.
     assert.Equal(t, m["greeting"], "Hello, World")
   }
 
+A dax using a bare map ignores commit and rollback.
+To verify that a failed transaction leaves no changes, a dax can use memdax.MemDaxConn instead, of which writes are reflected to a memdax.Store only when the transaction is committed.
+
+  type memDax struct {
+    memdax.MemDax[string, string]
+  }
+
+  func (dax memDax) Say(greeting string) sabi.Err {
+    conn, err := dax.GetMemDaxConn("mem")
+    if !err.IsOk() {
+      return err
+    }
+    return conn.Set("greeting", greeting)
+  }
+
+  store := memdax.NewStore[string, string]()
+  base := sabi.NewDaxBase()
+  base.AddLocalDaxSrc("mem", memdax.NewMemDaxSrc(store))
+
+Likewise, a logic which needs current time, new IDs or random numbers can get them through a dax using sysdax.ClockDaxConn, sysdax.IdDaxConn and sysdax.RandDaxConn instead of calling time.Now and so on directly, and a test can make its results deterministic by registering DaxSrc with a fixed clock, a sequential ID generator and a seeded random number generator.
+
+  base.AddLocalDaxSrc("clock", sysdax.NewClockDaxSrc(sysdax.FixedClock{Time: t0}))
+  base.AddLocalDaxSrc("id", sysdax.NewIdDaxSrc(sysdax.NewSeqIdGenerator("id-")))
+  base.AddLocalDaxSrc("rand", sysdax.NewSeededRandDaxSrc(1))
+
 Dax for real data access
 
 An actual dax ordinarily consists of multiple sub dax by input sources and output destinations.
@@ -79,20 +104,20 @@ An actual dax ordinarily consists of multiple sub dax by input sources and outpu
 The following code is an example of a dax with no external data source.
 This dax outputs a greeting to standard output.
 
-  type SayConsoleDax struct {}
-
-  type (
-    FailToPrint struct {}
-  )
+  type SayConsoleDax struct {
+    writerdax.WriterDax
+  }
 
   func (dax SayConsoleDax) Say(text string) sabi.Err {
-    _, e := fmt.Println(text)
-    if e != nil {
-      return sabi.ErrBy(FailToPrint{}, e)
+    conn, err := dax.GetWriterDaxConn("console")
+    if !err.IsOk() {
+      return err
     }
-    return sabi.Ok()
+    return conn.Println(text)
   }
 
+The output is buffered in the WriterDaxConn and is written to standard output only when the transaction is committed, so nothing is output if the transaction is rollbacked.
+
 And the following code is an example of a dax with an external data source.
 This dax accesses to a database and provides an implementation of GetName method of GreetDax.
 
@@ -100,35 +125,16 @@ This dax accesses to a database and provides an implementation of GetName method
     sqldax.SqlDax
   }
 
-  type (
-    FailToCreateStmt struct {}
-    NoUser struct {}
-    FailToQueryUserName struct {}
-  )
-
   func (dax UserSqlDax) GetName() (string, sabi.Err) {
     conn, err := dax.GetSqlDaxConn("sql")
     if !err.IsOk() {
-      return err
-    }
-    stmt, err := conn.Prepare("SELECT username FROM users LIMIT 1")
-    if err != nil {
-      return "", sabi.ErrBy(FailToCreateStmt{})
-    }
-    defer stmt.Close()
-
-    var username string
-    err = stmt.QueryRow().Scan(&username)
-    switch {
-    case err == sql.ErrNoRows:
-      return "", sabi.ErrBy(NoUser{})
-    case err != nil:
-      return "", sabi.ErrBy(FailToQueryUserName{})
-    default:
-      return username, sabi.Ok()
+      return "", err
     }
+    return sqldax.QueryOne[string](conn, "SELECT username FROM users LIMIT 1")
   }
 
+The prepared statement of the query is cached in the SqlDaxConn, and an Err having the reason sqldax.NoRowsFound is returned if no user is found.
+
 Mapping dax interface and implementations
 
 A dax interface can be related to multiple dax implementations.
@@ -143,7 +149,7 @@ In the following code, GetName method of GreetDax interface is corresponded to t
       SayConsoleDax
     } {
       UserSqlDax: UserSqlDax{SqlDax: sqldax.NewSqlDax(base)},
-      SayConsoleDax: SayConsoleDax{},
+      SayConsoleDax: SayConsoleDax{WriterDax: writerdax.NewWriterDax(base)},
     }
 
     return sabi.NewProc[GreetDax](base, dax)
@@ -155,9 +161,15 @@ The following code implements a main function which execute a GreetLogic.
 GreetLogic is executed in a transaction process by GreetProc#RunTxn, so the database update can be rollbacked when an error is occured.
 
 The init function registers a SqlDaxSrc which creates a DaxConn which connects to a database. The SqlDaxConn is registerd with a name "sql" and is obtained by GetSqlDaxConn("sql") in UserSqlDax#GetName.
+It also registers a WriterDaxSrc for standard output with a name "console", which is used in SayConsoleDax#Say.
 
   func init() {
-    sabi.AddGlobalDaxSrc("sql", sqldax.SqlDaxSrc{driver: "driver-name", dsn: "ds-name"})
+    ds, err := sqldax.OpenSqlDaxSrc("driver-name", "ds-name")
+    if !err.IsOk() {
+      os.Exit(1)
+    }
+    sabi.AddGlobalDaxSrc("sql", ds)
+    sabi.AddGlobalDaxSrc("console", writerdax.NewConsoleDaxSrc())
     sabi.FixGlobalDaxSrcs()
   }
 
@@ -0,0 +1,277 @@
+// Copyright (C) 2023 Takayuki Sato. All Rights Reserved.
+// This program is free software under MIT License.
+// See the file LICENSE in this distribution for more details.
+
+// Package eventbus provides an in-process event bus and a DaxSrc and a
+// DaxConn of sabi which deliver events published in a transaction to
+// subscribers only after the transaction is committed.
+package eventbus
+
+import (
+	"github.com/sttk-go/sabi"
+	"sync"
+)
+
+type /* error reasons */ (
+	// FailToHandleEvent is an error reason which indicates that a handler
+	// failed to handle an event.
+	// The field Topic is the topic of the event.
+	// The cause of an Err having this reason is an Err returned by the
+	// handler.
+	// An Err having this reason is created when a handler fails, so it is
+	// notified to Err handlers registered with sabi.AddSyncErrHandler or
+	// sabi.AddAsyncErrHandler.
+	FailToHandleEvent struct {
+		Topic string
+	}
+)
+
+// Event is a structure type which represents an event published through an
+// EventBusDaxConn.
+type Event struct {
+	Topic   string
+	Payload any
+}
+
+// Handler is a function type which handles an event.
+type Handler func(event Event) sabi.Err
+
+type topicQueue struct {
+	events  []Event
+	running bool
+}
+
+// Bus is a structure type which delivers events to handlers subscribing
+// their topics.
+// Sync handlers are called in the goroutine committing a transaction, in the
+// order of the events published in the transaction.
+// Async handlers are called in a goroutine per topic.
+// Events of a same topic published in a transaction are queued at once on
+// commit, so they are delivered to async handlers in the order in which the
+// transactions are committed, and are not interleaved with events of other
+// transactions.
+type Bus struct {
+	syncHandlers  map[string][]Handler
+	asyncHandlers map[string][]Handler
+	queues        map[string]*topicQueue
+	handlerMutex  sync.RWMutex
+	queueMutex    sync.Mutex
+	wg            sync.WaitGroup
+}
+
+// NewBus is a function which creates a new Bus.
+func NewBus() *Bus {
+	return &Bus{
+		syncHandlers:  make(map[string][]Handler),
+		asyncHandlers: make(map[string][]Handler),
+		queues:        make(map[string]*topicQueue),
+	}
+}
+
+// Subscribe is a method which registers a handler which is called
+// synchronously for events of a specified topic.
+func (bus *Bus) Subscribe(topic string, handler Handler) {
+	bus.handlerMutex.Lock()
+	defer bus.handlerMutex.Unlock()
+
+	bus.syncHandlers[topic] = append(bus.syncHandlers[topic], handler)
+}
+
+// SubscribeAsync is a method which registers a handler which is called
+// asynchronously for events of a specified topic.
+func (bus *Bus) SubscribeAsync(topic string, handler Handler) {
+	bus.handlerMutex.Lock()
+	defer bus.handlerMutex.Unlock()
+
+	bus.asyncHandlers[topic] = append(bus.asyncHandlers[topic], handler)
+}
+
+// Wait is a method which waits until all events passed to async handlers
+// are handled.
+func (bus *Bus) Wait() {
+	bus.wg.Wait()
+}
+
+func (bus *Bus) deliver(events []Event) {
+	asyncEvents := make([]Event, 0, len(events))
+
+	for _, event := range events {
+		bus.handlerMutex.RLock()
+		handlers := bus.syncHandlers[event.Topic]
+		hasAsync := len(bus.asyncHandlers[event.Topic]) > 0
+		bus.handlerMutex.RUnlock()
+
+		for _, handler := range handlers {
+			handle(handler, event)
+		}
+		if hasAsync {
+			asyncEvents = append(asyncEvents, event)
+		}
+	}
+
+	bus.enqueue(asyncEvents)
+}
+
+func (bus *Bus) enqueue(events []Event) {
+	if len(events) == 0 {
+		return
+	}
+
+	bus.queueMutex.Lock()
+	defer bus.queueMutex.Unlock()
+
+	for _, event := range events {
+		q := bus.queues[event.Topic]
+		if q == nil {
+			q = &topicQueue{}
+			bus.queues[event.Topic] = q
+		}
+
+		bus.wg.Add(1)
+		q.events = append(q.events, event)
+
+		if !q.running {
+			q.running = true
+			go bus.drain(q)
+		}
+	}
+}
+
+func (bus *Bus) drain(q *topicQueue) {
+	for {
+		bus.queueMutex.Lock()
+		if len(q.events) == 0 {
+			q.running = false
+			bus.queueMutex.Unlock()
+			return
+		}
+		event := q.events[0]
+		q.events = q.events[1:]
+		bus.queueMutex.Unlock()
+
+		bus.handlerMutex.RLock()
+		handlers := bus.asyncHandlers[event.Topic]
+		bus.handlerMutex.RUnlock()
+
+		for _, handler := range handlers {
+			handle(handler, event)
+		}
+		bus.wg.Done()
+	}
+}
+
+func handle(handler Handler, event Event) {
+	err := handler(event)
+	if !err.IsOk() {
+		sabi.ErrBy(FailToHandleEvent{Topic: event.Topic}, err)
+	}
+}
+
+// EventBusDaxSrc is a structure type which implements sabi.DaxSrc and
+// creates EventBusDaxConn.
+// This also implements sabi.OrderedDaxSrc and its commit order is
+// sabi.AfterCommitOrder.
+type EventBusDaxSrc struct {
+	bus *Bus
+}
+
+// NewEventBusDaxSrc is a function which creates a new EventBusDaxSrc which
+// delivers events with a specified Bus.
+func NewEventBusDaxSrc(bus *Bus) EventBusDaxSrc {
+	return EventBusDaxSrc{bus: bus}
+}
+
+// CreateDaxConn is a method which creates a new EventBusDaxConn.
+func (ds EventBusDaxSrc) CreateDaxConn() (sabi.DaxConn, sabi.Err) {
+	return &EventBusDaxConn{bus: ds.bus}, sabi.Ok()
+}
+
+// CommitOrder is a method which returns sabi.AfterCommitOrder.
+func (ds EventBusDaxSrc) CommitOrder() int {
+	return sabi.AfterCommitOrder
+}
+
+// EventBusDaxConn is a structure type which implements sabi.DaxConn, and
+// collects events published in a transaction.
+// Collected events are delivered to handlers on #Commit, and are discarded
+// on #Rollback.
+// Failures of handlers do not make the transaction fail because the other
+// DaxConn have already committed.
+// This also implements sabi.ReadOnlyDaxConn.
+type EventBusDaxConn struct {
+	bus      *Bus
+	events   []Event
+	readOnly bool
+	mutex    sync.Mutex
+}
+
+// Publish is a method which collects an event of a specified topic and a
+// specified payload to be delivered after commit.
+// In a read-only transaction, this method returns an Err having the reason
+// sabi.TxnIsReadOnly.
+func (conn *EventBusDaxConn) Publish(topic string, payload any) sabi.Err {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	if conn.readOnly {
+		return sabi.ErrBy(sabi.TxnIsReadOnly{})
+	}
+
+	conn.events = append(conn.events, Event{Topic: topic, Payload: payload})
+	return sabi.Ok()
+}
+
+// Commit is a method which delivers collected events to handlers.
+func (conn *EventBusDaxConn) Commit() sabi.Err {
+	conn.mutex.Lock()
+	events := conn.events
+	conn.events = nil
+	conn.mutex.Unlock()
+
+	conn.bus.deliver(events)
+	return sabi.Ok()
+}
+
+// Rollback is a method which discards collected events.
+func (conn *EventBusDaxConn) Rollback() {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	conn.events = nil
+}
+
+// Close is a method which discards collected events.
+func (conn *EventBusDaxConn) Close() {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	conn.events = nil
+}
+
+// SetReadOnly is a method which makes this EventBusDaxConn refuse
+// publishing.
+func (conn *EventBusDaxConn) SetReadOnly() sabi.Err {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	conn.readOnly = true
+	return sabi.Ok()
+}
+
+// EventBusDax is a structure type which is embedded in a dax structure
+// publishing events, and provides a method to get an EventBusDaxConn.
+type EventBusDax struct {
+	sabi.Dax
+}
+
+// NewEventBusDax is a function which creates a new EventBusDax with a
+// specified Dax.
+func NewEventBusDax(dax sabi.Dax) EventBusDax {
+	return EventBusDax{Dax: dax}
+}
+
+// GetEventBusDaxConn is a method which gets an EventBusDaxConn by a specified
+// name.
+func (dax EventBusDax) GetEventBusDaxConn(name string) (*EventBusDaxConn, sabi.Err) {
+	return sabi.GetDaxConn[*EventBusDaxConn](dax.Dax, name)
+}
@@ -0,0 +1,226 @@
+package eventbus
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/sttk-go/sabi"
+	"sync"
+	"testing"
+	"time"
+)
+
+type (
+	FailToRun    struct{}
+	FailToHandle struct{}
+)
+
+var (
+	logMutex sync.Mutex
+	logs     []string
+	notified []sabi.Err
+)
+
+func init() {
+	sabi.AddSyncErrHandler(func(err sabi.Err, tm time.Time) {
+		switch err.Reason().(type) {
+		case FailToHandleEvent:
+			logMutex.Lock()
+			defer logMutex.Unlock()
+			notified = append(notified, err)
+		}
+	})
+	sabi.FixErrCfgs()
+}
+
+func clearLogs() {
+	logMutex.Lock()
+	defer logMutex.Unlock()
+	logs = nil
+	notified = nil
+}
+
+func appendLog(s string) {
+	logMutex.Lock()
+	defer logMutex.Unlock()
+	logs = append(logs, s)
+}
+
+type UserDax struct {
+	EventBusDax
+}
+
+func (dax UserDax) AddUser(name string) sabi.Err {
+	conn, err := dax.GetEventBusDaxConn("bus")
+	if !err.IsOk() {
+		return err
+	}
+	return conn.Publish("user-added", name)
+}
+
+func newUserProc(bus *Bus) sabi.Proc[UserDax] {
+	base := sabi.NewDaxBase()
+	base.AddLocalDaxSrc("bus", NewEventBusDaxSrc(bus))
+	return sabi.NewProc[UserDax](base, UserDax{NewEventBusDax(base)})
+}
+
+func TestEventBusDaxConn_deliverAfterCommit(t *testing.T) {
+	clearLogs()
+	defer clearLogs()
+
+	bus := NewBus()
+	bus.Subscribe("user-added", func(event Event) sabi.Err {
+		appendLog("handle:" + event.Payload.(string))
+		return sabi.Ok()
+	})
+	bus.Subscribe("other", func(event Event) sabi.Err {
+		appendLog("other")
+		return sabi.Ok()
+	})
+
+	err := newUserProc(bus).RunTxn(func(dax UserDax) sabi.Err {
+		err := dax.AddUser("alice")
+		if !err.IsOk() {
+			return err
+		}
+		appendLog("logic")
+		return dax.AddUser("bob")
+	})
+	assert.True(t, err.IsOk())
+	assert.Equal(t, logs, []string{
+		"logic", "handle:alice", "handle:bob",
+	})
+}
+
+func TestEventBusDaxConn_discardOnRollback(t *testing.T) {
+	clearLogs()
+	defer clearLogs()
+
+	bus := NewBus()
+	bus.Subscribe("user-added", func(event Event) sabi.Err {
+		appendLog("handle:" + event.Payload.(string))
+		return sabi.Ok()
+	})
+	bus.SubscribeAsync("user-added", func(event Event) sabi.Err {
+		appendLog("async:" + event.Payload.(string))
+		return sabi.Ok()
+	})
+
+	err := newUserProc(bus).RunTxn(func(dax UserDax) sabi.Err {
+		err := dax.AddUser("alice")
+		if !err.IsOk() {
+			return err
+		}
+		return sabi.ErrBy(FailToRun{})
+	})
+	assert.Equal(t, err.ReasonName(), "FailToRun")
+
+	bus.Wait()
+	assert.Nil(t, logs)
+}
+
+func TestEventBusDaxConn_asyncOrderPerTopic(t *testing.T) {
+	bus := NewBus()
+
+	var received []int
+	bus.SubscribeAsync("user-added", func(event Event) sabi.Err {
+		time.Sleep(time.Microsecond)
+		received = append(received, event.Payload.(int))
+		return sabi.Ok()
+	})
+
+	base := sabi.NewDaxBase()
+	base.AddLocalDaxSrc("bus", NewEventBusDaxSrc(bus))
+	proc := sabi.NewProc[EventBusDax](base, NewEventBusDax(base))
+
+	expected := make([]int, 0, 100)
+	for i := 0; i < 50; i++ {
+		err := proc.RunTxn(func(dax EventBusDax) sabi.Err {
+			conn, err := dax.GetEventBusDaxConn("bus")
+			if !err.IsOk() {
+				return err
+			}
+			err = conn.Publish("user-added", i*2)
+			if !err.IsOk() {
+				return err
+			}
+			return conn.Publish("user-added", i*2+1)
+		})
+		assert.True(t, err.IsOk())
+		expected = append(expected, i*2, i*2+1)
+	}
+
+	bus.Wait()
+	assert.Equal(t, received, expected)
+}
+
+func TestBus_asyncEventsOfTxnAreNotInterleaved(t *testing.T) {
+	bus := NewBus()
+	bus.Subscribe("user-added", func(event Event) sabi.Err {
+		time.Sleep(time.Microsecond)
+		return sabi.Ok()
+	})
+
+	var received []int
+	bus.SubscribeAsync("user-added", func(event Event) sabi.Err {
+		received = append(received, event.Payload.(int))
+		return sabi.Ok()
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		events := make([]Event, 0, 5)
+		for j := 0; j < 5; j++ {
+			events = append(events, Event{Topic: "user-added", Payload: i*5 + j})
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			bus.deliver(events)
+		}()
+	}
+	wg.Wait()
+
+	bus.Wait()
+	assert.Equal(t, len(received), 100)
+	for k := 0; k < len(received); k += 5 {
+		for j := 1; j < 5; j++ {
+			assert.Equal(t, received[k+j], received[k]+j)
+		}
+	}
+}
+
+func TestEventBusDaxConn_handlerFailureIsNotified(t *testing.T) {
+	clearLogs()
+	defer clearLogs()
+
+	bus := NewBus()
+	bus.Subscribe("user-added", func(event Event) sabi.Err {
+		return sabi.ErrBy(FailToHandle{})
+	})
+	bus.Subscribe("user-added", func(event Event) sabi.Err {
+		appendLog("handle:" + event.Payload.(string))
+		return sabi.Ok()
+	})
+	bus.SubscribeAsync("user-added", func(event Event) sabi.Err {
+		return sabi.ErrBy(FailToHandle{})
+	})
+
+	err := newUserProc(bus).RunTxn(func(dax UserDax) sabi.Err {
+		return dax.AddUser("alice")
+	})
+	assert.True(t, err.IsOk())
+
+	bus.Wait()
+	assert.Equal(t, logs, []string{"handle:alice"})
+	assert.Equal(t, len(notified), 2)
+	for _, e := range notified {
+		assert.Equal(t, e.Get("Topic"), "user-added")
+		assert.Equal(t, e.Cause().(sabi.Err).ReasonName(), "FailToHandle")
+	}
+}
+
+func TestEventBusDaxConn_readOnly(t *testing.T) {
+	err := newUserProc(NewBus()).ReadOnly().RunTxn(func(dax UserDax) sabi.Err {
+		return dax.AddUser("alice")
+	})
+	assert.Equal(t, err.ReasonName(), "TxnIsReadOnly")
+}
@@ -175,3 +175,44 @@ func ExampleDax() {
 
 	sabi.Clear()
 }
+
+func ExampleGetDaxConn() {
+	base := sabi.NewDaxBase()
+	base.AddLocalDaxSrc("hoge", FooDaxSrc{})
+
+	conn, err := sabi.GetDaxConn[*FooDaxConn](base, "hoge")
+	fmt.Printf("conn = %v\n", reflect.TypeOf(conn))
+	fmt.Printf("err.IsOk() = %v\n", err.IsOk())
+
+	_, err = sabi.GetDaxConn[*BarDaxConn](base, "hoge")
+	fmt.Printf("err.ReasonName() = %v\n", err.ReasonName())
+	fmt.Printf("err.Get(\"Actual\") = %v\n", err.Get("Actual"))
+
+	// Output:
+	// conn = *sabi_test.FooDaxConn
+	// err.IsOk() = true
+	// err.ReasonName() = DaxConnIsNotExpectedType
+	// err.Get("Actual") = *sabi_test.FooDaxConn
+
+	sabi.Clear()
+}
+
+func ExampleCheckDaxConnType() {
+	sabi.AddGlobalDaxSrc("hoge", FooDaxSrc{})
+	sabi.AddGlobalDaxSrc("fuga", BarDaxSrc{})
+	sabi.FixGlobalDaxSrcs()
+
+	base := sabi.NewDaxBase()
+
+	err := sabi.CheckDaxConnType[*FooDaxConn](base, "hoge")
+	fmt.Printf("err.IsOk() = %v\n", err.IsOk())
+
+	err = sabi.CheckDaxConnType[*FooDaxConn](base, "fuga")
+	fmt.Printf("err.ReasonName() = %v\n", err.ReasonName())
+
+	// Output:
+	// err.IsOk() = true
+	// err.ReasonName() = DaxConnIsNotExpectedType
+
+	sabi.Clear()
+}
@@ -0,0 +1,45 @@
+package sabi_test
+
+import (
+	"github.com/sttk-go/sabi"
+)
+
+func ExampleSavepoint() {
+	base := sabi.NewDaxBase()
+
+	type MyDax interface {
+		sabi.Dax
+		GetData() string
+		SetData(data string)
+	}
+
+	dax := struct {
+		*sabi.DaxBase
+		FooGetterDax
+		BarSetterDax
+	}{
+		DaxBase:      base,
+		FooGetterDax: FooGetterDax{Dax: base},
+		BarSetterDax: BarSetterDax{Dax: base},
+	}
+
+	proc := sabi.NewProc[MyDax](base, dax)
+
+	err := proc.RunTxn(func(dax MyDax) sabi.Err {
+		data := dax.GetData()
+
+		// Changes in this function are undone if this function fails, but the
+		// transaction continues.
+		sabi.Savepoint(dax, func() sabi.Err {
+			dax.SetData(data)
+			return sabi.Ok()
+		})
+
+		return sabi.Ok()
+	})
+
+	// Output:
+
+	unused(err)
+	sabi.Clear()
+}
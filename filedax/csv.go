@@ -0,0 +1,200 @@
+// Copyright (C) 2023 Takayuki Sato. All Rights Reserved.
+// This program is free software under MIT License.
+// See the file LICENSE in this distribution for more details.
+
+package filedax
+
+import (
+	"encoding/csv"
+	"errors"
+	"github.com/sttk-go/sabi"
+	"github.com/sttk-go/sabi/internal/rowmap"
+	"io"
+	"io/fs"
+	"reflect"
+)
+
+// CsvReader is a structure type which reads rows of a CSV file one by one
+// and decodes them into values of a structure type specified by the type
+// parameter.
+// The first line of the file is a header, and each column is decoded into a
+// field of which tag `csv` or name matches the column name ignoring case and
+// underscores.
+// Columns matching no field are ignored.
+type CsvReader[T any] struct {
+	path   string
+	file   io.ReadCloser
+	reader *csv.Reader
+	fields [][]int
+	row    T
+	err    sabi.Err
+}
+
+// OpenCsv is a function which opens a CSV file at a specified path in a view
+// of a specified FileDaxConn, and reads its header.
+func OpenCsv[T any](conn *FileDaxConn, path string) (*CsvReader[T], sabi.Err) {
+	f, err := conn.Open(path)
+	if !err.IsOk() {
+		return nil, err
+	}
+
+	r := &CsvReader[T]{path: path, file: f, reader: csv.NewReader(f), err: sabi.Ok()}
+	r.reader.ReuseRecord = true
+
+	header, e := r.reader.Read()
+	if e == io.EOF {
+		return r, sabi.Ok()
+	}
+	if e != nil {
+		f.Close()
+		return nil, r.errBy(e)
+	}
+
+	m := make(map[string][]int)
+	for _, fld := range rowFields(reflect.TypeOf(r.row), "csv") {
+		m[rowmap.NormalizeColumnName(fld.name)] = fld.index
+	}
+	r.fields = make([][]int, len(header))
+	for i, col := range header {
+		r.fields[i] = m[rowmap.NormalizeColumnName(col)]
+	}
+	return r, sabi.Ok()
+}
+
+// Next is a method which reads and decodes the next row.
+// This method returns false when there are no more rows or an error occurs,
+// and the error can be got with #Err.
+func (r *CsvReader[T]) Next() bool {
+	if !r.err.IsOk() || r.fields == nil {
+		return false
+	}
+
+	rec, e := r.reader.Read()
+	if e == io.EOF {
+		return false
+	}
+	if e != nil {
+		r.err = r.errBy(e)
+		return false
+	}
+
+	var t T
+	v := reflect.ValueOf(&t).Elem()
+	for i, index := range r.fields {
+		if index == nil {
+			continue
+		}
+		e = decodeField(v.FieldByIndex(index), rec[i])
+		if e != nil {
+			line, _ := r.reader.FieldPos(i)
+			r.err = sabi.ErrBy(FailToDecodeRow{File: r.path, Line: line}, e)
+			return false
+		}
+	}
+	r.row = t
+	return true
+}
+
+func (r *CsvReader[T]) errBy(e error) sabi.Err {
+	var pe *csv.ParseError
+	if errors.As(e, &pe) {
+		return sabi.ErrBy(FailToDecodeRow{File: r.path, Line: pe.Line}, e)
+	}
+	return sabi.ErrBy(FailToReadFile{Path: r.path}, e)
+}
+
+// Row is a method which returns the row decoded by the last #Next.
+func (r *CsvReader[T]) Row() T {
+	return r.row
+}
+
+// Err is a method which returns an error occured in #Next.
+func (r *CsvReader[T]) Err() sabi.Err {
+	return r.err
+}
+
+// Close is a method which closes the file.
+func (r *CsvReader[T]) Close() {
+	r.file.Close()
+}
+
+// CsvWriter is a structure type which encodes values of a structure type
+// specified by the type parameter and writes them as rows of a CSV file in a
+// transaction.
+// The file is staged in a FileDaxConn and is published only when the
+// transaction is committed.
+// Columns are fields of the structure type in order, and their names are
+// tags `csv` or field names.
+type CsvWriter[T any] struct {
+	path    string
+	file    io.WriteCloser
+	counter *lineCounter
+	writer  *csv.Writer
+	fields  []rowField
+}
+
+// CreateCsv is a function which stages creating a CSV file at a specified
+// path with a specified permission in a specified FileDaxConn, and writes a
+// header.
+func CreateCsv[T any](conn *FileDaxConn, path string, perm fs.FileMode) (*CsvWriter[T], sabi.Err) {
+	f, err := conn.Create(path, perm)
+	if !err.IsOk() {
+		return nil, err
+	}
+
+	var t T
+	counter := &lineCounter{w: f}
+	w := &CsvWriter[T]{
+		path:    path,
+		file:    f,
+		counter: counter,
+		writer:  csv.NewWriter(counter),
+		fields:  rowFields(reflect.TypeOf(t), "csv"),
+	}
+
+	header := make([]string, len(w.fields))
+	for i, fld := range w.fields {
+		header[i] = fld.name
+	}
+	err = w.write(header)
+	if !err.IsOk() {
+		f.Close()
+		return nil, err
+	}
+	return w, sabi.Ok()
+}
+
+// Write is a method which encodes a specified value and writes it as a row.
+func (w *CsvWriter[T]) Write(row T) sabi.Err {
+	v := reflect.ValueOf(row)
+	rec := make([]string, len(w.fields))
+	for i, fld := range w.fields {
+		s, e := encodeField(v.FieldByIndex(fld.index))
+		if e != nil {
+			return sabi.ErrBy(FailToEncodeRow{File: w.path, Line: w.counter.lines + 1}, e)
+		}
+		rec[i] = s
+	}
+	return w.write(rec)
+}
+
+func (w *CsvWriter[T]) write(rec []string) sabi.Err {
+	e := w.writer.Write(rec)
+	if e == nil {
+		w.writer.Flush()
+		e = w.writer.Error()
+	}
+	if e != nil {
+		return sabi.ErrBy(FailToStageFileOp{Op: opWrite, Path: w.path}, e)
+	}
+	return sabi.Ok()
+}
+
+// Close is a method which closes the staged file.
+func (w *CsvWriter[T]) Close() sabi.Err {
+	e := w.file.Close()
+	if e != nil && !errors.Is(e, fs.ErrClosed) {
+		return sabi.ErrBy(FailToStageFileOp{Op: opWrite, Path: w.path}, e)
+	}
+	return sabi.Ok()
+}
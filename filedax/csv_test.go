@@ -0,0 +1,233 @@
+package filedax
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/sttk-go/sabi"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type Item struct {
+	ID       int64
+	Name     string `csv:"item_name"`
+	Price    float64
+	InStock  bool
+	Updated  time.Time
+	Internal string `csv:"-"`
+	note     string
+}
+
+type Total struct {
+	Name  string
+	Total float64
+}
+
+func TestCsvReaderAndWriter(t *testing.T) {
+	proc, dir := newFileProc(t)
+	in := filepath.Join(dir, "items.csv")
+	out := filepath.Join(dir, "totals.csv")
+	writeFile(t, in, "id,item_name,price,in_stock,updated,extra\n"+
+		"1,apple,1.5,true,2023-01-02T03:04:05Z,x\n"+
+		"2,\"banana\nsplit\",2,false,2023-01-03T00:00:00Z,y\n")
+
+	err := proc.RunTxn(func(dax FileDax) sabi.Err {
+		conn, err := dax.GetFileDaxConn("file")
+		if !err.IsOk() {
+			return err
+		}
+		r, err := OpenCsv[Item](conn, in)
+		if !err.IsOk() {
+			return err
+		}
+		defer r.Close()
+
+		w, err := CreateCsv[Total](conn, out, 0644)
+		if !err.IsOk() {
+			return err
+		}
+
+		items := make([]Item, 0)
+		for r.Next() {
+			item := r.Row()
+			items = append(items, item)
+			err = w.Write(Total{Name: item.Name, Total: item.Price * 2})
+			if !err.IsOk() {
+				return err
+			}
+		}
+		if !r.Err().IsOk() {
+			return r.Err()
+		}
+		assert.Equal(t, items, []Item{
+			{ID: 1, Name: "apple", Price: 1.5, InStock: true,
+				Updated: time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)},
+			{ID: 2, Name: "banana\nsplit", Price: 2,
+				Updated: time.Date(2023, 1, 3, 0, 0, 0, 0, time.UTC)},
+		})
+
+		assert.Equal(t, listDir(t, dir), []string{"items.csv"})
+		return w.Close()
+	})
+	assert.True(t, err.IsOk())
+
+	assert.Equal(t, readFile(t, out), "Name,Total\napple,3\n\"banana\nsplit\",4\n")
+}
+
+func TestCsvWriter_rollback(t *testing.T) {
+	proc, dir := newFileProc(t)
+	out := filepath.Join(dir, "totals.csv")
+
+	err := proc.RunTxn(func(dax FileDax) sabi.Err {
+		conn, err := dax.GetFileDaxConn("file")
+		if !err.IsOk() {
+			return err
+		}
+		w, err := CreateCsv[Total](conn, out, 0644)
+		if !err.IsOk() {
+			return err
+		}
+		err = w.Write(Total{Name: "a", Total: 1})
+		if !err.IsOk() {
+			return err
+		}
+		return sabi.ErrBy(FailToRun{})
+	})
+	assert.Equal(t, err.ReasonName(), "FailToRun")
+	assert.Equal(t, listDir(t, dir), []string{})
+}
+
+func readAllCsv[T any](proc sabi.Proc[FileDax], path string) ([]T, sabi.Err) {
+	var rows []T
+	err := proc.RunTxn(func(dax FileDax) sabi.Err {
+		conn, err := dax.GetFileDaxConn("file")
+		if !err.IsOk() {
+			return err
+		}
+		r, err := OpenCsv[T](conn, path)
+		if !err.IsOk() {
+			return err
+		}
+		defer r.Close()
+		for r.Next() {
+			rows = append(rows, r.Row())
+		}
+		return r.Err()
+	})
+	return rows, err
+}
+
+func TestCsvReader_empty(t *testing.T) {
+	proc, dir := newFileProc(t)
+	in := filepath.Join(dir, "items.csv")
+	writeFile(t, in, "")
+
+	rows, err := readAllCsv[Item](proc, in)
+	assert.True(t, err.IsOk())
+	assert.Equal(t, len(rows), 0)
+}
+
+func TestCsvReader_failToDecodeRow(t *testing.T) {
+	proc, dir := newFileProc(t)
+	in := filepath.Join(dir, "items.csv")
+	writeFile(t, in, "id,item_name\n1,apple\n2,\"ban\nana\"\nx,cherry\n")
+
+	rows, err := readAllCsv[Item](proc, in)
+	switch err.Reason().(type) {
+	case FailToDecodeRow:
+		assert.Equal(t, err.Get("File"), in)
+		assert.Equal(t, err.Get("Line"), 5)
+	default:
+		assert.Fail(t, err.Error())
+	}
+	assert.Equal(t, len(rows), 2)
+}
+
+func TestCsvReader_failToDecodeRow_parseError(t *testing.T) {
+	proc, dir := newFileProc(t)
+	in := filepath.Join(dir, "items.csv")
+	writeFile(t, in, "id,item_name\n1,apple\n2\n")
+
+	_, err := readAllCsv[Item](proc, in)
+	switch err.Reason().(type) {
+	case FailToDecodeRow:
+		assert.Equal(t, err.Get("File"), in)
+		assert.Equal(t, err.Get("Line"), 3)
+	default:
+		assert.Fail(t, err.Error())
+	}
+}
+
+func TestOpenCsv_failToReadFile(t *testing.T) {
+	proc, dir := newFileProc(t)
+	in := filepath.Join(dir, "none.csv")
+
+	_, err := readAllCsv[Item](proc, in)
+	assert.Equal(t, err.ReasonName(), "FailToReadFile")
+}
+
+type BadRow struct {
+	Name  string
+	Attrs map[string]string
+}
+
+type Removal struct {
+	Name    string
+	Removed *time.Time
+}
+
+func TestCsvWriter_Write_nilPointerField(t *testing.T) {
+	proc, dir := newFileProc(t)
+	out := filepath.Join(dir, "removals.csv")
+	tm := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	err := proc.RunTxn(func(dax FileDax) sabi.Err {
+		conn, err := dax.GetFileDaxConn("file")
+		if !err.IsOk() {
+			return err
+		}
+		w, err := CreateCsv[Removal](conn, out, 0644)
+		if !err.IsOk() {
+			return err
+		}
+		err = w.Write(Removal{Name: "apple", Removed: &tm})
+		if !err.IsOk() {
+			return err
+		}
+		err = w.Write(Removal{Name: "banana"})
+		if !err.IsOk() {
+			return err
+		}
+		return w.Close()
+	})
+	assert.True(t, err.IsOk())
+
+	assert.Equal(t, readFile(t, out),
+		"Name,Removed\napple,2023-01-02T03:04:05Z\nbanana,\n")
+}
+
+func TestCsvWriter_Write_failToEncodeRow(t *testing.T) {
+	proc, dir := newFileProc(t)
+	out := filepath.Join(dir, "bad.csv")
+
+	err := proc.RunTxn(func(dax FileDax) sabi.Err {
+		conn, err := dax.GetFileDaxConn("file")
+		if !err.IsOk() {
+			return err
+		}
+		w, err := CreateCsv[BadRow](conn, out, 0644)
+		if !err.IsOk() {
+			return err
+		}
+		defer w.Close()
+		return w.Write(BadRow{Name: "a\nb"})
+	})
+	switch err.Reason().(type) {
+	case FailToEncodeRow:
+		assert.Equal(t, err.Get("File"), out)
+		assert.Equal(t, err.Get("Line"), 2)
+	default:
+		assert.Fail(t, err.Error())
+	}
+	assert.Equal(t, listDir(t, dir), []string{})
+}
@@ -0,0 +1,511 @@
+// Copyright (C) 2023 Takayuki Sato. All Rights Reserved.
+// This program is free software under MIT License.
+// See the file LICENSE in this distribution for more details.
+
+// Package filedax provides a DaxSrc and a DaxConn of sabi which stage file
+// operations in a temporary directory and apply them when a transaction is
+// committed.
+//
+// This package also provides streaming readers of CSV and JSON Lines files
+// which decode rows into values, and writers of them which publish the files
+// only when transactions are committed.
+package filedax
+
+import (
+	"errors"
+	"github.com/sttk-go/sabi"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+type /* error reasons */ (
+	// FailToStageFileOp is an error reason which indicates that it failed to
+	// stage a file operation in a transaction.
+	// The field Op is the kind of the operation: "write", "rename" or
+	// "delete", and the field Path is the path of the target file.
+	// The cause of an Err having this reason is an error returned by the os
+	// package.
+	FailToStageFileOp struct {
+		Op   string
+		Path string
+	}
+
+	// FailToApplyFileOp is an error reason which indicates that it failed to
+	// apply a staged file operation on commit.
+	// The field Op is the kind of the operation: "write", "rename" or
+	// "delete", and the field Path is the path of the target file.
+	// The field NewPath is the destination path of a "rename" operation, and
+	// is empty for the other operations.
+	// The cause of an Err having this reason is an error returned by the os
+	// package.
+	FailToApplyFileOp struct {
+		Op      string
+		Path    string
+		NewPath string
+	}
+
+	// FailToReadFile is an error reason which indicates that it failed to read
+	// a file.
+	// The field Path is the path of the file.
+	// The cause of an Err having this reason is an error returned by the os
+	// package, and it matches fs.ErrNotExist with errors.Is if the file does
+	// not exist or is deleted in the transaction.
+	FailToReadFile struct {
+		Path string
+	}
+)
+
+const (
+	opWrite  = "write"
+	opRename = "rename"
+	opDelete = "delete"
+)
+
+// FileDaxSrc is a structure type which implements sabi.DaxSrc and creates
+// FileDaxConn.
+type FileDaxSrc struct {
+	tempDir string
+}
+
+// NewFileDaxSrc is a function which creates a new FileDaxSrc which stages
+// files in the default temporary directory.
+func NewFileDaxSrc() FileDaxSrc {
+	return FileDaxSrc{}
+}
+
+// WithTempDir is a method which returns a copy of this FileDaxSrc which stages
+// files in a specified directory.
+// Staged files are moved to their target paths by renaming them if the
+// directory is on the same file system as the target paths, otherwise they
+// are copied.
+func (ds FileDaxSrc) WithTempDir(dir string) FileDaxSrc {
+	ds.tempDir = dir
+	return ds
+}
+
+// CreateDaxConn is a method which creates a new FileDaxConn.
+func (ds FileDaxSrc) CreateDaxConn() (sabi.DaxConn, sabi.Err) {
+	return &FileDaxConn{tempDir: ds.tempDir}, sabi.Ok()
+}
+
+type fileOp struct {
+	op      string
+	path    string
+	newPath string
+	staged  string
+	file    *os.File
+}
+
+type undoFn func() error
+
+// FileDaxConn is a structure type which implements sabi.DaxConn, and stages
+// writes, renames and deletes of files in a transaction.
+// Staged operations are applied in order on #Commit and are discarded on
+// #Rollback.
+// If an operation fails on #Commit, the operations already applied are
+// undone.
+// This also implements sabi.ForceBackDaxConn to undo applied operations when
+// another DaxConn in the same transaction failed to commit.
+type FileDaxConn struct {
+	tempDir  string
+	stageDir string
+	ops      []fileOp
+	undos    []undoFn
+	backups  []string
+	mutex    sync.Mutex
+}
+
+// WriteFile is a method which stages writing data to a file at a specified
+// path with a specified permission.
+func (conn *FileDaxConn) WriteFile(path string, data []byte, perm fs.FileMode) sabi.Err {
+	f, err := conn.Create(path, perm)
+	if !err.IsOk() {
+		return err
+	}
+	_, e := f.Write(data)
+	if e == nil {
+		e = f.Close()
+	}
+	if e != nil {
+		return sabi.ErrBy(FailToStageFileOp{Op: opWrite, Path: path}, e)
+	}
+	return sabi.Ok()
+}
+
+// Create is a method which stages creating or truncating a file at a
+// specified path with a specified permission, and returns a writer to the
+// staged file.
+// The returned writer should be closed before the transaction is committed,
+// but it is closed on commit if it is not closed.
+func (conn *FileDaxConn) Create(path string, perm fs.FileMode) (io.WriteCloser, sabi.Err) {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	e := conn.makeStageDir()
+	if e != nil {
+		return nil, sabi.ErrBy(FailToStageFileOp{Op: opWrite, Path: path}, e)
+	}
+
+	staged := filepath.Join(conn.stageDir, strconv.Itoa(len(conn.ops)))
+	f, e := os.OpenFile(staged, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if e != nil {
+		return nil, sabi.ErrBy(FailToStageFileOp{Op: opWrite, Path: path}, e)
+	}
+
+	conn.ops = append(conn.ops, fileOp{
+		op:     opWrite,
+		path:   filepath.Clean(path),
+		staged: staged,
+		file:   f,
+	})
+	return f, sabi.Ok()
+}
+
+// Rename is a method which stages renaming a file at a specified path to a
+// new path.
+func (conn *FileDaxConn) Rename(path, newPath string) sabi.Err {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	path = filepath.Clean(path)
+	_, e := conn.stat(path, len(conn.ops)-1)
+	if e != nil {
+		return sabi.ErrBy(FailToStageFileOp{Op: opRename, Path: path}, e)
+	}
+
+	conn.ops = append(conn.ops, fileOp{
+		op:      opRename,
+		path:    path,
+		newPath: filepath.Clean(newPath),
+	})
+	return sabi.Ok()
+}
+
+// Remove is a method which stages deleting a file at a specified path.
+func (conn *FileDaxConn) Remove(path string) sabi.Err {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	path = filepath.Clean(path)
+	_, e := conn.stat(path, len(conn.ops)-1)
+	if e != nil {
+		return sabi.ErrBy(FailToStageFileOp{Op: opDelete, Path: path}, e)
+	}
+
+	conn.ops = append(conn.ops, fileOp{op: opDelete, path: path})
+	return sabi.Ok()
+}
+
+// ReadFile is a method which reads a file at a specified path in a view which
+// reflects operations staged in this transaction.
+func (conn *FileDaxConn) ReadFile(path string) ([]byte, sabi.Err) {
+	r, err := conn.Open(path)
+	if !err.IsOk() {
+		return nil, err
+	}
+	defer r.Close()
+
+	b, e := io.ReadAll(r)
+	if e != nil {
+		return nil, sabi.ErrBy(FailToReadFile{Path: path}, e)
+	}
+	return b, sabi.Ok()
+}
+
+// Open is a method which opens a file at a specified path for reading in a
+// view which reflects operations staged in this transaction.
+func (conn *FileDaxConn) Open(path string) (io.ReadCloser, sabi.Err) {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	actual, e := conn.stat(filepath.Clean(path), len(conn.ops)-1)
+	if e != nil {
+		return nil, sabi.ErrBy(FailToReadFile{Path: path}, e)
+	}
+	f, e := os.Open(actual)
+	if e != nil {
+		return nil, sabi.ErrBy(FailToReadFile{Path: path}, e)
+	}
+	return f, sabi.Ok()
+}
+
+// stat resolves a path in a view reflecting operations until a specified
+// index, and returns a path of an actual file which has the content.
+func (conn *FileDaxConn) stat(path string, index int) (string, error) {
+	for i := index; i >= 0; i-- {
+		op := conn.ops[i]
+		switch op.op {
+		case opWrite:
+			if op.path == path {
+				return op.staged, nil
+			}
+		case opDelete:
+			if op.path == path {
+				return "", fs.ErrNotExist
+			}
+		case opRename:
+			if op.path == path {
+				return "", fs.ErrNotExist
+			}
+			if op.newPath == path {
+				path = op.path
+			}
+		}
+	}
+
+	_, e := os.Stat(path)
+	if e != nil {
+		return "", e
+	}
+	return path, nil
+}
+
+func (conn *FileDaxConn) makeStageDir() error {
+	if len(conn.stageDir) > 0 {
+		return nil
+	}
+	dir, e := os.MkdirTemp(conn.tempDir, "sabi-filedax-")
+	if e != nil {
+		return e
+	}
+	conn.stageDir = dir
+	return nil
+}
+
+// Commit is a method which applies staged operations in order.
+// If an operation fails, this method undoes the operations already applied
+// and returns an Err having the reason FailToApplyFileOp.
+func (conn *FileDaxConn) Commit() sabi.Err {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	for _, op := range conn.ops {
+		if op.file != nil {
+			op.file.Close()
+		}
+	}
+
+	for _, op := range conn.ops {
+		var e error
+		switch op.op {
+		case opWrite:
+			e = conn.applyWrite(op)
+		case opRename:
+			e = conn.applyRename(op)
+		case opDelete:
+			e = conn.applyDelete(op)
+		}
+		if e != nil {
+			conn.undo()
+			return sabi.ErrBy(FailToApplyFileOp{
+				Op: op.op, Path: op.path, NewPath: op.newPath,
+			}, e)
+		}
+	}
+
+	conn.ops = nil
+	return sabi.Ok()
+}
+
+func (conn *FileDaxConn) applyWrite(op fileOp) error {
+	restore, e := conn.backup(op.path)
+	if e != nil {
+		return e
+	}
+
+	e = moveFile(op.staged, op.path)
+	if e != nil {
+		restore()
+		return e
+	}
+
+	conn.undos = append(conn.undos, func() error {
+		e := os.Remove(op.path)
+		if e != nil {
+			return e
+		}
+		return restore()
+	})
+	return nil
+}
+
+func (conn *FileDaxConn) applyRename(op fileOp) error {
+	restore, e := conn.backup(op.newPath)
+	if e != nil {
+		return e
+	}
+
+	e = os.Rename(op.path, op.newPath)
+	if e != nil {
+		restore()
+		return e
+	}
+
+	conn.undos = append(conn.undos, func() error {
+		e := os.Rename(op.newPath, op.path)
+		if e != nil {
+			return e
+		}
+		return restore()
+	})
+	return nil
+}
+
+func (conn *FileDaxConn) applyDelete(op fileOp) error {
+	_, e := os.Lstat(op.path)
+	if e != nil {
+		return e
+	}
+
+	restore, e := conn.backup(op.path)
+	if e != nil {
+		return e
+	}
+
+	conn.undos = append(conn.undos, restore)
+	return nil
+}
+
+var backupSeq uint64
+
+// backup moves an existing file at a specified path to a backup file in the
+// same directory, and returns a function to restore it.
+func (conn *FileDaxConn) backup(path string) (undoFn, error) {
+	_, e := os.Lstat(path)
+	if errors.Is(e, fs.ErrNotExist) {
+		return func() error { return nil }, nil
+	}
+	if e != nil {
+		return nil, e
+	}
+
+	seq := atomic.AddUint64(&backupSeq, 1)
+	bak := path + ".sabi-bak-" + strconv.Itoa(os.Getpid()) + "-" +
+		strconv.FormatUint(seq, 10)
+	e = os.Rename(path, bak)
+	if e != nil {
+		return nil, e
+	}
+
+	conn.backups = append(conn.backups, bak)
+	return func() error {
+		return os.Rename(bak, path)
+	}, nil
+}
+
+func (conn *FileDaxConn) undo() {
+	for i := len(conn.undos) - 1; i >= 0; i-- {
+		conn.undos[i]()
+	}
+	conn.undos = nil
+}
+
+// ForceBack is a method which undoes operations applied by #Commit.
+// This method is called by sabi.DaxBase when another DaxConn in the same
+// transaction failed to commit.
+func (conn *FileDaxConn) ForceBack() {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	conn.undo()
+}
+
+// Rollback is a method which discards staged operations.
+func (conn *FileDaxConn) Rollback() {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	for _, op := range conn.ops {
+		if op.file != nil {
+			op.file.Close()
+		}
+	}
+	conn.ops = nil
+}
+
+// Close is a method which removes the temporary directory of staged files and
+// backup files of overwritten or deleted files.
+func (conn *FileDaxConn) Close() {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	for _, op := range conn.ops {
+		if op.file != nil {
+			op.file.Close()
+		}
+	}
+	conn.ops = nil
+
+	for _, bak := range conn.backups {
+		os.Remove(bak)
+	}
+	conn.backups = nil
+	conn.undos = nil
+
+	if len(conn.stageDir) > 0 {
+		os.RemoveAll(conn.stageDir)
+		conn.stageDir = ""
+	}
+}
+
+func moveFile(src, dst string) error {
+	e := os.Rename(src, dst)
+	if e == nil {
+		return nil
+	}
+
+	// If the staged file is on another file system, it is copied to a
+	// temporary file in the target directory and renamed.
+	in, e2 := os.Open(src)
+	if e2 != nil {
+		return e
+	}
+	defer in.Close()
+
+	fi, e2 := in.Stat()
+	if e2 != nil {
+		return e2
+	}
+
+	tmp := dst + ".sabi-tmp"
+	out, e2 := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, fi.Mode())
+	if e2 != nil {
+		return e2
+	}
+
+	_, e2 = io.Copy(out, in)
+	if e2 == nil {
+		e2 = out.Close()
+	} else {
+		out.Close()
+	}
+	if e2 == nil {
+		e2 = os.Rename(tmp, dst)
+	}
+	if e2 != nil {
+		os.Remove(tmp)
+		return e2
+	}
+	return nil
+}
+
+// FileDax is a structure type which is embedded in a dax structure accessing
+// files, and provides a method to get a FileDaxConn.
+type FileDax struct {
+	sabi.Dax
+}
+
+// NewFileDax is a function which creates a new FileDax with a specified Dax.
+func NewFileDax(dax sabi.Dax) FileDax {
+	return FileDax{Dax: dax}
+}
+
+// GetFileDaxConn is a method which gets a FileDaxConn by a specified name.
+func (dax FileDax) GetFileDaxConn(name string) (*FileDaxConn, sabi.Err) {
+	return sabi.GetDaxConn[*FileDaxConn](dax.Dax, name)
+}
@@ -0,0 +1,385 @@
+package filedax
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/sttk-go/sabi"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+type FailToRun struct{}
+
+type FailToCommit struct{}
+
+type failingDaxConn struct{}
+
+func (conn failingDaxConn) Commit() sabi.Err {
+	return sabi.ErrBy(FailToCommit{})
+}
+
+func (conn failingDaxConn) Rollback() {
+}
+
+func (conn failingDaxConn) Close() {
+}
+
+type failingDaxSrc struct{}
+
+func (ds failingDaxSrc) CreateDaxConn() (sabi.DaxConn, sabi.Err) {
+	return failingDaxConn{}, sabi.Ok()
+}
+
+func (ds failingDaxSrc) CommitOrder() int {
+	return 1
+}
+
+func newFileProc(t *testing.T) (sabi.Proc[FileDax], string) {
+	dir := t.TempDir()
+	base := sabi.NewDaxBase()
+	base.AddLocalDaxSrc("file", NewFileDaxSrc().WithTempDir(t.TempDir()))
+	return sabi.NewProc[FileDax](base, NewFileDax(base)), dir
+}
+
+func writeFile(t *testing.T, path, s string) {
+	assert.Nil(t, os.WriteFile(path, []byte(s), 0644))
+}
+
+func readFile(t *testing.T, path string) string {
+	b, e := os.ReadFile(path)
+	assert.Nil(t, e)
+	return string(b)
+}
+
+func listDir(t *testing.T, dir string) []string {
+	entries, e := os.ReadDir(dir)
+	assert.Nil(t, e)
+	a := make([]string, 0, len(entries))
+	for _, ent := range entries {
+		a = append(a, ent.Name())
+	}
+	sort.Strings(a)
+	return a
+}
+
+func TestFileDaxConn_commit(t *testing.T) {
+	proc, dir := newFileProc(t)
+	writeFile(t, filepath.Join(dir, "old.txt"), "old")
+	writeFile(t, filepath.Join(dir, "gone.txt"), "gone")
+	writeFile(t, filepath.Join(dir, "over.txt"), "before")
+
+	err := proc.RunTxn(func(dax FileDax) sabi.Err {
+		conn, err := dax.GetFileDaxConn("file")
+		if !err.IsOk() {
+			return err
+		}
+		err = conn.WriteFile(filepath.Join(dir, "new.txt"), []byte("new"), 0644)
+		if !err.IsOk() {
+			return err
+		}
+		err = conn.WriteFile(filepath.Join(dir, "over.txt"), []byte("after"), 0644)
+		if !err.IsOk() {
+			return err
+		}
+		err = conn.Rename(filepath.Join(dir, "old.txt"), filepath.Join(dir, "moved.txt"))
+		if !err.IsOk() {
+			return err
+		}
+		err = conn.Remove(filepath.Join(dir, "gone.txt"))
+		if !err.IsOk() {
+			return err
+		}
+
+		_, e := os.Stat(filepath.Join(dir, "new.txt"))
+		assert.True(t, errors.Is(e, fs.ErrNotExist))
+		return sabi.Ok()
+	})
+	assert.True(t, err.IsOk())
+
+	assert.Equal(t, listDir(t, dir), []string{"moved.txt", "new.txt", "over.txt"})
+	assert.Equal(t, readFile(t, filepath.Join(dir, "new.txt")), "new")
+	assert.Equal(t, readFile(t, filepath.Join(dir, "over.txt")), "after")
+	assert.Equal(t, readFile(t, filepath.Join(dir, "moved.txt")), "old")
+}
+
+func TestFileDaxConn_rollback(t *testing.T) {
+	proc, dir := newFileProc(t)
+	writeFile(t, filepath.Join(dir, "old.txt"), "old")
+
+	err := proc.RunTxn(func(dax FileDax) sabi.Err {
+		conn, err := dax.GetFileDaxConn("file")
+		if !err.IsOk() {
+			return err
+		}
+		err = conn.WriteFile(filepath.Join(dir, "new.txt"), []byte("new"), 0644)
+		if !err.IsOk() {
+			return err
+		}
+		err = conn.Remove(filepath.Join(dir, "old.txt"))
+		if !err.IsOk() {
+			return err
+		}
+		return sabi.ErrBy(FailToRun{})
+	})
+	assert.Equal(t, err.ReasonName(), "FailToRun")
+
+	assert.Equal(t, listDir(t, dir), []string{"old.txt"})
+	assert.Equal(t, readFile(t, filepath.Join(dir, "old.txt")), "old")
+}
+
+func TestFileDaxConn_readStagedView(t *testing.T) {
+	proc, dir := newFileProc(t)
+	writeFile(t, filepath.Join(dir, "a.txt"), "a")
+
+	err := proc.RunTxn(func(dax FileDax) sabi.Err {
+		conn, err := dax.GetFileDaxConn("file")
+		if !err.IsOk() {
+			return err
+		}
+
+		b, err := conn.ReadFile(filepath.Join(dir, "a.txt"))
+		assert.True(t, err.IsOk())
+		assert.Equal(t, string(b), "a")
+
+		err = conn.Rename(filepath.Join(dir, "a.txt"), filepath.Join(dir, "b.txt"))
+		assert.True(t, err.IsOk())
+
+		b, err = conn.ReadFile(filepath.Join(dir, "b.txt"))
+		assert.True(t, err.IsOk())
+		assert.Equal(t, string(b), "a")
+
+		_, err = conn.ReadFile(filepath.Join(dir, "a.txt"))
+		switch err.Reason().(type) {
+		case FailToReadFile:
+			assert.Equal(t, err.Get("Path"), filepath.Join(dir, "a.txt"))
+			assert.True(t, errors.Is(err.Cause(), fs.ErrNotExist))
+		default:
+			assert.Fail(t, err.Error())
+		}
+
+		w, err := conn.Create(filepath.Join(dir, "c.txt"), 0600)
+		assert.True(t, err.IsOk())
+		w.Write([]byte("c"))
+
+		b, err = conn.ReadFile(filepath.Join(dir, "c.txt"))
+		assert.True(t, err.IsOk())
+		assert.Equal(t, string(b), "c")
+
+		err = conn.Remove(filepath.Join(dir, "c.txt"))
+		assert.True(t, err.IsOk())
+
+		_, err = conn.ReadFile(filepath.Join(dir, "c.txt"))
+		assert.Equal(t, err.ReasonName(), "FailToReadFile")
+		return sabi.Ok()
+	})
+	assert.True(t, err.IsOk())
+
+	assert.Equal(t, listDir(t, dir), []string{"b.txt"})
+}
+
+func TestFileDaxConn_Create_notClosed(t *testing.T) {
+	proc, dir := newFileProc(t)
+
+	err := proc.RunTxn(func(dax FileDax) sabi.Err {
+		conn, err := dax.GetFileDaxConn("file")
+		if !err.IsOk() {
+			return err
+		}
+		w, err := conn.Create(filepath.Join(dir, "a.txt"), 0644)
+		if !err.IsOk() {
+			return err
+		}
+		w.Write([]byte("hello"))
+		return sabi.Ok()
+	})
+	assert.True(t, err.IsOk())
+
+	assert.Equal(t, readFile(t, filepath.Join(dir, "a.txt")), "hello")
+}
+
+func TestFileDaxConn_failToStageFileOp(t *testing.T) {
+	proc, dir := newFileProc(t)
+
+	err := proc.RunTxn(func(dax FileDax) sabi.Err {
+		conn, err := dax.GetFileDaxConn("file")
+		if !err.IsOk() {
+			return err
+		}
+		return conn.Remove(filepath.Join(dir, "none.txt"))
+	})
+	switch err.Reason().(type) {
+	case FailToStageFileOp:
+		assert.Equal(t, err.Get("Op"), "delete")
+		assert.Equal(t, err.Get("Path"), filepath.Join(dir, "none.txt"))
+	default:
+		assert.Fail(t, err.Error())
+	}
+
+	err = proc.RunTxn(func(dax FileDax) sabi.Err {
+		conn, err := dax.GetFileDaxConn("file")
+		if !err.IsOk() {
+			return err
+		}
+		return conn.Rename(filepath.Join(dir, "none.txt"), filepath.Join(dir, "x"))
+	})
+	switch err.Reason().(type) {
+	case FailToStageFileOp:
+		assert.Equal(t, err.Get("Op"), "rename")
+	default:
+		assert.Fail(t, err.Error())
+	}
+}
+
+func TestFileDaxConn_failToApplyFileOp(t *testing.T) {
+	proc, dir := newFileProc(t)
+	writeFile(t, filepath.Join(dir, "over.txt"), "before")
+	writeFile(t, filepath.Join(dir, "gone.txt"), "gone")
+
+	err := proc.RunTxn(func(dax FileDax) sabi.Err {
+		conn, err := dax.GetFileDaxConn("file")
+		if !err.IsOk() {
+			return err
+		}
+		err = conn.WriteFile(filepath.Join(dir, "over.txt"), []byte("after"), 0644)
+		if !err.IsOk() {
+			return err
+		}
+		err = conn.Remove(filepath.Join(dir, "gone.txt"))
+		if !err.IsOk() {
+			return err
+		}
+		err = conn.WriteFile(filepath.Join(dir, "no", "dir.txt"), []byte("x"), 0644)
+		if !err.IsOk() {
+			return err
+		}
+		return sabi.Ok()
+	})
+	switch err.Reason().(type) {
+	case sabi.FailToCommitDaxConn:
+		errs := err.Get("Errors").(map[string]sabi.Err)
+		e := errs["file"]
+		switch e.Reason().(type) {
+		case FailToApplyFileOp:
+			assert.Equal(t, e.Get("Op"), "write")
+			assert.Equal(t, e.Get("Path"), filepath.Join(dir, "no", "dir.txt"))
+			assert.Equal(t, e.Get("NewPath"), "")
+			assert.True(t, errors.Is(e.Cause(), fs.ErrNotExist))
+		default:
+			assert.Fail(t, e.Error())
+		}
+	default:
+		assert.Fail(t, err.Error())
+	}
+
+	assert.Equal(t, listDir(t, dir), []string{"gone.txt", "over.txt"})
+	assert.Equal(t, readFile(t, filepath.Join(dir, "over.txt")), "before")
+	assert.Equal(t, readFile(t, filepath.Join(dir, "gone.txt")), "gone")
+}
+
+func TestFileDaxConn_failToApplyFileOp_rename(t *testing.T) {
+	proc, dir := newFileProc(t)
+	writeFile(t, filepath.Join(dir, "old.txt"), "old")
+
+	err := proc.RunTxn(func(dax FileDax) sabi.Err {
+		conn, err := dax.GetFileDaxConn("file")
+		if !err.IsOk() {
+			return err
+		}
+		return conn.Rename(filepath.Join(dir, "old.txt"), filepath.Join(dir, "no", "new.txt"))
+	})
+	switch err.Reason().(type) {
+	case sabi.FailToCommitDaxConn:
+		errs := err.Get("Errors").(map[string]sabi.Err)
+		e := errs["file"]
+		switch e.Reason().(type) {
+		case FailToApplyFileOp:
+			assert.Equal(t, e.Get("Op"), "rename")
+			assert.Equal(t, e.Get("Path"), filepath.Join(dir, "old.txt"))
+			assert.Equal(t, e.Get("NewPath"), filepath.Join(dir, "no", "new.txt"))
+		default:
+			assert.Fail(t, e.Error())
+		}
+	default:
+		assert.Fail(t, err.Error())
+	}
+
+	assert.Equal(t, listDir(t, dir), []string{"old.txt"})
+}
+
+func TestFileDaxConn_ForceBack(t *testing.T) {
+	proc, dir := newFileProc(t)
+	proc.AddLocalDaxSrc("failing", failingDaxSrc{})
+	writeFile(t, filepath.Join(dir, "over.txt"), "before")
+	writeFile(t, filepath.Join(dir, "old.txt"), "old")
+
+	err := proc.RunTxn(func(dax FileDax) sabi.Err {
+		conn, err := dax.GetFileDaxConn("file")
+		if !err.IsOk() {
+			return err
+		}
+		err = conn.WriteFile(filepath.Join(dir, "over.txt"), []byte("after"), 0644)
+		if !err.IsOk() {
+			return err
+		}
+		err = conn.WriteFile(filepath.Join(dir, "new.txt"), []byte("new"), 0644)
+		if !err.IsOk() {
+			return err
+		}
+		err = conn.Rename(filepath.Join(dir, "old.txt"), filepath.Join(dir, "moved.txt"))
+		if !err.IsOk() {
+			return err
+		}
+		_, err = dax.GetDaxConn("failing")
+		return err
+	})
+	switch err.Reason().(type) {
+	case sabi.FailToCommitDaxConn:
+		assert.Equal(t, err.Get("Committed"), []string{"file"})
+		assert.Equal(t, err.Get("ForcedBack"), []string{"file"})
+	default:
+		assert.Fail(t, err.Error())
+	}
+
+	assert.Equal(t, listDir(t, dir), []string{"old.txt", "over.txt"})
+	assert.Equal(t, readFile(t, filepath.Join(dir, "over.txt")), "before")
+	assert.Equal(t, readFile(t, filepath.Join(dir, "old.txt")), "old")
+}
+
+func TestFileDaxConn_Close_removesStageDir(t *testing.T) {
+	tempDir := t.TempDir()
+	dir := t.TempDir()
+
+	conn, err := NewFileDaxSrc().WithTempDir(tempDir).CreateDaxConn()
+	assert.True(t, err.IsOk())
+	fconn := conn.(*FileDaxConn)
+
+	err = fconn.WriteFile(filepath.Join(dir, "a.txt"), []byte("a"), 0644)
+	assert.True(t, err.IsOk())
+	assert.Equal(t, len(listDir(t, tempDir)), 1)
+
+	fconn.Rollback()
+	fconn.Close()
+
+	assert.Equal(t, listDir(t, tempDir), []string{})
+	assert.Equal(t, listDir(t, dir), []string{})
+}
+
+func TestMoveFile_copy(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src.txt")
+	writeFile(t, src, "hello")
+
+	// A destination which is a non-empty directory makes os.Rename fail, so
+	// the fallback of copying is also failed and the temporary file is
+	// removed.
+	dst := filepath.Join(dir, "dst")
+	assert.Nil(t, os.Mkdir(dst, 0755))
+	writeFile(t, filepath.Join(dst, "x"), "x")
+
+	e := moveFile(src, dst)
+	assert.NotNil(t, e)
+	assert.Equal(t, listDir(t, dir), []string{"dst", "src.txt"})
+}
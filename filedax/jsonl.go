@@ -0,0 +1,141 @@
+// Copyright (C) 2023 Takayuki Sato. All Rights Reserved.
+// This program is free software under MIT License.
+// See the file LICENSE in this distribution for more details.
+
+package filedax
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/sttk-go/sabi"
+	"io"
+	"io/fs"
+)
+
+// JsonlReader is a structure type which reads lines of a JSON Lines file one
+// by one and decodes them into values of a type specified by the type
+// parameter with the encoding/json package.
+// Blank lines are skipped.
+type JsonlReader[T any] struct {
+	path   string
+	file   io.ReadCloser
+	reader *bufio.Reader
+	line   int
+	row    T
+	err    sabi.Err
+}
+
+// OpenJsonl is a function which opens a JSON Lines file at a specified path
+// in a view of a specified FileDaxConn.
+func OpenJsonl[T any](conn *FileDaxConn, path string) (*JsonlReader[T], sabi.Err) {
+	f, err := conn.Open(path)
+	if !err.IsOk() {
+		return nil, err
+	}
+	return &JsonlReader[T]{
+		path:   path,
+		file:   f,
+		reader: bufio.NewReader(f),
+		err:    sabi.Ok(),
+	}, sabi.Ok()
+}
+
+// Next is a method which reads and decodes the next line.
+// This method returns false when there are no more lines or an error occurs,
+// and the error can be got with #Err.
+func (r *JsonlReader[T]) Next() bool {
+	if !r.err.IsOk() {
+		return false
+	}
+
+	for {
+		b, e := r.reader.ReadBytes('\n')
+		if len(b) == 0 && e == io.EOF {
+			return false
+		}
+		if e != nil && e != io.EOF {
+			r.err = sabi.ErrBy(FailToReadFile{Path: r.path}, e)
+			return false
+		}
+		r.line++
+
+		b = bytes.TrimSpace(b)
+		if len(b) == 0 {
+			if e == io.EOF {
+				return false
+			}
+			continue
+		}
+
+		var t T
+		e = json.Unmarshal(b, &t)
+		if e != nil {
+			r.err = sabi.ErrBy(FailToDecodeRow{File: r.path, Line: r.line}, e)
+			return false
+		}
+		r.row = t
+		return true
+	}
+}
+
+// Row is a method which returns the row decoded by the last #Next.
+func (r *JsonlReader[T]) Row() T {
+	return r.row
+}
+
+// Err is a method which returns an error occured in #Next.
+func (r *JsonlReader[T]) Err() sabi.Err {
+	return r.err
+}
+
+// Close is a method which closes the file.
+func (r *JsonlReader[T]) Close() {
+	r.file.Close()
+}
+
+// JsonlWriter is a structure type which encodes values of a type specified by
+// the type parameter with the encoding/json package and writes them as lines
+// of a JSON Lines file in a transaction.
+// The file is staged in a FileDaxConn and is published only when the
+// transaction is committed.
+type JsonlWriter[T any] struct {
+	path string
+	file io.WriteCloser
+	line int
+}
+
+// CreateJsonl is a function which stages creating a JSON Lines file at a
+// specified path with a specified permission in a specified FileDaxConn.
+func CreateJsonl[T any](conn *FileDaxConn, path string, perm fs.FileMode) (*JsonlWriter[T], sabi.Err) {
+	f, err := conn.Create(path, perm)
+	if !err.IsOk() {
+		return nil, err
+	}
+	return &JsonlWriter[T]{path: path, file: f}, sabi.Ok()
+}
+
+// Write is a method which encodes a specified value and writes it as a line.
+func (w *JsonlWriter[T]) Write(row T) sabi.Err {
+	b, e := json.Marshal(row)
+	if e != nil {
+		return sabi.ErrBy(FailToEncodeRow{File: w.path, Line: w.line + 1}, e)
+	}
+
+	_, e = w.file.Write(append(b, '\n'))
+	if e != nil {
+		return sabi.ErrBy(FailToStageFileOp{Op: opWrite, Path: w.path}, e)
+	}
+	w.line++
+	return sabi.Ok()
+}
+
+// Close is a method which closes the staged file.
+func (w *JsonlWriter[T]) Close() sabi.Err {
+	e := w.file.Close()
+	if e != nil && !errors.Is(e, fs.ErrClosed) {
+		return sabi.ErrBy(FailToStageFileOp{Op: opWrite, Path: w.path}, e)
+	}
+	return sabi.Ok()
+}
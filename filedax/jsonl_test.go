@@ -0,0 +1,117 @@
+package filedax
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/sttk-go/sabi"
+	"path/filepath"
+	"testing"
+)
+
+type Event struct {
+	ID   int    `json:"id"`
+	Kind string `json:"kind"`
+}
+
+func TestJsonlReaderAndWriter(t *testing.T) {
+	proc, dir := newFileProc(t)
+	in := filepath.Join(dir, "events.jsonl")
+	out := filepath.Join(dir, "kinds.jsonl")
+	writeFile(t, in, "{\"id\":1,\"kind\":\"a\"}\n\n{\"id\":2,\"kind\":\"b\"}")
+
+	err := proc.RunTxn(func(dax FileDax) sabi.Err {
+		conn, err := dax.GetFileDaxConn("file")
+		if !err.IsOk() {
+			return err
+		}
+		r, err := OpenJsonl[Event](conn, in)
+		if !err.IsOk() {
+			return err
+		}
+		defer r.Close()
+
+		w, err := CreateJsonl[map[string]string](conn, out, 0644)
+		if !err.IsOk() {
+			return err
+		}
+		defer w.Close()
+
+		events := make([]Event, 0)
+		for r.Next() {
+			events = append(events, r.Row())
+			err = w.Write(map[string]string{"kind": r.Row().Kind})
+			if !err.IsOk() {
+				return err
+			}
+		}
+		assert.Equal(t, events, []Event{{ID: 1, Kind: "a"}, {ID: 2, Kind: "b"}})
+
+		assert.Equal(t, listDir(t, dir), []string{"events.jsonl"})
+		return r.Err()
+	})
+	assert.True(t, err.IsOk())
+
+	assert.Equal(t, readFile(t, out), "{\"kind\":\"a\"}\n{\"kind\":\"b\"}\n")
+}
+
+func TestJsonlReader_failToDecodeRow(t *testing.T) {
+	proc, dir := newFileProc(t)
+	in := filepath.Join(dir, "events.jsonl")
+	writeFile(t, in, "{\"id\":1}\n\n{\"id\":\"x\"}\n{\"id\":3}\n")
+
+	var events []Event
+	err := proc.RunTxn(func(dax FileDax) sabi.Err {
+		conn, err := dax.GetFileDaxConn("file")
+		if !err.IsOk() {
+			return err
+		}
+		r, err := OpenJsonl[Event](conn, in)
+		if !err.IsOk() {
+			return err
+		}
+		defer r.Close()
+
+		for r.Next() {
+			events = append(events, r.Row())
+		}
+		return r.Err()
+	})
+	switch err.Reason().(type) {
+	case FailToDecodeRow:
+		assert.Equal(t, err.Get("File"), in)
+		assert.Equal(t, err.Get("Line"), 3)
+	default:
+		assert.Fail(t, err.Error())
+	}
+	assert.Equal(t, events, []Event{{ID: 1}})
+}
+
+func TestJsonlWriter_Write_failToEncodeRow(t *testing.T) {
+	proc, dir := newFileProc(t)
+	out := filepath.Join(dir, "bad.jsonl")
+
+	err := proc.RunTxn(func(dax FileDax) sabi.Err {
+		conn, err := dax.GetFileDaxConn("file")
+		if !err.IsOk() {
+			return err
+		}
+		w, err := CreateJsonl[any](conn, out, 0644)
+		if !err.IsOk() {
+			return err
+		}
+		defer w.Close()
+
+		err = w.Write(1)
+		if !err.IsOk() {
+			return err
+		}
+		return w.Write(func() {})
+	})
+	switch err.Reason().(type) {
+	case FailToEncodeRow:
+		assert.Equal(t, err.Get("File"), out)
+		assert.Equal(t, err.Get("Line"), 2)
+	default:
+		assert.Fail(t, err.Error())
+	}
+	assert.Equal(t, listDir(t, dir), []string{})
+}
@@ -0,0 +1,160 @@
+// Copyright (C) 2023 Takayuki Sato. All Rights Reserved.
+// This program is free software under MIT License.
+// See the file LICENSE in this distribution for more details.
+
+package filedax
+
+import (
+	"bytes"
+	"encoding"
+	"fmt"
+	"github.com/sttk-go/sabi/internal/rowmap"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+type /* error reasons */ (
+	// FailToDecodeRow is an error reason which indicates that it failed to
+	// decode a row of a CSV or JSON Lines file.
+	// The field File is the path of the file, and the field Line is the line
+	// number of the row, which starts from 1.
+	// The cause of an Err having this reason is an error returned by the
+	// encoding/csv package, the encoding/json package or the conversion of a
+	// field value.
+	FailToDecodeRow struct {
+		File string
+		Line int
+	}
+
+	// FailToEncodeRow is an error reason which indicates that it failed to
+	// encode a row to be written to a CSV or JSON Lines file.
+	// The field File is the path of the file, and the field Line is the line
+	// number of the row, which starts from 1.
+	// The cause of an Err having this reason is an error returned by the
+	// encoding/csv package, the encoding/json package or the conversion of a
+	// field value.
+	FailToEncodeRow struct {
+		File string
+		Line int
+	}
+)
+
+type rowField struct {
+	name  string
+	index []int
+}
+
+// rowFields returns exported fields of a structure type with their column
+// names, which are tags of a specified key or field names.
+func rowFields(t reflect.Type, tagKey string) []rowField {
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+
+	fields := make([]rowField, 0, t.NumField())
+	for _, f := range reflect.VisibleFields(t) {
+		if !f.IsExported() || f.Anonymous || rowmap.IsPromotedByPtr(t, f.Index) {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get(tagKey), ",")
+		if name == "-" {
+			continue
+		}
+		if len(name) == 0 {
+			name = f.Name
+		}
+		fields = append(fields, rowField{name: name, index: f.Index})
+	}
+	return fields
+}
+
+var (
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	textMarshalerType   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// decodeField sets a text value into a field value.
+// An empty text sets the zero value except for a string field.
+func decodeField(v reflect.Value, s string) error {
+	if reflect.PtrTo(v.Type()).Implements(textUnmarshalerType) {
+		return v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+	}
+
+	if v.Kind() != reflect.String && len(s) == 0 {
+		v.Set(reflect.Zero(v.Type()))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, e := strconv.ParseBool(s)
+		if e != nil {
+			return e
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, e := strconv.ParseInt(s, 10, v.Type().Bits())
+		if e != nil {
+			return e
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, e := strconv.ParseUint(s, 10, v.Type().Bits())
+		if e != nil {
+			return e
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, e := strconv.ParseFloat(s, v.Type().Bits())
+		if e != nil {
+			return e
+		}
+		v.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported field type: %s", v.Type())
+	}
+	return nil
+}
+
+// encodeField converts a field value into a text.
+// A nil pointer is converted into an empty text.
+func encodeField(v reflect.Value) (string, error) {
+	if v.Kind() == reflect.Ptr && v.IsNil() {
+		return "", nil
+	}
+
+	if v.Type().Implements(textMarshalerType) {
+		b, e := v.Interface().(encoding.TextMarshaler).MarshalText()
+		return string(b), e
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'g', -1, v.Type().Bits()), nil
+	}
+	return "", fmt.Errorf("unsupported field type: %s", v.Type())
+}
+
+// lineCounter is a writer which counts lines written through it.
+type lineCounter struct {
+	w     io.Writer
+	lines int
+}
+
+func (lc *lineCounter) Write(p []byte) (int, error) {
+	n, e := lc.w.Write(p)
+	lc.lines += bytes.Count(p[:n], []byte{'\n'})
+	return n, e
+}
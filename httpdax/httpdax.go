@@ -0,0 +1,346 @@
+// Copyright (C) 2023 Takayuki Sato. All Rights Reserved.
+// This program is free software under MIT License.
+// See the file LICENSE in this distribution for more details.
+
+// Package httpdax provides a DaxSrc and a DaxConn of sabi which send HTTP
+// requests to external APIs.
+//
+// A request for reading can be sent immediately in a logic, and a request for
+// writing can be deferred until the transaction is committed, because its
+// side effects cannot be rolled back.
+package httpdax
+
+import (
+	"bytes"
+	"context"
+	"github.com/sttk-go/sabi"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+type /* error reasons */ (
+	// FailToCreateRequest is an error reason which indicates that it failed
+	// to create an HTTP request.
+	// The field Method and URL are the method and the URL of the request.
+	// The cause of an Err having this reason is an error returned by the
+	// net/http package.
+	FailToCreateRequest struct {
+		Method string
+		URL    string
+	}
+
+	// FailToSendRequest is an error reason which indicates that it failed to
+	// send an HTTP request or to receive its response.
+	// The field Method and URL are the method and the URL of the request.
+	// The cause of an Err having this reason is an error returned by the
+	// net/http package.
+	FailToSendRequest struct {
+		Method string
+		URL    string
+	}
+
+	// ClientErrorStatus is an error reason which indicates that a response
+	// status is a client error (4xx).
+	// The field Method and URL are the method and the URL of the request, and
+	// the field StatusCode is the status code of the response.
+	ClientErrorStatus struct {
+		Method     string
+		URL        string
+		StatusCode int
+	}
+
+	// ServerErrorStatus is an error reason which indicates that a response
+	// status is a server error (5xx).
+	// The field Method and URL are the method and the URL of the request, and
+	// the field StatusCode is the status code of the response.
+	ServerErrorStatus struct {
+		Method     string
+		URL        string
+		StatusCode int
+	}
+)
+
+// Request is a structure type which represents an HTTP request.
+// If the URL is not absolute, it is resolved by appending it to the base URL
+// of a HttpDaxSrc.
+type Request struct {
+	Method string
+	URL    string
+	Header http.Header
+	Body   []byte
+}
+
+// Response is a structure type which represents an HTTP response of which
+// body is read entirely.
+type Response struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// Exchange is a structure type which represents a pair of a request and its
+// response recorded by a Recorder.
+// The field Deferred is true if the request was deferred until commit.
+// The field Response is empty if the request failed to be sent.
+type Exchange struct {
+	Request  Request
+	Response Response
+	Deferred bool
+}
+
+// Recorder is a structure type which records requests sent by HttpDaxConn
+// and their responses, and is mainly used in tests.
+type Recorder struct {
+	exchanges []Exchange
+	mutex     sync.Mutex
+}
+
+// NewRecorder is a function which creates a new Recorder.
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+// Exchanges is a method which returns recorded exchanges in the order in
+// which requests were sent.
+func (rec *Recorder) Exchanges() []Exchange {
+	rec.mutex.Lock()
+	defer rec.mutex.Unlock()
+
+	a := make([]Exchange, len(rec.exchanges))
+	copy(a, rec.exchanges)
+	return a
+}
+
+// Clear is a method which discards recorded exchanges.
+func (rec *Recorder) Clear() {
+	rec.mutex.Lock()
+	defer rec.mutex.Unlock()
+
+	rec.exchanges = nil
+}
+
+func (rec *Recorder) record(ex Exchange) {
+	if rec == nil {
+		return
+	}
+
+	rec.mutex.Lock()
+	defer rec.mutex.Unlock()
+
+	rec.exchanges = append(rec.exchanges, ex)
+}
+
+// HttpDaxSrc is a structure type which implements sabi.DaxSrc and creates
+// HttpDaxConn.
+// This also implements sabi.ContextDaxSrc to send requests with a context of
+// a transaction, and sabi.OrderedDaxSrc of which commit order is
+// sabi.LateCommitOrder.
+// Because deferred requests can fail, they are sent before DaxConn with
+// sabi.AfterCommitOrder deliver their side effects.
+type HttpDaxSrc struct {
+	client   *http.Client
+	baseURL  string
+	recorder *Recorder
+}
+
+// NewHttpDaxSrc is a function which creates a new HttpDaxSrc which sends
+// requests with a specified http.Client.
+// If the client is nil, http.DefaultClient is used.
+func NewHttpDaxSrc(client *http.Client) HttpDaxSrc {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return HttpDaxSrc{client: client}
+}
+
+// WithBaseURL is a method which returns a copy of this HttpDaxSrc which
+// resolves relative URLs of requests with a specified base URL.
+func (ds HttpDaxSrc) WithBaseURL(baseURL string) HttpDaxSrc {
+	ds.baseURL = strings.TrimSuffix(baseURL, "/")
+	return ds
+}
+
+// WithRecorder is a method which returns a copy of this HttpDaxSrc which
+// records requests and responses into a specified Recorder.
+func (ds HttpDaxSrc) WithRecorder(rec *Recorder) HttpDaxSrc {
+	ds.recorder = rec
+	return ds
+}
+
+// CreateDaxConn is a method which creates a new HttpDaxConn.
+func (ds HttpDaxSrc) CreateDaxConn() (sabi.DaxConn, sabi.Err) {
+	return ds.CreateDaxConnCtx(context.Background())
+}
+
+// CreateDaxConnCtx is a method which creates a new HttpDaxConn which sends
+// requests with a specified context.
+func (ds HttpDaxSrc) CreateDaxConnCtx(ctx context.Context) (sabi.DaxConn, sabi.Err) {
+	return &HttpDaxConn{src: ds, ctx: ctx}, sabi.Ok()
+}
+
+// CommitOrder is a method which returns sabi.LateCommitOrder.
+func (ds HttpDaxSrc) CommitOrder() int {
+	return sabi.LateCommitOrder
+}
+
+// HttpDaxConn is a structure type which implements sabi.DaxConn, and sends
+// HTTP requests immediately or on #Commit.
+// Deferred requests are sent in order on #Commit, and the first failure stops
+// the rest and is returned from #Commit.
+// Deferred requests are discarded on #Rollback.
+// This also implements sabi.ReadOnlyDaxConn.
+type HttpDaxConn struct {
+	src      HttpDaxSrc
+	ctx      context.Context
+	deferred []Request
+	readOnly bool
+	mutex    sync.Mutex
+}
+
+// Do is a method which sends a specified request immediately and returns its
+// response.
+// If the status of the response is 4xx or 5xx, this method returns the
+// response with an Err having the reason ClientErrorStatus or
+// ServerErrorStatus.
+func (conn *HttpDaxConn) Do(req Request) (Response, sabi.Err) {
+	return conn.send(req, false)
+}
+
+// Get is a method which sends a GET request to a specified URL immediately.
+func (conn *HttpDaxConn) Get(url string) (Response, sabi.Err) {
+	return conn.Do(Request{Method: http.MethodGet, URL: url})
+}
+
+// Defer is a method which defers a specified request until commit.
+// In a read-only transaction, this method returns an Err having the reason
+// sabi.TxnIsReadOnly.
+func (conn *HttpDaxConn) Defer(req Request) sabi.Err {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	if conn.readOnly {
+		return sabi.ErrBy(sabi.TxnIsReadOnly{})
+	}
+
+	conn.deferred = append(conn.deferred, req)
+	return sabi.Ok()
+}
+
+func (conn *HttpDaxConn) send(req Request, deferred bool) (Response, sabi.Err) {
+	url := req.URL
+	if !strings.Contains(url, "://") {
+		url = conn.src.baseURL + url
+	}
+	method := req.Method
+	if len(method) == 0 {
+		method = http.MethodGet
+	}
+
+	var body io.Reader
+	if req.Body != nil {
+		body = bytes.NewReader(req.Body)
+	}
+
+	hreq, e := http.NewRequestWithContext(conn.ctx, method, url, body)
+	if e != nil {
+		return Response{}, sabi.ErrBy(FailToCreateRequest{Method: method, URL: url}, e)
+	}
+	for k, vs := range req.Header {
+		for _, v := range vs {
+			hreq.Header.Add(k, v)
+		}
+	}
+
+	ex := Exchange{Request: req, Deferred: deferred}
+	ex.Request.Method = method
+	ex.Request.URL = url
+
+	hres, e := conn.src.client.Do(hreq)
+	if e != nil {
+		conn.src.recorder.record(ex)
+		return Response{}, sabi.ErrBy(FailToSendRequest{Method: method, URL: url}, e)
+	}
+	defer hres.Body.Close()
+
+	b, e := io.ReadAll(hres.Body)
+	if e != nil {
+		conn.src.recorder.record(ex)
+		return Response{}, sabi.ErrBy(FailToSendRequest{Method: method, URL: url}, e)
+	}
+
+	res := Response{StatusCode: hres.StatusCode, Header: hres.Header, Body: b}
+	ex.Response = res
+	conn.src.recorder.record(ex)
+
+	switch {
+	case res.StatusCode >= 500:
+		return res, sabi.ErrBy(ServerErrorStatus{
+			Method: method, URL: url, StatusCode: res.StatusCode,
+		})
+	case res.StatusCode >= 400:
+		return res, sabi.ErrBy(ClientErrorStatus{
+			Method: method, URL: url, StatusCode: res.StatusCode,
+		})
+	}
+	return res, sabi.Ok()
+}
+
+// Commit is a method which sends deferred requests in order.
+func (conn *HttpDaxConn) Commit() sabi.Err {
+	conn.mutex.Lock()
+	reqs := conn.deferred
+	conn.deferred = nil
+	conn.mutex.Unlock()
+
+	for _, req := range reqs {
+		_, err := conn.send(req, true)
+		if !err.IsOk() {
+			return err
+		}
+	}
+	return sabi.Ok()
+}
+
+// Rollback is a method which discards deferred requests.
+func (conn *HttpDaxConn) Rollback() {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	conn.deferred = nil
+}
+
+// Close is a method which discards deferred requests.
+func (conn *HttpDaxConn) Close() {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	conn.deferred = nil
+}
+
+// SetReadOnly is a method which makes this HttpDaxConn refuse deferring
+// requests.
+func (conn *HttpDaxConn) SetReadOnly() sabi.Err {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	conn.readOnly = true
+	return sabi.Ok()
+}
+
+// HttpDax is a structure type which is embedded in a dax structure accessing
+// HTTP APIs, and provides a method to get a HttpDaxConn.
+type HttpDax struct {
+	sabi.Dax
+}
+
+// NewHttpDax is a function which creates a new HttpDax with a specified Dax.
+func NewHttpDax(dax sabi.Dax) HttpDax {
+	return HttpDax{Dax: dax}
+}
+
+// GetHttpDaxConn is a method which gets a HttpDaxConn by a specified name.
+func (dax HttpDax) GetHttpDaxConn(name string) (*HttpDaxConn, sabi.Err) {
+	return sabi.GetDaxConn[*HttpDaxConn](dax.Dax, name)
+}
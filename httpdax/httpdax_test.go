@@ -0,0 +1,275 @@
+package httpdax
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/sttk-go/sabi"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+type FailToRun struct{}
+
+type fakeAPI struct {
+	server *httptest.Server
+	logs   []string
+	mutex  sync.Mutex
+}
+
+func newFakeAPI() *fakeAPI {
+	api := &fakeAPI{}
+	api.server = httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			b, _ := io.ReadAll(r.Body)
+			api.mutex.Lock()
+			api.logs = append(api.logs, r.Method+" "+r.URL.Path+" "+string(b))
+			api.mutex.Unlock()
+
+			switch r.URL.Path {
+			case "/users/1":
+				w.Header().Set("Content-Type", "text/plain")
+				w.Write([]byte("alice"))
+			case "/users":
+				w.WriteHeader(http.StatusCreated)
+			case "/broken":
+				w.WriteHeader(http.StatusInternalServerError)
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		},
+	))
+	return api
+}
+
+func (api *fakeAPI) getLogs() []string {
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+	return append([]string{}, api.logs...)
+}
+
+type UserDax struct {
+	HttpDax
+}
+
+func (dax UserDax) GetUser(id string) (string, sabi.Err) {
+	conn, err := dax.GetHttpDaxConn("api")
+	if !err.IsOk() {
+		return "", err
+	}
+	res, err := conn.Get("/users/" + id)
+	return string(res.Body), err
+}
+
+func (dax UserDax) AddUser(name string) sabi.Err {
+	conn, err := dax.GetHttpDaxConn("api")
+	if !err.IsOk() {
+		return err
+	}
+	return conn.Defer(Request{Method: http.MethodPost, URL: "/users", Body: []byte(name)})
+}
+
+func newUserProc(ds HttpDaxSrc) sabi.Proc[UserDax] {
+	base := sabi.NewDaxBase()
+	base.AddLocalDaxSrc("api", ds)
+	return sabi.NewProc[UserDax](base, UserDax{NewHttpDax(base)})
+}
+
+func TestHttpDaxConn_immediateAndDeferred(t *testing.T) {
+	api := newFakeAPI()
+	defer api.server.Close()
+
+	rec := NewRecorder()
+	ds := NewHttpDaxSrc(nil).WithBaseURL(api.server.URL + "/").WithRecorder(rec)
+
+	err := newUserProc(ds).RunTxn(func(dax UserDax) sabi.Err {
+		name, err := dax.GetUser("1")
+		if !err.IsOk() {
+			return err
+		}
+		assert.Equal(t, name, "alice")
+
+		err = dax.AddUser("bob")
+		if !err.IsOk() {
+			return err
+		}
+		assert.Equal(t, api.getLogs(), []string{"GET /users/1 "})
+		return sabi.Ok()
+	})
+	assert.True(t, err.IsOk())
+	assert.Equal(t, api.getLogs(), []string{"GET /users/1 ", "POST /users bob"})
+
+	exs := rec.Exchanges()
+	assert.Equal(t, len(exs), 2)
+	assert.Equal(t, exs[0].Request.Method, "GET")
+	assert.Equal(t, exs[0].Request.URL, api.server.URL+"/users/1")
+	assert.Equal(t, exs[0].Response.StatusCode, 200)
+	assert.Equal(t, exs[0].Response.Body, []byte("alice"))
+	assert.False(t, exs[0].Deferred)
+	assert.Equal(t, exs[1].Request.Method, "POST")
+	assert.Equal(t, exs[1].Request.Body, []byte("bob"))
+	assert.Equal(t, exs[1].Response.StatusCode, 201)
+	assert.True(t, exs[1].Deferred)
+
+	rec.Clear()
+	assert.Equal(t, len(rec.Exchanges()), 0)
+}
+
+func TestHttpDaxConn_discardDeferredOnRollback(t *testing.T) {
+	api := newFakeAPI()
+	defer api.server.Close()
+
+	ds := NewHttpDaxSrc(nil).WithBaseURL(api.server.URL)
+
+	err := newUserProc(ds).RunTxn(func(dax UserDax) sabi.Err {
+		err := dax.AddUser("bob")
+		if !err.IsOk() {
+			return err
+		}
+		return sabi.ErrBy(FailToRun{})
+	})
+	assert.Equal(t, err.ReasonName(), "FailToRun")
+	assert.Equal(t, len(api.getLogs()), 0)
+}
+
+func TestHttpDaxConn_Do_clientErrorStatus(t *testing.T) {
+	api := newFakeAPI()
+	defer api.server.Close()
+
+	ds := NewHttpDaxSrc(nil).WithBaseURL(api.server.URL)
+
+	err := newUserProc(ds).RunTxn(func(dax UserDax) sabi.Err {
+		_, err := dax.GetUser("2")
+		return err
+	})
+	switch err.Reason().(type) {
+	case ClientErrorStatus:
+		assert.Equal(t, err.Get("Method"), "GET")
+		assert.Equal(t, err.Get("URL"), api.server.URL+"/users/2")
+		assert.Equal(t, err.Get("StatusCode"), 404)
+	default:
+		assert.Fail(t, err.Error())
+	}
+}
+
+func TestHttpDaxConn_Commit_serverErrorStatus(t *testing.T) {
+	api := newFakeAPI()
+	defer api.server.Close()
+
+	ds := NewHttpDaxSrc(nil).WithBaseURL(api.server.URL)
+
+	err := newUserProc(ds).RunTxn(func(dax UserDax) sabi.Err {
+		conn, err := dax.GetHttpDaxConn("api")
+		if !err.IsOk() {
+			return err
+		}
+		err = conn.Defer(Request{Method: "PUT", URL: "/broken"})
+		if !err.IsOk() {
+			return err
+		}
+		return dax.AddUser("bob")
+	})
+	switch err.Reason().(type) {
+	case sabi.FailToCommitDaxConn:
+		errs := err.Get("Errors").(map[string]sabi.Err)
+		assert.Equal(t, errs["api"].ReasonName(), "ServerErrorStatus")
+		assert.Equal(t, errs["api"].Get("StatusCode"), 500)
+	default:
+		assert.Fail(t, err.Error())
+	}
+	assert.Equal(t, api.getLogs(), []string{"PUT /broken "})
+}
+
+type afterCommitDaxConn struct {
+	committed *bool
+}
+
+func (conn afterCommitDaxConn) Commit() sabi.Err {
+	*conn.committed = true
+	return sabi.Ok()
+}
+
+func (conn afterCommitDaxConn) Rollback() {}
+
+func (conn afterCommitDaxConn) Close() {}
+
+type afterCommitDaxSrc struct {
+	committed *bool
+}
+
+func (ds afterCommitDaxSrc) CreateDaxConn() (sabi.DaxConn, sabi.Err) {
+	return afterCommitDaxConn{committed: ds.committed}, sabi.Ok()
+}
+
+func (ds afterCommitDaxSrc) CommitOrder() int {
+	return sabi.AfterCommitOrder
+}
+
+func TestHttpDaxConn_Commit_failBeforeAfterCommitOrder(t *testing.T) {
+	api := newFakeAPI()
+	defer api.server.Close()
+
+	ds := NewHttpDaxSrc(nil).WithBaseURL(api.server.URL)
+	assert.Less(t, ds.CommitOrder(), sabi.AfterCommitOrder)
+
+	committed := false
+
+	proc := newUserProc(ds)
+	proc.AddLocalDaxSrc("after", afterCommitDaxSrc{committed: &committed})
+
+	err := proc.RunTxn(func(dax UserDax) sabi.Err {
+		_, err := dax.GetDaxConn("after")
+		if !err.IsOk() {
+			return err
+		}
+		conn, err := dax.GetHttpDaxConn("api")
+		if !err.IsOk() {
+			return err
+		}
+		return conn.Defer(Request{Method: "PUT", URL: "/broken"})
+	})
+	assert.Equal(t, err.ReasonName(), "FailToCommitDaxConn")
+	assert.False(t, committed)
+}
+
+func TestHttpDaxConn_Do_failToSendRequest(t *testing.T) {
+	api := newFakeAPI()
+	api.server.Close()
+
+	rec := NewRecorder()
+	ds := NewHttpDaxSrc(nil).WithBaseURL(api.server.URL).WithRecorder(rec)
+
+	err := newUserProc(ds).RunTxn(func(dax UserDax) sabi.Err {
+		_, err := dax.GetUser("1")
+		return err
+	})
+	switch err.Reason().(type) {
+	case FailToSendRequest:
+		assert.Equal(t, err.Get("URL"), api.server.URL+"/users/1")
+	default:
+		assert.Fail(t, err.Error())
+	}
+	exs := rec.Exchanges()
+	assert.Equal(t, len(exs), 1)
+	assert.Equal(t, exs[0].Response.StatusCode, 0)
+}
+
+func TestHttpDaxConn_Do_failToCreateRequest(t *testing.T) {
+	err := newUserProc(NewHttpDaxSrc(nil)).RunTxn(func(dax UserDax) sabi.Err {
+		conn, err := dax.GetHttpDaxConn("api")
+		if !err.IsOk() {
+			return err
+		}
+		_, err = conn.Do(Request{Method: "BAD METHOD", URL: "http://localhost/"})
+		return err
+	})
+	assert.Equal(t, err.ReasonName(), "FailToCreateRequest")
+}
+
+func TestHttpDaxConn_readOnly(t *testing.T) {
+	err := newUserProc(NewHttpDaxSrc(nil)).ReadOnly().RunTxn(func(dax UserDax) sabi.Err {
+		return dax.AddUser("bob")
+	})
+	assert.Equal(t, err.ReasonName(), "TxnIsReadOnly")
+}
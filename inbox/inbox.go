@@ -0,0 +1,237 @@
+// Copyright (C) 2023 Takayuki Sato. All Rights Reserved.
+// This program is free software under MIT License.
+// See the file LICENSE in this distribution for more details.
+
+// Package inbox provides a DaxSrc and a DaxConn of sabi which record IDs of
+// processed messages in the same transaction as business writes, so that a
+// consumer can skip messages which were already processed.
+//
+// IDs are recorded after the business writes have committed, so if the
+// process dies between them, a redelivered message is processed again.
+// So messages are processed at least once, and never recorded as processed
+// without their business writes.
+package inbox
+
+import (
+	"github.com/sttk-go/sabi"
+	"sync"
+)
+
+type /* error reasons */ (
+	// MessageAlreadyProcessed is an error reason which indicates that a
+	// message with a specified ID was already processed.
+	// The field ID is the ID of the message.
+	MessageAlreadyProcessed struct {
+		ID string
+	}
+)
+
+// InboxDaxSrc is a structure type which implements sabi.DaxSrc and creates
+// InboxDaxConn.
+// This also implements sabi.OrderedDaxSrc and its commit order is
+// sabi.LateCommitOrder.
+type InboxDaxSrc struct {
+	store Store
+}
+
+// NewInboxDaxSrc is a function which creates a new InboxDaxSrc which records
+// processed message IDs in a specified Store.
+func NewInboxDaxSrc(store Store) InboxDaxSrc {
+	return InboxDaxSrc{store: store}
+}
+
+// CreateDaxConn is a method which creates a new InboxDaxConn.
+func (ds InboxDaxSrc) CreateDaxConn() (sabi.DaxConn, sabi.Err) {
+	return &InboxDaxConn{store: ds.store}, sabi.Ok()
+}
+
+// CommitOrder is a method which returns sabi.LateCommitOrder.
+func (ds InboxDaxSrc) CommitOrder() int {
+	return sabi.LateCommitOrder
+}
+
+// InboxDaxConn is a structure type which implements sabi.DaxConn, and
+// records IDs of messages received in a transaction.
+// This implements sabi.PreparableDaxConn, and on #Prepare, checks again that
+// the messages were not processed by another transaction, so that a
+// duplicated message makes the transaction fail before any DaxConn commits.
+// The IDs are added to a Store on #Commit, which is run after the business
+// DaxConn have committed because of the commit order.
+// This also implements sabi.ForceBackDaxConn to remove the added IDs when
+// another DaxConn in the same transaction failed to commit after this, and
+// sabi.ReadOnlyDaxConn.
+type InboxDaxConn struct {
+	store     Store
+	ids       []string
+	committed []string
+	readOnly  bool
+	mutex     sync.Mutex
+}
+
+// Receive is a method which records a specified message ID as processed in
+// this transaction.
+// If the message was already processed, this method returns an Err having
+// the reason MessageAlreadyProcessed.
+// In a read-only transaction, this method returns an Err having the reason
+// sabi.TxnIsReadOnly.
+func (conn *InboxDaxConn) Receive(id string) sabi.Err {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	if conn.readOnly {
+		return sabi.ErrBy(sabi.TxnIsReadOnly{})
+	}
+
+	for _, received := range conn.ids {
+		if received == id {
+			return sabi.ErrBy(MessageAlreadyProcessed{ID: id})
+		}
+	}
+
+	processed, err := conn.store.Contains(id)
+	if !err.IsOk() {
+		return err
+	}
+	if processed {
+		return sabi.ErrBy(MessageAlreadyProcessed{ID: id})
+	}
+
+	conn.ids = append(conn.ids, id)
+	return sabi.Ok()
+}
+
+// Prepare is a method which checks that received messages were not processed
+// by another transaction after they were received.
+// If some of them were processed, this method returns an Err having the
+// reason MessageAlreadyProcessed.
+func (conn *InboxDaxConn) Prepare() sabi.Err {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	for _, id := range conn.ids {
+		processed, err := conn.store.Contains(id)
+		if !err.IsOk() {
+			return err
+		}
+		if processed {
+			return sabi.ErrBy(MessageAlreadyProcessed{ID: id})
+		}
+	}
+	return sabi.Ok()
+}
+
+// Commit is a method which adds received message IDs to the Store.
+// If some of the messages were processed by another transaction after this
+// transaction was prepared, this method returns an Err having the reason
+// MessageAlreadyProcessed.
+func (conn *InboxDaxConn) Commit() sabi.Err {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	if len(conn.ids) == 0 {
+		return sabi.Ok()
+	}
+
+	err := conn.store.Add(conn.ids...)
+	if !err.IsOk() {
+		return err
+	}
+
+	conn.committed = conn.ids
+	conn.ids = nil
+	return sabi.Ok()
+}
+
+// ForceBack is a method which removes the message IDs added on #Commit from
+// the Store.
+func (conn *InboxDaxConn) ForceBack() {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	if len(conn.committed) == 0 {
+		return
+	}
+	conn.store.Remove(conn.committed...)
+	conn.committed = nil
+}
+
+// Rollback is a method which discards received message IDs.
+func (conn *InboxDaxConn) Rollback() {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	conn.ids = nil
+}
+
+// Close is a method which discards received message IDs.
+func (conn *InboxDaxConn) Close() {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	conn.ids = nil
+	conn.committed = nil
+}
+
+// SetReadOnly is a method which makes this InboxDaxConn refuse receiving
+// messages.
+func (conn *InboxDaxConn) SetReadOnly() sabi.Err {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	conn.readOnly = true
+	return sabi.Ok()
+}
+
+// IsAlreadyProcessed is a function which determines whether a specified Err
+// is caused by MessageAlreadyProcessed.
+// This function checks the reason of the Err and also errors of DaxConn in a
+// sabi.FailToPrepareDaxConn or a sabi.FailToCommitDaxConn.
+func IsAlreadyProcessed(err sabi.Err) bool {
+	switch r := err.Reason().(type) {
+	case MessageAlreadyProcessed:
+		return true
+	case sabi.FailToPrepareDaxConn:
+		for _, e := range r.Errors {
+			if IsAlreadyProcessed(e) {
+				return true
+			}
+		}
+	case sabi.FailToCommitDaxConn:
+		for _, e := range r.Errors {
+			if IsAlreadyProcessed(e) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// IgnoreProcessed is a function which returns an Err of which reason is
+// NoError if a specified Err is caused by MessageAlreadyProcessed, otherwise
+// returns the specified Err.
+// This function is used to skip duplicated messages silently as follows:
+//
+//	err := inbox.IgnoreProcessed(proc.RunTxn(logic))
+func IgnoreProcessed(err sabi.Err) sabi.Err {
+	if IsAlreadyProcessed(err) {
+		return sabi.Ok()
+	}
+	return err
+}
+
+// InboxDax is a structure type which is embedded in a dax structure
+// consuming messages, and provides a method to get an InboxDaxConn.
+type InboxDax struct {
+	sabi.Dax
+}
+
+// NewInboxDax is a function which creates a new InboxDax with a specified
+// Dax.
+func NewInboxDax(dax sabi.Dax) InboxDax {
+	return InboxDax{Dax: dax}
+}
+
+// GetInboxDaxConn is a method which gets an InboxDaxConn by a specified name.
+func (dax InboxDax) GetInboxDaxConn(name string) (*InboxDaxConn, sabi.Err) {
+	return sabi.GetDaxConn[*InboxDaxConn](dax.Dax, name)
+}
@@ -0,0 +1,210 @@
+package inbox
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/sttk-go/sabi"
+	"github.com/sttk-go/sabi/memdax"
+	"testing"
+)
+
+type FailToRun struct{}
+
+type ConsumerDax struct {
+	InboxDax
+	memdax.MemDax[string, int]
+}
+
+func (dax ConsumerDax) Consume(id string, key string) sabi.Err {
+	conn, err := dax.GetInboxDaxConn("inbox")
+	if !err.IsOk() {
+		return err
+	}
+	err = conn.Receive(id)
+	if !err.IsOk() {
+		return err
+	}
+
+	mem, err := dax.GetMemDaxConn("mem")
+	if !err.IsOk() {
+		return err
+	}
+	n, _ := mem.Get(key)
+	return mem.Set(key, n+1)
+}
+
+func newConsumerProc(
+	store Store, mem *memdax.Store[string, int],
+) sabi.Proc[ConsumerDax] {
+	base := sabi.NewDaxBase()
+	base.AddLocalDaxSrc("inbox", NewInboxDaxSrc(store))
+	base.AddLocalDaxSrc("mem", memdax.NewMemDaxSrc(mem))
+	dax := ConsumerDax{
+		InboxDax: NewInboxDax(base),
+		MemDax:   memdax.NewMemDax[string, int](base),
+	}
+	return sabi.NewProc[ConsumerDax](base, dax)
+}
+
+func TestInboxDaxConn_duplicatedMessage(t *testing.T) {
+	store := NewMemStore()
+	mem := memdax.NewStore[string, int]()
+	proc := newConsumerProc(store, mem)
+
+	consume := func(dax ConsumerDax) sabi.Err {
+		return dax.Consume("m1", "a")
+	}
+
+	err := proc.RunTxn(consume)
+	assert.True(t, err.IsOk())
+
+	err = proc.RunTxn(consume)
+	switch err.Reason().(type) {
+	case MessageAlreadyProcessed:
+		assert.Equal(t, err.Get("ID"), "m1")
+	default:
+		assert.Fail(t, err.Error())
+	}
+	assert.Equal(t, mem.Snapshot(), map[string]int{"a": 1})
+
+	err = IgnoreProcessed(proc.RunTxn(consume))
+	assert.True(t, err.IsOk())
+	assert.Equal(t, mem.Snapshot(), map[string]int{"a": 1})
+}
+
+func TestInboxDaxConn_duplicatedInSameTxn(t *testing.T) {
+	store := NewMemStore()
+	mem := memdax.NewStore[string, int]()
+	proc := newConsumerProc(store, mem)
+
+	err := proc.RunTxn(func(dax ConsumerDax) sabi.Err {
+		err := dax.Consume("m1", "a")
+		if !err.IsOk() {
+			return err
+		}
+		return dax.Consume("m1", "a")
+	})
+	assert.Equal(t, err.ReasonName(), "MessageAlreadyProcessed")
+
+	ok, _ := store.Contains("m1")
+	assert.False(t, ok)
+	assert.Equal(t, mem.Snapshot(), map[string]int{})
+}
+
+func TestInboxDaxConn_rollback(t *testing.T) {
+	store := NewMemStore()
+	mem := memdax.NewStore[string, int]()
+	proc := newConsumerProc(store, mem)
+
+	err := proc.RunTxn(func(dax ConsumerDax) sabi.Err {
+		err := dax.Consume("m1", "a")
+		if !err.IsOk() {
+			return err
+		}
+		return sabi.ErrBy(FailToRun{})
+	})
+	assert.Equal(t, err.ReasonName(), "FailToRun")
+
+	ok, _ := store.Contains("m1")
+	assert.False(t, ok)
+
+	err = proc.RunTxn(func(dax ConsumerDax) sabi.Err {
+		return dax.Consume("m1", "a")
+	})
+	assert.True(t, err.IsOk())
+	assert.Equal(t, mem.Snapshot(), map[string]int{"a": 1})
+}
+
+func TestInboxDaxConn_Prepare_processedConcurrently(t *testing.T) {
+	store := NewMemStore()
+	mem := memdax.NewStore[string, int]()
+	proc := newConsumerProc(store, mem)
+	other := newConsumerProc(store, mem)
+
+	err := proc.RunTxn(func(dax ConsumerDax) sabi.Err {
+		err := dax.Consume("m1", "a")
+		if !err.IsOk() {
+			return err
+		}
+		return other.RunTxn(func(dax ConsumerDax) sabi.Err {
+			return dax.Consume("m1", "b")
+		})
+	})
+	switch err.Reason().(type) {
+	case sabi.FailToPrepareDaxConn:
+		errs := err.Get("Errors").(map[string]sabi.Err)
+		assert.Equal(t, errs["inbox"].ReasonName(), "MessageAlreadyProcessed")
+	default:
+		assert.Fail(t, err.Error())
+	}
+	assert.True(t, IsAlreadyProcessed(err))
+	assert.Equal(t, mem.Snapshot(), map[string]int{"b": 1})
+}
+
+func TestInboxDaxConn_otherDaxConnFailedToCommit(t *testing.T) {
+	store := NewMemStore()
+	mem := memdax.NewStore[string, int]()
+	proc := newConsumerProc(store, mem)
+	other := newConsumerProc(store, mem)
+
+	err := proc.RunTxn(func(dax ConsumerDax) sabi.Err {
+		err := dax.Consume("m1", "a")
+		if !err.IsOk() {
+			return err
+		}
+		return other.RunTxn(func(dax ConsumerDax) sabi.Err {
+			return dax.Consume("m2", "a")
+		})
+	})
+	switch err.Reason().(type) {
+	case sabi.FailToCommitDaxConn:
+		errs := err.Get("Errors").(map[string]sabi.Err)
+		assert.Equal(t, errs["mem"].ReasonName(), "WriteConflict")
+		assert.Equal(t, err.Get("Committed"), []string{})
+	default:
+		assert.Fail(t, err.Error())
+	}
+	assert.False(t, IsAlreadyProcessed(err))
+
+	ok, _ := store.Contains("m1")
+	assert.False(t, ok)
+	ok, _ = store.Contains("m2")
+	assert.True(t, ok)
+}
+
+func TestInboxDaxConn_ForceBack(t *testing.T) {
+	store := NewMemStore()
+	conn := &InboxDaxConn{store: store}
+
+	assert.True(t, conn.Receive("m1").IsOk())
+	assert.True(t, conn.Prepare().IsOk())
+	assert.True(t, conn.Commit().IsOk())
+
+	ok, _ := store.Contains("m1")
+	assert.True(t, ok)
+
+	conn.ForceBack()
+
+	ok, _ = store.Contains("m1")
+	assert.False(t, ok)
+}
+
+func TestInboxDaxSrc_CommitOrder(t *testing.T) {
+	var ds sabi.OrderedDaxSrc = NewInboxDaxSrc(nil)
+	assert.Less(t, ds.CommitOrder(), sabi.AfterCommitOrder)
+	assert.Greater(t, ds.CommitOrder(), 0)
+}
+
+func TestInboxDaxConn_readOnly(t *testing.T) {
+	proc := newConsumerProc(NewMemStore(), memdax.NewStore[string, int]())
+
+	err := proc.ReadOnly().RunTxn(func(dax ConsumerDax) sabi.Err {
+		return dax.Consume("m1", "a")
+	})
+	assert.Equal(t, err.ReasonName(), "TxnIsReadOnly")
+}
+
+func TestIgnoreProcessed(t *testing.T) {
+	assert.True(t, IgnoreProcessed(sabi.Ok()).IsOk())
+	assert.True(t, IgnoreProcessed(sabi.ErrBy(MessageAlreadyProcessed{ID: "x"})).IsOk())
+	assert.Equal(t, IgnoreProcessed(sabi.ErrBy(FailToRun{})).ReasonName(), "FailToRun")
+}
@@ -0,0 +1,159 @@
+// Copyright (C) 2023 Takayuki Sato. All Rights Reserved.
+// This program is free software under MIT License.
+// See the file LICENSE in this distribution for more details.
+
+package inbox
+
+import (
+	"encoding/gob"
+	"github.com/sttk-go/sabi"
+	"github.com/sttk-go/sabi/internal/gobfile"
+	"os"
+	"sort"
+	"sync"
+)
+
+type /* error reasons */ (
+	// FailToLoadInbox is an error reason which indicates that it failed to
+	// load processed message IDs from a file.
+	// The field Path is the path of the file.
+	// The cause of an Err having this reason is an error returned by the os
+	// package or the encoding/gob package.
+	FailToLoadInbox struct {
+		Path string
+	}
+
+	// FailToSaveInbox is an error reason which indicates that it failed to
+	// save processed message IDs to a file.
+	// The field Path is the path of the file.
+	// The cause of an Err having this reason is an error returned by the os
+	// package or the encoding/gob package.
+	FailToSaveInbox struct {
+		Path string
+	}
+)
+
+// Store is an interface which represents a backend recording IDs of
+// processed messages.
+// #Add must add all specified IDs atomically, and must return an Err having
+// the reason MessageAlreadyProcessed without adding any ID if some of them
+// are already recorded.
+type Store interface {
+	Contains(id string) (bool, sabi.Err)
+	Add(ids ...string) sabi.Err
+	Remove(ids ...string) sabi.Err
+}
+
+// MemStore is a structure type which implements Store and records processed
+// message IDs in memory.
+type MemStore struct {
+	ids      map[string]struct{}
+	filePath string
+	mutex    sync.Mutex
+}
+
+// NewMemStore is a function which creates a new empty MemStore.
+func NewMemStore() *MemStore {
+	return &MemStore{ids: make(map[string]struct{})}
+}
+
+// OpenFileStore is a function which creates a new MemStore which is persisted
+// to a file at a specified path.
+// If the file exists, this function loads processed message IDs from it, and
+// every change of the IDs is saved to it.
+func OpenFileStore(path string) (*MemStore, sabi.Err) {
+	store := &MemStore{ids: make(map[string]struct{}), filePath: path}
+
+	f, e := os.Open(path)
+	if os.IsNotExist(e) {
+		return store, sabi.Ok()
+	}
+	if e != nil {
+		return nil, sabi.ErrBy(FailToLoadInbox{Path: path}, e)
+	}
+	defer f.Close()
+
+	var ids []string
+	e = gob.NewDecoder(f).Decode(&ids)
+	if e != nil {
+		return nil, sabi.ErrBy(FailToLoadInbox{Path: path}, e)
+	}
+
+	for _, id := range ids {
+		store.ids[id] = struct{}{}
+	}
+	return store, sabi.Ok()
+}
+
+// Contains is a method which determines whether a message with a specified
+// ID is already processed.
+func (store *MemStore) Contains(id string) (bool, sabi.Err) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	_, ok := store.ids[id]
+	return ok, sabi.Ok()
+}
+
+// Add is a method which records specified IDs as processed.
+func (store *MemStore) Add(ids ...string) sabi.Err {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	for _, id := range ids {
+		if _, ok := store.ids[id]; ok {
+			return sabi.ErrBy(MessageAlreadyProcessed{ID: id})
+		}
+	}
+
+	m := store.copyIDs()
+	for _, id := range ids {
+		m[id] = struct{}{}
+	}
+	return store.replace(m)
+}
+
+// Remove is a method which deletes records of specified IDs.
+func (store *MemStore) Remove(ids ...string) sabi.Err {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	m := store.copyIDs()
+	for _, id := range ids {
+		delete(m, id)
+	}
+	return store.replace(m)
+}
+
+func (store *MemStore) copyIDs() map[string]struct{} {
+	m := make(map[string]struct{}, len(store.ids))
+	for id := range store.ids {
+		m[id] = struct{}{}
+	}
+	return m
+}
+
+func (store *MemStore) replace(m map[string]struct{}) sabi.Err {
+	if len(store.filePath) > 0 {
+		err := saveIDs(store.filePath, m)
+		if !err.IsOk() {
+			return err
+		}
+	}
+	store.ids = m
+	return sabi.Ok()
+}
+
+func saveIDs(path string, m map[string]struct{}) sabi.Err {
+	ids := make([]string, 0, len(m))
+	for id := range m {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	e := gobfile.Save(path, ids)
+	if e != nil {
+		return sabi.ErrBy(FailToSaveInbox{Path: path}, e)
+	}
+	return sabi.Ok()
+}
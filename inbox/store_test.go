@@ -0,0 +1,82 @@
+package inbox
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMemStore(t *testing.T) {
+	store := NewMemStore()
+
+	assert.True(t, store.Add("a", "b").IsOk())
+
+	err := store.Add("c", "b")
+	switch err.Reason().(type) {
+	case MessageAlreadyProcessed:
+		assert.Equal(t, err.Get("ID"), "b")
+	default:
+		assert.Fail(t, err.Error())
+	}
+
+	ok, _ := store.Contains("c")
+	assert.False(t, ok)
+
+	assert.True(t, store.Remove("a").IsOk())
+	ok, _ = store.Contains("a")
+	assert.False(t, ok)
+	ok, _ = store.Contains("b")
+	assert.True(t, ok)
+}
+
+func TestOpenFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "inbox.gob")
+
+	store, err := OpenFileStore(path)
+	assert.True(t, err.IsOk())
+	assert.True(t, store.Add("a", "b").IsOk())
+	assert.True(t, store.Remove("a").IsOk())
+
+	store, err = OpenFileStore(path)
+	assert.True(t, err.IsOk())
+	ok, _ := store.Contains("a")
+	assert.False(t, ok)
+	ok, _ = store.Contains("b")
+	assert.True(t, ok)
+}
+
+func TestOpenFileStore_failToLoadInbox(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "inbox.gob")
+	assert.Nil(t, os.WriteFile(path, []byte("broken"), 0644))
+
+	store, err := OpenFileStore(path)
+	assert.Nil(t, store)
+	switch err.Reason().(type) {
+	case FailToLoadInbox:
+		assert.Equal(t, err.Get("Path"), path)
+	default:
+		assert.Fail(t, err.Error())
+	}
+}
+
+func TestFileStore_Add_failToSaveInbox(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "dir")
+	assert.Nil(t, os.Mkdir(dir, 0755))
+	path := filepath.Join(dir, "inbox.gob")
+
+	store, err := OpenFileStore(path)
+	assert.True(t, err.IsOk())
+	assert.Nil(t, os.Remove(dir))
+
+	err = store.Add("a")
+	switch err.Reason().(type) {
+	case FailToSaveInbox:
+		assert.Equal(t, err.Get("Path"), path)
+	default:
+		assert.Fail(t, err.Error())
+	}
+
+	ok, _ := store.Contains("a")
+	assert.False(t, ok)
+}
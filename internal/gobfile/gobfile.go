@@ -0,0 +1,42 @@
+// Copyright (C) 2023 Takayuki Sato. All Rights Reserved.
+// This program is free software under MIT License.
+// See the file LICENSE in this distribution for more details.
+
+// Package gobfile provides a function which saves a value into a file in gob
+// format atomically, which is shared by packages persisting their data.
+package gobfile
+
+import (
+	"encoding/gob"
+	"os"
+	"path/filepath"
+)
+
+// Save is a function which encodes a specified value in gob format and saves
+// it into a file at a specified path.
+// The value is written into a hidden temporary file in the same directory,
+// which is synced and then renamed to the path, so the file at the path is
+// always either the old one or the new one, even if a process dies while
+// saving.
+func Save(path string, v any) error {
+	tmp, e := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if e != nil {
+		return e
+	}
+
+	e = gob.NewEncoder(tmp).Encode(v)
+	if e == nil {
+		e = tmp.Sync()
+	}
+	if e2 := tmp.Close(); e == nil {
+		e = e2
+	}
+	if e == nil {
+		e = os.Rename(tmp.Name(), path)
+	}
+	if e != nil {
+		os.Remove(tmp.Name())
+		return e
+	}
+	return nil
+}
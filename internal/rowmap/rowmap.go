@@ -0,0 +1,33 @@
+// Copyright (C) 2023 Takayuki Sato. All Rights Reserved.
+// This program is free software under MIT License.
+// See the file LICENSE in this distribution for more details.
+
+// Package rowmap provides functions which are shared by packages mapping
+// columns of rows to fields of structures.
+package rowmap
+
+import (
+	"reflect"
+	"strings"
+)
+
+// IsPromotedByPtr is a function which reports whether a field at a specified
+// index of a structure type is promoted through an embedded pointer.
+// Such fields are not mapped because setting them needs to allocate the
+// embedded structure.
+func IsPromotedByPtr(t reflect.Type, index []int) bool {
+	for _, i := range index[:len(index)-1] {
+		t = t.Field(i).Type
+		if t.Kind() == reflect.Pointer {
+			return true
+		}
+	}
+	return false
+}
+
+// NormalizeColumnName is a function which normalizes a column name or a field
+// name by removing underscores and lowering its case, so that a column name
+// in snake case matches a field name in camel case.
+func NormalizeColumnName(name string) string {
+	return strings.ToLower(strings.ReplaceAll(name, "_", ""))
+}
@@ -0,0 +1,221 @@
+// Copyright (C) 2023 Takayuki Sato. All Rights Reserved.
+// This program is free software under MIT License.
+// See the file LICENSE in this distribution for more details.
+
+// Package maildax provides a DaxSrc and a DaxConn of sabi which queue mails
+// in a transaction and send them only after the transaction is committed.
+//
+// Queued mails are stored into an outbox.Spool when the transaction is
+// prepared, before any DaxConn in it commits, and are sent on commit.
+// Mails which failed to be sent remain in the Spool and are sent again by a
+// Relay created with NewRelay.
+package maildax
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/sttk-go/sabi"
+	"github.com/sttk-go/sabi/outbox"
+	"mime"
+	"net/smtp"
+	"strings"
+)
+
+type /* error reasons */ (
+	// FailToQueueMail is an error reason which indicates that it failed to
+	// queue a mail in a transaction.
+	// The field To is the recipients of the mail.
+	// The cause of an Err having this reason is an error returned by the
+	// encoding/json package.
+	FailToQueueMail struct {
+		To []string
+	}
+
+	// FailToDecodeMail is an error reason which indicates that a message in
+	// a Spool cannot be decoded to a mail.
+	// The field ID is the ID of the message.
+	// The cause of an Err having this reason is an error returned by the
+	// encoding/json package.
+	FailToDecodeMail struct {
+		ID string
+	}
+
+	// FailToSendMail is an error reason which indicates that it failed to
+	// send a mail.
+	// The field To is the recipients of the mail.
+	// The cause of an Err having this reason is an error returned by the
+	// net/smtp package.
+	FailToSendMail struct {
+		To []string
+	}
+)
+
+// MailTopic is the topic of outbox.Message in which a mail is stored.
+const MailTopic = "mail"
+
+// Mail is a structure type which represents a plain text mail.
+type Mail struct {
+	From    string
+	To      []string
+	Subject string
+	Body    string
+}
+
+// Sender is an interface which sends a mail.
+type Sender interface {
+	Send(mail Mail) sabi.Err
+}
+
+// SmtpSender is a structure type which implements Sender and sends a mail
+// to an SMTP server.
+type SmtpSender struct {
+	addr string
+	auth smtp.Auth
+}
+
+// NewSmtpSender is a function which creates a new SmtpSender which sends
+// mails to an SMTP server at a specified address with a specified
+// authentication.
+// The auth can be nil if the server does not require authentication.
+func NewSmtpSender(addr string, auth smtp.Auth) SmtpSender {
+	return SmtpSender{addr: addr, auth: auth}
+}
+
+// Send is a method which sends a specified mail.
+func (sender SmtpSender) Send(mail Mail) sabi.Err {
+	e := smtp.SendMail(sender.addr, sender.auth, mail.From, mail.To, formatMail(mail))
+	if e != nil {
+		return sabi.ErrBy(FailToSendMail{To: mail.To}, e)
+	}
+	return sabi.Ok()
+}
+
+func formatMail(mail Mail) []byte {
+	var b bytes.Buffer
+	b.WriteString("From: " + mail.From + "\r\n")
+	b.WriteString("To: " + strings.Join(mail.To, ", ") + "\r\n")
+	b.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", mail.Subject) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+
+	body := strings.ReplaceAll(mail.Body, "\r\n", "\n")
+	b.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	return b.Bytes()
+}
+
+type mailPublisher struct {
+	sender Sender
+}
+
+func (p mailPublisher) Publish(msg outbox.Message) sabi.Err {
+	var mail Mail
+	e := json.Unmarshal(msg.Payload, &mail)
+	if e != nil {
+		return sabi.ErrBy(FailToDecodeMail{ID: msg.ID}, e)
+	}
+	return p.sender.Send(mail)
+}
+
+// NewRelay is a function which creates a new outbox.Relay which sends mails
+// left in a specified Spool with a specified Sender.
+func NewRelay(spool *outbox.Spool, sender Sender) outbox.Relay {
+	return outbox.NewRelay(spool, mailPublisher{sender: sender})
+}
+
+// MailDaxSrc is a structure type which implements sabi.DaxSrc and creates
+// MailDaxConn.
+// This also implements sabi.OrderedDaxSrc and its commit order is
+// sabi.AfterCommitOrder.
+type MailDaxSrc struct {
+	ds outbox.OutboxDaxSrc
+}
+
+// NewMailDaxSrc is a function which creates a new MailDaxSrc which stores
+// mails into a specified Spool and sends them with a specified Sender.
+func NewMailDaxSrc(spool *outbox.Spool, sender Sender) MailDaxSrc {
+	return MailDaxSrc{
+		ds: outbox.NewOutboxDaxSrc(spool, mailPublisher{sender: sender}),
+	}
+}
+
+// CreateDaxConn is a method which creates a new MailDaxConn.
+func (ds MailDaxSrc) CreateDaxConn() (sabi.DaxConn, sabi.Err) {
+	conn, err := ds.ds.CreateDaxConn()
+	if !err.IsOk() {
+		return nil, err
+	}
+	return &MailDaxConn{conn: conn.(*outbox.OutboxDaxConn)}, sabi.Ok()
+}
+
+// CommitOrder is a method which returns sabi.AfterCommitOrder.
+func (ds MailDaxSrc) CommitOrder() int {
+	return ds.ds.CommitOrder()
+}
+
+// MailDaxConn is a structure type which implements sabi.DaxConn, and queues
+// mails in a transaction.
+// This implements sabi.PreparableDaxConn, and on #Prepare, queued mails are
+// stored into a Spool.
+// On #Commit, the stored mails are sent in order.
+// If a mail failed to be sent, an Err having the reason
+// outbox.FailToPublishMessage of which cause has the reason FailToSendMail is
+// created and notified to Err handlers, and the mail and the following mails
+// are left in the Spool.
+// This also implements sabi.ReadOnlyDaxConn.
+type MailDaxConn struct {
+	conn *outbox.OutboxDaxConn
+}
+
+// Send is a method which queues a specified mail to be sent after commit.
+// In a read-only transaction, this method returns an Err having the reason
+// sabi.TxnIsReadOnly.
+func (conn *MailDaxConn) Send(mail Mail) sabi.Err {
+	b, e := json.Marshal(mail)
+	if e != nil {
+		return sabi.ErrBy(FailToQueueMail{To: mail.To}, e)
+	}
+	return conn.conn.Publish(outbox.Message{Topic: MailTopic, Payload: b})
+}
+
+// Prepare is a method which stores queued mails into a Spool.
+func (conn *MailDaxConn) Prepare() sabi.Err {
+	return conn.conn.Prepare()
+}
+
+// Commit is a method which sends mails stored by #Prepare.
+func (conn *MailDaxConn) Commit() sabi.Err {
+	return conn.conn.Commit()
+}
+
+// Rollback is a method which discards queued mails and removes mails stored
+// by #Prepare from the Spool.
+func (conn *MailDaxConn) Rollback() {
+	conn.conn.Rollback()
+}
+
+// Close is a method which discards queued mails.
+func (conn *MailDaxConn) Close() {
+	conn.conn.Close()
+}
+
+// SetReadOnly is a method which makes this MailDaxConn refuse queueing mails.
+func (conn *MailDaxConn) SetReadOnly() sabi.Err {
+	return conn.conn.SetReadOnly()
+}
+
+// MailDax is a structure type which is embedded in a dax structure sending
+// mails, and provides a method to get a MailDaxConn.
+type MailDax struct {
+	sabi.Dax
+}
+
+// NewMailDax is a function which creates a new MailDax with a specified Dax.
+func NewMailDax(dax sabi.Dax) MailDax {
+	return MailDax{Dax: dax}
+}
+
+// GetMailDaxConn is a method which gets a MailDaxConn by a specified name.
+func (dax MailDax) GetMailDaxConn(name string) (*MailDaxConn, sabi.Err) {
+	return sabi.GetDaxConn[*MailDaxConn](dax.Dax, name)
+}
@@ -0,0 +1,188 @@
+package maildax
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/sttk-go/sabi"
+	"github.com/sttk-go/sabi/outbox"
+	"os"
+	"testing"
+)
+
+type FailToRun struct{}
+
+type WelcomeDax struct {
+	MailDax
+}
+
+func (dax WelcomeDax) SendWelcome(to string) sabi.Err {
+	conn, err := dax.GetMailDaxConn("mail")
+	if !err.IsOk() {
+		return err
+	}
+	return conn.Send(Mail{
+		From:    "noreply@example.com",
+		To:      []string{to},
+		Subject: "Welcome",
+		Body:    "Hello,\nWelcome!",
+	})
+}
+
+func newWelcomeProc(ds MailDaxSrc) sabi.Proc[WelcomeDax] {
+	base := sabi.NewDaxBase()
+	base.AddLocalDaxSrc("mail", ds)
+	return sabi.NewProc[WelcomeDax](base, WelcomeDax{NewMailDax(base)})
+}
+
+func newMailDaxSrc(t *testing.T, addr string) (MailDaxSrc, *outbox.Spool) {
+	spool, err := outbox.OpenSpool(t.TempDir())
+	assert.True(t, err.IsOk())
+	return NewMailDaxSrc(spool, NewSmtpSender(addr, nil)), spool
+}
+
+func TestMailDaxConn_sendAfterCommit(t *testing.T) {
+	server := startFakeSmtpServer()
+	defer server.Close()
+
+	ds, spool := newMailDaxSrc(t, server.Addr())
+
+	err := newWelcomeProc(ds).RunTxn(func(dax WelcomeDax) sabi.Err {
+		err := dax.SendWelcome("alice@example.com")
+		if !err.IsOk() {
+			return err
+		}
+		assert.Equal(t, len(server.mails()), 0)
+		return sabi.Ok()
+	})
+	assert.True(t, err.IsOk())
+
+	mails := server.mails()
+	assert.Equal(t, len(mails), 1)
+	assert.Equal(t, mails[0].From, "noreply@example.com")
+	assert.Equal(t, mails[0].To, []string{"alice@example.com"})
+	assert.Equal(t, mails[0].Data, "From: noreply@example.com\n"+
+		"To: alice@example.com\n"+
+		"Subject: Welcome\n"+
+		"MIME-Version: 1.0\n"+
+		"Content-Type: text/plain; charset=utf-8\n"+
+		"\n"+
+		"Hello,\nWelcome!\n")
+
+	msgs, err := spool.Messages()
+	assert.True(t, err.IsOk())
+	assert.Equal(t, len(msgs), 0)
+}
+
+func TestMailDaxConn_discardOnRollback(t *testing.T) {
+	server := startFakeSmtpServer()
+	defer server.Close()
+
+	ds, spool := newMailDaxSrc(t, server.Addr())
+
+	err := newWelcomeProc(ds).RunTxn(func(dax WelcomeDax) sabi.Err {
+		err := dax.SendWelcome("alice@example.com")
+		if !err.IsOk() {
+			return err
+		}
+		return sabi.ErrBy(FailToRun{})
+	})
+	assert.Equal(t, err.ReasonName(), "FailToRun")
+	assert.Equal(t, len(server.mails()), 0)
+
+	msgs, err := spool.Messages()
+	assert.True(t, err.IsOk())
+	assert.Equal(t, len(msgs), 0)
+}
+
+func TestMailDaxConn_failToSpoolMail(t *testing.T) {
+	server := startFakeSmtpServer()
+	defer server.Close()
+
+	ds, spool := newMailDaxSrc(t, server.Addr())
+	assert.Nil(t, os.Remove(spool.Dir()))
+
+	err := newWelcomeProc(ds).RunTxn(func(dax WelcomeDax) sabi.Err {
+		return dax.SendWelcome("alice@example.com")
+	})
+	switch err.Reason().(type) {
+	case sabi.FailToPrepareDaxConn:
+		errs := err.Get("Errors").(map[string]sabi.Err)
+		assert.Equal(t, errs["mail"].ReasonName(), "FailToSpoolMessage")
+	default:
+		assert.Fail(t, err.Error())
+	}
+	assert.Equal(t, len(server.mails()), 0)
+}
+
+func TestMailDaxConn_failToSendMailAndRelay(t *testing.T) {
+	server := startFakeSmtpServer()
+	defer server.Close()
+	server.setReject(true)
+
+	ds, spool := newMailDaxSrc(t, server.Addr())
+	sender := NewSmtpSender(server.Addr(), nil)
+
+	err := newWelcomeProc(ds).RunTxn(func(dax WelcomeDax) sabi.Err {
+		return dax.SendWelcome("alice@example.com")
+	})
+	assert.True(t, err.IsOk())
+	assert.Equal(t, len(server.mails()), 0)
+
+	msgs, err := spool.Messages()
+	assert.True(t, err.IsOk())
+	assert.Equal(t, len(msgs), 1)
+	assert.Equal(t, msgs[0].Topic, MailTopic)
+
+	err = NewRelay(spool, sender).Run()
+	switch err.Reason().(type) {
+	case outbox.FailToPublishMessage:
+		cause := err.Cause().(sabi.Err)
+		switch cause.Reason().(type) {
+		case FailToSendMail:
+			assert.Equal(t, cause.Get("To"), []string{"alice@example.com"})
+		default:
+			assert.Fail(t, cause.Error())
+		}
+	default:
+		assert.Fail(t, err.Error())
+	}
+
+	server.setReject(false)
+
+	err = NewRelay(spool, sender).Run()
+	assert.True(t, err.IsOk())
+	assert.Equal(t, len(server.mails()), 1)
+
+	msgs, err = spool.Messages()
+	assert.True(t, err.IsOk())
+	assert.Equal(t, len(msgs), 0)
+}
+
+func TestMailDaxConn_readOnly(t *testing.T) {
+	ds, _ := newMailDaxSrc(t, "127.0.0.1:0")
+
+	err := newWelcomeProc(ds).ReadOnly().RunTxn(func(dax WelcomeDax) sabi.Err {
+		return dax.SendWelcome("alice@example.com")
+	})
+	assert.Equal(t, err.ReasonName(), "TxnIsReadOnly")
+}
+
+func TestRelay_failToDecodeMail(t *testing.T) {
+	_, spool := newMailDaxSrc(t, "127.0.0.1:0")
+	err := spool.Put(outbox.Message{ID: "x", Topic: MailTopic, Payload: []byte("{")})
+	assert.True(t, err.IsOk())
+
+	err = NewRelay(spool, NewSmtpSender("127.0.0.1:0", nil)).Run()
+	assert.Equal(t, err.ReasonName(), "FailToPublishMessage")
+	assert.Equal(t, err.Cause().(sabi.Err).ReasonName(), "FailToDecodeMail")
+	assert.Equal(t, err.Cause().(sabi.Err).Get("ID"), "x")
+}
+
+func TestFormatMail_encodeSubject(t *testing.T) {
+	b := formatMail(Mail{
+		From: "a@example.com", To: []string{"b@example.com", "c@example.com"},
+		Subject: "ようこそ\r\nBcc: x@example.com", Body: "x",
+	})
+	assert.Contains(t, string(b), "To: b@example.com, c@example.com\r\n")
+	assert.Contains(t, string(b), "Subject: =?utf-8?q?")
+	assert.NotContains(t, string(b), "\r\nBcc:")
+}
@@ -0,0 +1,127 @@
+package maildax
+
+import (
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+)
+
+// fakeSmtpServer is a minimal SMTP server which accepts mails without
+// authentication and TLS, and records received mails.
+type fakeSmtpServer struct {
+	listener net.Listener
+	received []receivedMail
+	reject   bool
+	mutex    sync.Mutex
+	wg       sync.WaitGroup
+}
+
+type receivedMail struct {
+	From string
+	To   []string
+	Data string
+}
+
+func startFakeSmtpServer() *fakeSmtpServer {
+	l, e := net.Listen("tcp", "127.0.0.1:0")
+	if e != nil {
+		panic(e)
+	}
+	s := &fakeSmtpServer{listener: l}
+	s.wg.Add(1)
+	go s.serve()
+	return s
+}
+
+func (s *fakeSmtpServer) Addr() string {
+	return s.listener.Addr().String()
+}
+
+func (s *fakeSmtpServer) Close() {
+	s.listener.Close()
+	s.wg.Wait()
+}
+
+func (s *fakeSmtpServer) setReject(reject bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.reject = reject
+}
+
+func (s *fakeSmtpServer) mails() []receivedMail {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]receivedMail{}, s.received...)
+}
+
+func (s *fakeSmtpServer) serve() {
+	defer s.wg.Done()
+	for {
+		c, e := s.listener.Accept()
+		if e != nil {
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer c.Close()
+			s.handle(textproto.NewConn(c))
+		}()
+	}
+}
+
+func (s *fakeSmtpServer) handle(tc *textproto.Conn) {
+	var mail receivedMail
+	tc.PrintfLine("220 localhost fake smtp")
+
+	for {
+		line, e := tc.ReadLine()
+		if e != nil {
+			return
+		}
+		cmd := strings.ToUpper(line)
+
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			tc.PrintfLine("250 localhost")
+		case strings.HasPrefix(cmd, "MAIL FROM:"):
+			mail = receivedMail{From: trimAddr(line[len("MAIL FROM:"):])}
+			tc.PrintfLine("250 OK")
+		case strings.HasPrefix(cmd, "RCPT TO:"):
+			s.mutex.Lock()
+			reject := s.reject
+			s.mutex.Unlock()
+			if reject {
+				tc.PrintfLine("550 mailbox unavailable")
+				continue
+			}
+			mail.To = append(mail.To, trimAddr(line[len("RCPT TO:"):]))
+			tc.PrintfLine("250 OK")
+		case cmd == "DATA":
+			tc.PrintfLine("354 start mail input")
+			b, e := tc.ReadDotBytes()
+			if e != nil {
+				return
+			}
+			mail.Data = string(b)
+			s.mutex.Lock()
+			s.received = append(s.received, mail)
+			s.mutex.Unlock()
+			tc.PrintfLine("250 OK")
+		case cmd == "QUIT":
+			tc.PrintfLine("221 bye")
+			return
+		default:
+			tc.PrintfLine("250 OK")
+		}
+	}
+}
+
+func trimAddr(s string) string {
+	s = strings.TrimSpace(s)
+	if i := strings.IndexByte(s, ' '); i >= 0 {
+		s = s[:i]
+	}
+	return strings.Trim(s, "<>")
+}
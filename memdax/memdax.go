@@ -0,0 +1,410 @@
+// Copyright (C) 2023 Takayuki Sato. All Rights Reserved.
+// This program is free software under MIT License.
+// See the file LICENSE in this distribution for more details.
+
+// Package memdax provides a DaxSrc and a DaxConn of sabi which access an
+// in-memory key-value store with transactional semantics.
+// This package is mainly for tests and prototypes of logics.
+package memdax
+
+import (
+	"encoding/gob"
+	"github.com/sttk-go/sabi"
+	"github.com/sttk-go/sabi/internal/gobfile"
+	"os"
+	"sync"
+)
+
+type /* error reasons */ (
+	// FailToSaveSnapshot is an error reason which indicates that it failed to
+	// save a snapshot of a Store to a file.
+	// The field Path is the path of the file.
+	// The cause of an Err having this reason is an error returned by the os
+	// package or the encoding/gob package.
+	FailToSaveSnapshot struct {
+		Path string
+	}
+
+	// FailToLoadSnapshot is an error reason which indicates that it failed to
+	// load a snapshot of a Store from a file.
+	// The field Path is the path of the file.
+	// The cause of an Err having this reason is an error returned by the os
+	// package or the encoding/gob package.
+	FailToLoadSnapshot struct {
+		Path string
+	}
+
+	// WriteConflict is an error reason which indicates that a key written in a
+	// transaction was updated or deleted by another transaction committed
+	// after the snapshot of the transaction was taken.
+	// The field Key is the conflicted key.
+	WriteConflict struct {
+		Key any
+	}
+)
+
+// Store is a structure type which holds key-value data shared by
+// transactions.
+// Committed data is held as an immutable snapshot, and a commit replaces it
+// with a new snapshot, so a transaction reads a consistent snapshot taken at
+// its first access.
+// Each entry has a version which is updated when the entry is committed, and
+// a commit fails with the reason WriteConflict if an entry to be written was
+// updated after the snapshot of the transaction was taken.
+type Store[K comparable, V any] struct {
+	data     map[K]entry[V]
+	seq      uint64
+	filePath string
+	mutex    sync.Mutex
+}
+
+type entry[V any] struct {
+	value   V
+	version uint64
+}
+
+// NewStore is a function which creates a new empty Store.
+func NewStore[K comparable, V any]() *Store[K, V] {
+	return &Store[K, V]{data: make(map[K]entry[V])}
+}
+
+// OpenStore is a function which creates a new Store which is persisted to a
+// file at a specified path.
+// If the file exists, this function loads data from it, and every commit
+// saves a snapshot of the data to it.
+// Keys and values are encoded with the encoding/gob package.
+func OpenStore[K comparable, V any](path string) (*Store[K, V], sabi.Err) {
+	store := &Store[K, V]{data: make(map[K]entry[V]), filePath: path}
+
+	f, e := os.Open(path)
+	if os.IsNotExist(e) {
+		return store, sabi.Ok()
+	}
+	if e != nil {
+		return nil, sabi.ErrBy(FailToLoadSnapshot{Path: path}, e)
+	}
+	defer f.Close()
+
+	var m map[K]V
+	e = gob.NewDecoder(f).Decode(&m)
+	if e != nil {
+		return nil, sabi.ErrBy(FailToLoadSnapshot{Path: path}, e)
+	}
+
+	store.seq++
+	for k, v := range m {
+		store.data[k] = entry[V]{value: v, version: store.seq}
+	}
+	return store, sabi.Ok()
+}
+
+// Snapshot is a method which returns a copy of committed data.
+func (store *Store[K, V]) Snapshot() map[K]V {
+	return valuesOf(store.snapshot())
+}
+
+// SaveSnapshot is a method which saves committed data to a file at a
+// specified path.
+func (store *Store[K, V]) SaveSnapshot(path string) sabi.Err {
+	return saveSnapshot(path, valuesOf(store.snapshot()))
+}
+
+func valuesOf[K comparable, V any](data map[K]entry[V]) map[K]V {
+	m := make(map[K]V, len(data))
+	for k, ent := range data {
+		m[k] = ent.value
+	}
+	return m
+}
+
+func (store *Store[K, V]) snapshot() map[K]entry[V] {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	return store.data
+}
+
+func (store *Store[K, V]) commit(
+	snapshot map[K]entry[V], writes map[K]write[V],
+) sabi.Err {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	for k := range writes {
+		if store.data[k].version != snapshot[k].version {
+			return sabi.ErrBy(WriteConflict{Key: k})
+		}
+	}
+
+	seq := store.seq + 1
+
+	data := make(map[K]entry[V], len(store.data)+len(writes))
+	for k, ent := range store.data {
+		data[k] = ent
+	}
+	for k, w := range writes {
+		if w.deleted {
+			delete(data, k)
+		} else {
+			data[k] = entry[V]{value: w.value, version: seq}
+		}
+	}
+
+	if len(store.filePath) > 0 {
+		err := saveSnapshot(store.filePath, valuesOf(data))
+		if !err.IsOk() {
+			return err
+		}
+	}
+
+	store.data = data
+	store.seq = seq
+	return sabi.Ok()
+}
+
+func saveSnapshot[K comparable, V any](path string, data map[K]V) sabi.Err {
+	e := gobfile.Save(path, data)
+	if e != nil {
+		return sabi.ErrBy(FailToSaveSnapshot{Path: path}, e)
+	}
+	return sabi.Ok()
+}
+
+type write[V any] struct {
+	value   V
+	deleted bool
+}
+
+// MemDaxSrc is a structure type which implements sabi.DaxSrc and creates
+// MemDaxConn to a Store.
+type MemDaxSrc[K comparable, V any] struct {
+	store *Store[K, V]
+}
+
+// NewMemDaxSrc is a function which creates a new MemDaxSrc with a specified
+// Store.
+func NewMemDaxSrc[K comparable, V any](store *Store[K, V]) MemDaxSrc[K, V] {
+	return MemDaxSrc[K, V]{store: store}
+}
+
+// CreateDaxConn is a method which creates a new MemDaxConn.
+func (ds MemDaxSrc[K, V]) CreateDaxConn() (sabi.DaxConn, sabi.Err) {
+	return &MemDaxConn[K, V]{store: ds.store}, sabi.Ok()
+}
+
+// MemDaxConn is a structure type which implements sabi.DaxConn, and reads
+// and writes data of a Store in a transaction.
+// Writes are buffered in this DaxConn and are reflected to the Store only when
+// committed.
+// Reads see a snapshot of the Store taken at the first access in the
+// transaction, overlaid with the buffered writes.
+// This also implements sabi.ReadOnlyDaxConn and sabi.SavepointDaxConn.
+type MemDaxConn[K comparable, V any] struct {
+	store      *Store[K, V]
+	snapshot   map[K]entry[V]
+	writes     map[K]write[V]
+	savepoints map[string]map[K]write[V]
+	readOnly   bool
+	mutex      sync.Mutex
+}
+
+func (conn *MemDaxConn[K, V]) begin() {
+	if conn.snapshot == nil {
+		conn.snapshot = conn.store.snapshot()
+		conn.writes = make(map[K]write[V])
+	}
+}
+
+// Get is a method which gets a value associated with a specified key.
+// The second result is false if the key is not found.
+func (conn *MemDaxConn[K, V]) Get(key K) (V, bool) {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	conn.begin()
+
+	w, ok := conn.writes[key]
+	if ok {
+		return w.value, !w.deleted
+	}
+	ent, ok := conn.snapshot[key]
+	return ent.value, ok
+}
+
+// Set is a method which associates a value with a specified key.
+// In a read-only transaction, this method returns an Err having the reason
+// sabi.TxnIsReadOnly.
+func (conn *MemDaxConn[K, V]) Set(key K, value V) sabi.Err {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	if conn.readOnly {
+		return sabi.ErrBy(sabi.TxnIsReadOnly{})
+	}
+	conn.begin()
+
+	conn.writes[key] = write[V]{value: value}
+	return sabi.Ok()
+}
+
+// Delete is a method which deletes a value associated with a specified key.
+// In a read-only transaction, this method returns an Err having the reason
+// sabi.TxnIsReadOnly.
+func (conn *MemDaxConn[K, V]) Delete(key K) sabi.Err {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	if conn.readOnly {
+		return sabi.ErrBy(sabi.TxnIsReadOnly{})
+	}
+	conn.begin()
+
+	conn.writes[key] = write[V]{deleted: true}
+	return sabi.Ok()
+}
+
+// Range is a method which calls a specified function for each key and value
+// in no particular order.
+// If the function returns false, this method stops the iteration.
+func (conn *MemDaxConn[K, V]) Range(fn func(key K, value V) bool) {
+	conn.mutex.Lock()
+	conn.begin()
+	data := valuesOf(conn.snapshot)
+	for k, w := range conn.writes {
+		if w.deleted {
+			delete(data, k)
+		} else {
+			data[k] = w.value
+		}
+	}
+	conn.mutex.Unlock()
+
+	for k, v := range data {
+		if !fn(k, v) {
+			return
+		}
+	}
+}
+
+// Commit is a method which reflects buffered writes to the Store.
+// If a key written in this transaction was updated or deleted by another
+// transaction after the snapshot of this transaction was taken, this method
+// returns an Err having the reason WriteConflict and reflects no writes.
+func (conn *MemDaxConn[K, V]) Commit() sabi.Err {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	if len(conn.writes) == 0 {
+		return sabi.Ok()
+	}
+	return conn.store.commit(conn.snapshot, conn.writes)
+}
+
+// Rollback is a method which discards buffered writes.
+func (conn *MemDaxConn[K, V]) Rollback() {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	conn.writes = nil
+	conn.snapshot = nil
+}
+
+// Close is a method which releases the snapshot and buffered writes.
+func (conn *MemDaxConn[K, V]) Close() {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	conn.writes = nil
+	conn.snapshot = nil
+	conn.savepoints = nil
+}
+
+// SetReadOnly is a method which makes this MemDaxConn refuse writes.
+func (conn *MemDaxConn[K, V]) SetReadOnly() sabi.Err {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	conn.readOnly = true
+	return sabi.Ok()
+}
+
+// Savepoint is a method which saves buffered writes with a specified name.
+func (conn *MemDaxConn[K, V]) Savepoint(name string) sabi.Err {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	conn.begin()
+
+	if conn.savepoints == nil {
+		conn.savepoints = make(map[string]map[K]write[V])
+	}
+	conn.savepoints[name] = copyWrites(conn.writes)
+	return sabi.Ok()
+}
+
+// RollbackTo is a method which restores buffered writes saved with a
+// specified name.
+func (conn *MemDaxConn[K, V]) RollbackTo(name string) sabi.Err {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	writes, ok := conn.savepoints[name]
+	if ok {
+		conn.writes = writes
+		delete(conn.savepoints, name)
+	}
+	return sabi.Ok()
+}
+
+// Release is a method which discards buffered writes saved with a specified
+// name.
+func (conn *MemDaxConn[K, V]) Release(name string) sabi.Err {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	delete(conn.savepoints, name)
+	return sabi.Ok()
+}
+
+// IsWriteConflict is a function which determines whether a specified Err is
+// caused by WriteConflict.
+// This function checks the reason of the Err and also errors of DaxConn in a
+// sabi.FailToCommitDaxConn, so it can be used as RetryPolicy#IsRetryable of
+// sabi.
+func IsWriteConflict(err sabi.Err) bool {
+	switch r := err.Reason().(type) {
+	case WriteConflict:
+		return true
+	case sabi.FailToCommitDaxConn:
+		for _, e := range r.Errors {
+			if IsWriteConflict(e) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func copyWrites[K comparable, V any](writes map[K]write[V]) map[K]write[V] {
+	m := make(map[K]write[V], len(writes))
+	for k, w := range writes {
+		m[k] = w
+	}
+	return m
+}
+
+// MemDax is a structure type which is embedded in a dax structure accessing
+// a Store, and provides a method to get a MemDaxConn.
+type MemDax[K comparable, V any] struct {
+	sabi.Dax
+}
+
+// NewMemDax is a function which creates a new MemDax with a specified Dax.
+func NewMemDax[K comparable, V any](dax sabi.Dax) MemDax[K, V] {
+	return MemDax[K, V]{Dax: dax}
+}
+
+// GetMemDaxConn is a method which gets a MemDaxConn by a specified name.
+func (dax MemDax[K, V]) GetMemDaxConn(name string) (*MemDaxConn[K, V], sabi.Err) {
+	return sabi.GetDaxConn[*MemDaxConn[K, V]](dax.Dax, name)
+}
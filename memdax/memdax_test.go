@@ -0,0 +1,392 @@
+package memdax
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/sttk-go/sabi"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type FailToRun struct{}
+
+type CounterDax struct {
+	MemDax[string, int]
+}
+
+func (dax CounterDax) Increment(key string) sabi.Err {
+	conn, err := dax.GetMemDaxConn("mem")
+	if !err.IsOk() {
+		return err
+	}
+	n, _ := conn.Get(key)
+	return conn.Set(key, n+1)
+}
+
+func newCounterProc(store *Store[string, int]) sabi.Proc[CounterDax] {
+	base := sabi.NewDaxBase()
+	base.AddLocalDaxSrc("mem", NewMemDaxSrc(store))
+	dax := CounterDax{MemDax: NewMemDax[string, int](base)}
+	return sabi.NewProc[CounterDax](base, dax)
+}
+
+func TestMemDaxConn_commit(t *testing.T) {
+	store := NewStore[string, int]()
+	proc := newCounterProc(store)
+
+	err := proc.RunTxn(func(dax CounterDax) sabi.Err {
+		err := dax.Increment("a")
+		if !err.IsOk() {
+			return err
+		}
+		return dax.Increment("a")
+	})
+	assert.True(t, err.IsOk())
+	assert.Equal(t, store.Snapshot(), map[string]int{"a": 2})
+}
+
+func TestMemDaxConn_rollback(t *testing.T) {
+	store := NewStore[string, int]()
+	proc := newCounterProc(store)
+
+	err := proc.RunTxn(func(dax CounterDax) sabi.Err {
+		return dax.Increment("a")
+	})
+	assert.True(t, err.IsOk())
+
+	err = proc.RunTxn(func(dax CounterDax) sabi.Err {
+		err := dax.Increment("a")
+		if !err.IsOk() {
+			return err
+		}
+		err = dax.Increment("b")
+		if !err.IsOk() {
+			return err
+		}
+		return sabi.ErrBy(FailToRun{})
+	})
+	assert.Equal(t, err.ReasonName(), "FailToRun")
+	assert.Equal(t, store.Snapshot(), map[string]int{"a": 1})
+}
+
+func TestMemDaxConn_Delete(t *testing.T) {
+	store := NewStore[string, int]()
+	proc := newCounterProc(store)
+
+	err := proc.RunTxn(func(dax CounterDax) sabi.Err {
+		err := dax.Increment("a")
+		if !err.IsOk() {
+			return err
+		}
+		return dax.Increment("b")
+	})
+	assert.True(t, err.IsOk())
+
+	err = proc.RunTxn(func(dax CounterDax) sabi.Err {
+		conn, err := dax.GetMemDaxConn("mem")
+		if !err.IsOk() {
+			return err
+		}
+		err = conn.Delete("a")
+		if !err.IsOk() {
+			return err
+		}
+		_, ok := conn.Get("a")
+		assert.False(t, ok)
+
+		m := make(map[string]int)
+		conn.Range(func(k string, v int) bool {
+			m[k] = v
+			return true
+		})
+		assert.Equal(t, m, map[string]int{"b": 1})
+		return sabi.Ok()
+	})
+	assert.True(t, err.IsOk())
+	assert.Equal(t, store.Snapshot(), map[string]int{"b": 1})
+}
+
+func TestMemDaxConn_snapshotRead(t *testing.T) {
+	store := NewStore[string, int]()
+	proc := newCounterProc(store)
+	other := newCounterProc(store)
+
+	err := proc.RunTxn(func(dax CounterDax) sabi.Err {
+		conn, err := dax.GetMemDaxConn("mem")
+		if !err.IsOk() {
+			return err
+		}
+		_, ok := conn.Get("a")
+		assert.False(t, ok)
+
+		err = other.RunTxn(func(dax CounterDax) sabi.Err {
+			return dax.Increment("a")
+		})
+		assert.True(t, err.IsOk())
+
+		_, ok = conn.Get("a")
+		assert.False(t, ok)
+		return sabi.Ok()
+	})
+	assert.True(t, err.IsOk())
+	assert.Equal(t, store.Snapshot(), map[string]int{"a": 1})
+}
+
+func TestMemDaxConn_readOnly(t *testing.T) {
+	store := NewStore[string, int]()
+	proc := newCounterProc(store)
+
+	err := proc.ReadOnly().RunTxn(func(dax CounterDax) sabi.Err {
+		conn, err := dax.GetMemDaxConn("mem")
+		if !err.IsOk() {
+			return err
+		}
+		err = conn.Delete("a")
+		assert.Equal(t, err.ReasonName(), "TxnIsReadOnly")
+		return dax.Increment("a")
+	})
+	assert.Equal(t, err.ReasonName(), "TxnIsReadOnly")
+	assert.Equal(t, store.Snapshot(), map[string]int{})
+}
+
+func TestMemDaxConn_Savepoint(t *testing.T) {
+	store := NewStore[string, int]()
+
+	base := sabi.NewDaxBase()
+	base.AddLocalDaxSrc("mem", NewMemDaxSrc(store))
+	dax := CounterDax{MemDax: NewMemDax[string, int](base)}
+	proc := sabi.NewProc[CounterDax](base, dax)
+
+	err := proc.RunTxn(func(dax CounterDax) sabi.Err {
+		err := dax.Increment("a")
+		if !err.IsOk() {
+			return err
+		}
+		err = sabi.Savepoint(base, func() sabi.Err {
+			err := dax.Increment("a")
+			if !err.IsOk() {
+				return err
+			}
+			return sabi.ErrBy(FailToRun{})
+		})
+		assert.Equal(t, err.ReasonName(), "FailToRun")
+		return sabi.Savepoint(base, func() sabi.Err {
+			return dax.Increment("b")
+		})
+	})
+	assert.True(t, err.IsOk())
+	assert.Equal(t, store.Snapshot(), map[string]int{"a": 1, "b": 1})
+}
+
+func TestMemDax_GetMemDaxConn_daxConnIsNotExpectedType(t *testing.T) {
+	base := sabi.NewDaxBase()
+	base.AddLocalDaxSrc("mem", NewMemDaxSrc(NewStore[string, string]()))
+
+	_, err := NewMemDax[string, int](base).GetMemDaxConn("mem")
+	assert.Equal(t, err.ReasonName(), "DaxConnIsNotExpectedType")
+}
+
+func TestOpenStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.gob")
+
+	store, err := OpenStore[string, int](path)
+	assert.True(t, err.IsOk())
+	assert.Equal(t, store.Snapshot(), map[string]int{})
+
+	proc := newCounterProc(store)
+	err = proc.RunTxn(func(dax CounterDax) sabi.Err {
+		return dax.Increment("a")
+	})
+	assert.True(t, err.IsOk())
+
+	err = proc.RunTxn(func(dax CounterDax) sabi.Err {
+		err := dax.Increment("b")
+		if !err.IsOk() {
+			return err
+		}
+		return sabi.ErrBy(FailToRun{})
+	})
+	assert.False(t, err.IsOk())
+
+	store, err = OpenStore[string, int](path)
+	assert.True(t, err.IsOk())
+	assert.Equal(t, store.Snapshot(), map[string]int{"a": 1})
+}
+
+func TestOpenStore_failToLoadSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.gob")
+	assert.Nil(t, os.WriteFile(path, []byte("broken"), 0644))
+
+	store, err := OpenStore[string, int](path)
+	assert.Nil(t, store)
+	switch err.Reason().(type) {
+	case FailToLoadSnapshot:
+		assert.Equal(t, err.Get("Path"), path)
+	default:
+		assert.Fail(t, err.Error())
+	}
+}
+
+func TestOpenStore_failToSaveSnapshot(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "dir")
+	assert.Nil(t, os.Mkdir(dir, 0755))
+	path := filepath.Join(dir, "store.gob")
+
+	store, err := OpenStore[string, int](path)
+	assert.True(t, err.IsOk())
+	assert.Nil(t, os.Remove(dir))
+
+	proc := newCounterProc(store)
+	err = proc.RunTxn(func(dax CounterDax) sabi.Err {
+		return dax.Increment("a")
+	})
+	switch err.Reason().(type) {
+	case sabi.FailToCommitDaxConn:
+		errs := err.Get("Errors").(map[string]sabi.Err)
+		assert.Equal(t, errs["mem"].ReasonName(), "FailToSaveSnapshot")
+		assert.Equal(t, errs["mem"].Get("Path"), path)
+	default:
+		assert.Fail(t, err.Error())
+	}
+	assert.Equal(t, store.Snapshot(), map[string]int{})
+}
+
+func TestStore_SaveSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.gob")
+
+	store := NewStore[string, int]()
+	proc := newCounterProc(store)
+	err := proc.RunTxn(func(dax CounterDax) sabi.Err {
+		return dax.Increment("a")
+	})
+	assert.True(t, err.IsOk())
+
+	err = store.SaveSnapshot(path)
+	assert.True(t, err.IsOk())
+
+	loaded, err := OpenStore[string, int](path)
+	assert.True(t, err.IsOk())
+	assert.Equal(t, loaded.Snapshot(), map[string]int{"a": 1})
+}
+
+func TestMemDaxConn_Commit_writeConflict(t *testing.T) {
+	store := NewStore[string, int]()
+	proc := newCounterProc(store)
+	other := newCounterProc(store)
+
+	err := proc.RunTxn(func(dax CounterDax) sabi.Err {
+		return dax.Increment("a")
+	})
+	assert.True(t, err.IsOk())
+
+	err = proc.RunTxn(func(dax CounterDax) sabi.Err {
+		err := dax.Increment("a")
+		if !err.IsOk() {
+			return err
+		}
+		return other.RunTxn(func(dax CounterDax) sabi.Err {
+			return dax.Increment("a")
+		})
+	})
+	switch err.Reason().(type) {
+	case sabi.FailToCommitDaxConn:
+		errs := err.Get("Errors").(map[string]sabi.Err)
+		assert.Equal(t, errs["mem"].ReasonName(), "WriteConflict")
+		assert.Equal(t, errs["mem"].Get("Key"), "a")
+	default:
+		assert.Fail(t, err.Error())
+	}
+	assert.True(t, IsWriteConflict(err))
+	assert.Equal(t, store.Snapshot(), map[string]int{"a": 2})
+}
+
+func TestMemDaxConn_Commit_writeConflictOfInsertAndDelete(t *testing.T) {
+	store := NewStore[string, int]()
+	proc := newCounterProc(store)
+	other := newCounterProc(store)
+
+	err := proc.RunTxn(func(dax CounterDax) sabi.Err {
+		err := dax.Increment("a")
+		if !err.IsOk() {
+			return err
+		}
+		return other.RunTxn(func(dax CounterDax) sabi.Err {
+			return dax.Increment("a")
+		})
+	})
+	assert.True(t, IsWriteConflict(err))
+	assert.Equal(t, store.Snapshot(), map[string]int{"a": 1})
+
+	err = proc.RunTxn(func(dax CounterDax) sabi.Err {
+		conn, err := dax.GetMemDaxConn("mem")
+		if !err.IsOk() {
+			return err
+		}
+		err = conn.Delete("a")
+		if !err.IsOk() {
+			return err
+		}
+		return other.RunTxn(func(dax CounterDax) sabi.Err {
+			return dax.Increment("a")
+		})
+	})
+	assert.True(t, IsWriteConflict(err))
+	assert.Equal(t, store.Snapshot(), map[string]int{"a": 2})
+}
+
+func TestMemDaxConn_Commit_noConflictOfDifferentKeys(t *testing.T) {
+	store := NewStore[string, int]()
+	proc := newCounterProc(store)
+	other := newCounterProc(store)
+
+	err := proc.RunTxn(func(dax CounterDax) sabi.Err {
+		err := dax.Increment("a")
+		if !err.IsOk() {
+			return err
+		}
+		return other.RunTxn(func(dax CounterDax) sabi.Err {
+			return dax.Increment("b")
+		})
+	})
+	assert.True(t, err.IsOk())
+	assert.Equal(t, store.Snapshot(), map[string]int{"a": 1, "b": 1})
+}
+
+func TestMemDaxConn_Commit_retryOnWriteConflict(t *testing.T) {
+	store := NewStore[string, int]()
+	proc := newCounterProc(store).WithRetry(sabi.RetryPolicy{
+		MaxAttempts: 3,
+		IsRetryable: IsWriteConflict,
+	})
+	other := newCounterProc(store)
+
+	attempts := 0
+	err := proc.RunTxn(func(dax CounterDax) sabi.Err {
+		attempts++
+		err := dax.Increment("a")
+		if !err.IsOk() {
+			return err
+		}
+		if attempts > 1 {
+			return sabi.Ok()
+		}
+		return other.RunTxn(func(dax CounterDax) sabi.Err {
+			return dax.Increment("a")
+		})
+	})
+	assert.True(t, err.IsOk())
+	assert.Equal(t, attempts, 2)
+	assert.Equal(t, store.Snapshot(), map[string]int{"a": 2})
+}
+
+func TestIsWriteConflict(t *testing.T) {
+	assert.True(t, IsWriteConflict(sabi.ErrBy(WriteConflict{Key: "a"})))
+	assert.False(t, IsWriteConflict(sabi.ErrBy(FailToRun{})))
+	assert.False(t, IsWriteConflict(sabi.Ok()))
+	assert.True(t, IsWriteConflict(sabi.ErrBy(sabi.FailToCommitDaxConn{
+		Errors: map[string]sabi.Err{
+			"x": sabi.ErrBy(FailToRun{}),
+			"y": sabi.ErrBy(WriteConflict{Key: 1}),
+		},
+	})))
+}
@@ -0,0 +1,384 @@
+// Copyright (C) 2023 Takayuki Sato. All Rights Reserved.
+// This program is free software under MIT License.
+// See the file LICENSE in this distribution for more details.
+
+// Package migrate provides a schema migration runner which applies and
+// reverts migration steps as sabi transactions.
+package migrate
+
+import (
+	"github.com/sttk-go/sabi"
+	"sort"
+)
+
+type /* error reasons */ (
+	// FailToMigrate is an error reason which indicates that a migration step
+	// failed.
+	// The field Version and Name are the version and the name of the failed
+	// migration, and the field Direction is "up" or "down".
+	// The cause of an Err having this reason is an Err returned by the step.
+	FailToMigrate struct {
+		Version   int64
+		Name      string
+		Direction string
+	}
+
+	// MigrationIsNotReversible is an error reason which indicates that a
+	// migration to be reverted has no Down function.
+	// The field Version and Name are the version and the name of the
+	// migration.
+	MigrationIsNotReversible struct {
+		Version int64
+		Name    string
+	}
+
+	// MigrationIsNotFound is an error reason which indicates that an applied
+	// version recorded in a version table is not found in the migrations of a
+	// Migrator.
+	// The field Version is the applied version.
+	MigrationIsNotFound struct {
+		Version int64
+	}
+
+	// DuplicatedMigrationVersion is an error reason which indicates that
+	// multiple migrations have a same version.
+	// The field Version is the duplicated version.
+	DuplicatedMigrationVersion struct {
+		Version int64
+	}
+)
+
+const (
+	directionUp   = "up"
+	directionDown = "down"
+)
+
+// VersionDax is an interface which accesses a version table recording
+// versions of applied migrations.
+// A dax type of a Migrator is required to implement this interface, and
+// methods of it are called in the same transaction as a migration step.
+// #GetAppliedVersions is required not to change the database, and to return
+// no version if the version table does not exist.
+type VersionDax interface {
+	GetAppliedVersions() ([]int64, sabi.Err)
+	AddAppliedVersion(version int64, name string) sabi.Err
+	RemoveAppliedVersion(version int64) sabi.Err
+}
+
+// VersionTableCreator is an interface which is optionally implemented by a
+// VersionDax to create a version table if it does not exist.
+// #CreateVersionTable is called only before applying a migration, so
+// Migrator#Status and reverting migrations do not create the table.
+type VersionTableCreator interface {
+	CreateVersionTable() sabi.Err
+}
+
+// Migration is a structure type which represents a migration step.
+// The field Version is a unique number which decides the order of migrations,
+// and the field Name is a description of the migration.
+// The field Up is a logic function which applies the migration, and the field
+// Down is a logic function which reverts it. Down can be nil if the migration
+// is not reversible.
+type Migration[D VersionDax] struct {
+	Version int64
+	Name    string
+	Up      func(dax D) sabi.Err
+	Down    func(dax D) sabi.Err
+}
+
+// MigrationStatus is a structure type which represents whether a migration is
+// applied or not.
+type MigrationStatus struct {
+	Version int64
+	Name    string
+	Applied bool
+}
+
+// Migrator is a structure type which applies and reverts migrations with a
+// sabi.Proc.
+// Each migration step is run in its own transaction, and the step and the
+// update of the version table are committed or rollbacked together.
+type Migrator[D VersionDax] struct {
+	proc       sabi.Proc[D]
+	migrations []Migration[D]
+	err        sabi.Err
+}
+
+// NewMigrator is a function which creates a new Migrator with a specified
+// sabi.Proc and migrations.
+// Migrations are sorted by their versions.
+// If multiple migrations have a same version, operations of the Migrator
+// return an Err having the reason DuplicatedMigrationVersion.
+func NewMigrator[D VersionDax](
+	proc sabi.Proc[D], migrations ...Migration[D],
+) Migrator[D] {
+	a := make([]Migration[D], len(migrations))
+	copy(a, migrations)
+	sort.SliceStable(a, func(i, j int) bool {
+		return a[i].Version < a[j].Version
+	})
+
+	err := sabi.Ok()
+	for i := 1; i < len(a); i++ {
+		if a[i].Version == a[i-1].Version {
+			err = sabi.ErrBy(DuplicatedMigrationVersion{Version: a[i].Version})
+			break
+		}
+	}
+
+	return Migrator[D]{proc: proc, migrations: a, err: err}
+}
+
+// Up is a method which creates a sabi.Runner which applies all migrations
+// which are not applied yet in ascending order of versions.
+// The runner is a sequence of transactions created by sabi.Seq, so it stops
+// at the first failed migration and the migrations before it remain applied.
+func (m Migrator[D]) Up() sabi.Runner {
+	return m.upRunner(func(mig Migration[D]) bool { return true })
+}
+
+// UpTo is a method which creates a sabi.Runner which applies migrations of
+// which versions are less than or equal to a specified version in the same
+// way as #Up.
+func (m Migrator[D]) UpTo(version int64) sabi.Runner {
+	return m.upRunner(func(mig Migration[D]) bool {
+		return mig.Version <= version
+	})
+}
+
+func (m Migrator[D]) upRunner(fn func(mig Migration[D]) bool) sabi.Runner {
+	if !m.err.IsOk() {
+		return errRunner{err: m.err}
+	}
+
+	runners := make([]sabi.Runner, 0, len(m.migrations))
+	for _, mig := range m.migrations {
+		if fn(mig) {
+			runners = append(runners, m.upStep(mig))
+		}
+	}
+	return sabi.Seq(runners...)
+}
+
+// DownTo is a method which creates a sabi.Runner which reverts applied
+// migrations of which versions are greater than a specified version in
+// descending order of versions.
+// The runner is a sequence of transactions created by sabi.Seq, so it stops
+// at the first failed migration.
+func (m Migrator[D]) DownTo(version int64) sabi.Runner {
+	if !m.err.IsOk() {
+		return errRunner{err: m.err}
+	}
+
+	runners := make([]sabi.Runner, 0, len(m.migrations))
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		mig := m.migrations[i]
+		if mig.Version <= version {
+			break
+		}
+		runners = append(runners, m.downStep(mig))
+	}
+	return sabi.Seq(runners...)
+}
+
+// Down is a method which creates a sabi.Runner which reverts the latest
+// applied migration.
+// If no migration is applied, the runner does nothing.
+func (m Migrator[D]) Down() sabi.Runner {
+	if !m.err.IsOk() {
+		return errRunner{err: m.err}
+	}
+	return downLatestRunner[D]{migrator: m}
+}
+
+// Status is a method which returns statuses of all migrations of this
+// Migrator in ascending order of versions.
+// This method reads the version table in a read-only transaction, and reports
+// all migrations as not applied if the version table does not exist.
+// If the version table has a version which is not found in the migrations,
+// this method returns an Err having the reason MigrationIsNotFound.
+func (m Migrator[D]) Status() ([]MigrationStatus, sabi.Err) {
+	if !m.err.IsOk() {
+		return nil, m.err
+	}
+
+	versions, err := m.getAppliedVersions(m.proc.ReadOnly())
+	if !err.IsOk() {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, len(m.migrations))
+	for i, mig := range m.migrations {
+		_, applied := versions[mig.Version]
+		delete(versions, mig.Version)
+		statuses[i] = MigrationStatus{
+			Version: mig.Version,
+			Name:    mig.Name,
+			Applied: applied,
+		}
+	}
+
+	if len(versions) > 0 {
+		return nil, sabi.ErrBy(MigrationIsNotFound{Version: minVersion(versions)})
+	}
+	return statuses, sabi.Ok()
+}
+
+func (m Migrator[D]) getAppliedVersions(
+	proc sabi.Proc[D],
+) (map[int64]struct{}, sabi.Err) {
+	var versions map[int64]struct{}
+	err := proc.RunTxn(func(dax D) sabi.Err {
+		var err sabi.Err
+		versions, err = getAppliedVersionSet(dax)
+		return err
+	})
+	return versions, err
+}
+
+func getAppliedVersionSet(dax VersionDax) (map[int64]struct{}, sabi.Err) {
+	a, err := dax.GetAppliedVersions()
+	if !err.IsOk() {
+		return nil, err
+	}
+	m := make(map[int64]struct{}, len(a))
+	for _, v := range a {
+		m[v] = struct{}{}
+	}
+	return m, sabi.Ok()
+}
+
+func (m Migrator[D]) upStep(mig Migration[D]) sabi.Runner {
+	txn := m.proc.Txn(func(dax D) sabi.Err {
+		creator, ok := any(dax).(VersionTableCreator)
+		if ok {
+			err := creator.CreateVersionTable()
+			if !err.IsOk() {
+				return err
+			}
+		}
+
+		versions, err := getAppliedVersionSet(dax)
+		if !err.IsOk() {
+			return err
+		}
+		if _, applied := versions[mig.Version]; applied {
+			return sabi.Ok()
+		}
+		err = mig.Up(dax)
+		if !err.IsOk() {
+			return err
+		}
+		return dax.AddAppliedVersion(mig.Version, mig.Name)
+	})
+	return stepRunner{
+		txn:       txn,
+		version:   mig.Version,
+		name:      mig.Name,
+		direction: directionUp,
+	}
+}
+
+func (m Migrator[D]) downStep(mig Migration[D]) sabi.Runner {
+	txn := m.proc.Txn(func(dax D) sabi.Err {
+		versions, err := getAppliedVersionSet(dax)
+		if !err.IsOk() {
+			return err
+		}
+		if _, applied := versions[mig.Version]; !applied {
+			return sabi.Ok()
+		}
+		if mig.Down == nil {
+			return sabi.ErrBy(MigrationIsNotReversible{
+				Version: mig.Version,
+				Name:    mig.Name,
+			})
+		}
+		err = mig.Down(dax)
+		if !err.IsOk() {
+			return err
+		}
+		return dax.RemoveAppliedVersion(mig.Version)
+	})
+	return stepRunner{
+		txn:       txn,
+		version:   mig.Version,
+		name:      mig.Name,
+		direction: directionDown,
+	}
+}
+
+type stepRunner struct {
+	txn       sabi.Runner
+	version   int64
+	name      string
+	direction string
+}
+
+func (r stepRunner) Run() sabi.Err {
+	err := r.txn.Run()
+	if !err.IsOk() {
+		return sabi.ErrBy(FailToMigrate{
+			Version:   r.version,
+			Name:      r.name,
+			Direction: r.direction,
+		}, err)
+	}
+	return sabi.Ok()
+}
+
+type downLatestRunner[D VersionDax] struct {
+	migrator Migrator[D]
+}
+
+func (r downLatestRunner[D]) Run() sabi.Err {
+	versions, err := r.migrator.getAppliedVersions(r.migrator.proc)
+	if !err.IsOk() {
+		return err
+	}
+
+	if len(versions) == 0 {
+		return sabi.Ok()
+	}
+
+	latest := maxVersion(versions)
+
+	for _, mig := range r.migrator.migrations {
+		if mig.Version == latest {
+			return r.migrator.downStep(mig).Run()
+		}
+	}
+	return sabi.ErrBy(MigrationIsNotFound{Version: latest})
+}
+
+func minVersion(versions map[int64]struct{}) int64 {
+	first := true
+	var v int64
+	for version := range versions {
+		if first || version < v {
+			v = version
+			first = false
+		}
+	}
+	return v
+}
+
+func maxVersion(versions map[int64]struct{}) int64 {
+	first := true
+	var v int64
+	for version := range versions {
+		if first || version > v {
+			v = version
+			first = false
+		}
+	}
+	return v
+}
+
+type errRunner struct {
+	err sabi.Err
+}
+
+func (r errRunner) Run() sabi.Err {
+	return r.err
+}
@@ -0,0 +1,408 @@
+package migrate
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/sttk-go/sabi"
+	"sort"
+	"testing"
+)
+
+var logs []string
+
+type FailToRun struct{}
+
+// MemDaxSrc is a DaxSrc which holds applied versions and executed statements
+// in memory, and reflects changes in a transaction only when it is committed.
+type MemDaxSrc struct {
+	store *memStore
+}
+
+type memStore struct {
+	versions map[int64]string
+	tables   []string
+}
+
+func newMemDaxSrc() MemDaxSrc {
+	return MemDaxSrc{store: &memStore{versions: make(map[int64]string)}}
+}
+
+func (ds MemDaxSrc) CreateDaxConn() (sabi.DaxConn, sabi.Err) {
+	versions := make(map[int64]string)
+	for k, v := range ds.store.versions {
+		versions[k] = v
+	}
+	tables := append([]string{}, ds.store.tables...)
+	return &MemDaxConn{store: ds.store, versions: versions, tables: tables}, sabi.Ok()
+}
+
+type MemDaxConn struct {
+	store    *memStore
+	versions map[int64]string
+	tables   []string
+}
+
+func (conn *MemDaxConn) Commit() sabi.Err {
+	conn.store.versions = conn.versions
+	conn.store.tables = conn.tables
+	logs = append(logs, "commit")
+	return sabi.Ok()
+}
+
+func (conn *MemDaxConn) Rollback() {
+	logs = append(logs, "rollback")
+}
+
+func (conn *MemDaxConn) Close() {
+}
+
+type MemDax struct {
+	sabi.Dax
+}
+
+func (dax MemDax) getConn() (*MemDaxConn, sabi.Err) {
+	return sabi.GetDaxConn[*MemDaxConn](dax, "mem")
+}
+
+func (dax MemDax) GetAppliedVersions() ([]int64, sabi.Err) {
+	conn, err := dax.getConn()
+	if !err.IsOk() {
+		return nil, err
+	}
+	a := make([]int64, 0, len(conn.versions))
+	for v := range conn.versions {
+		a = append(a, v)
+	}
+	sort.Slice(a, func(i, j int) bool { return a[i] < a[j] })
+	return a, sabi.Ok()
+}
+
+func (dax MemDax) AddAppliedVersion(version int64, name string) sabi.Err {
+	conn, err := dax.getConn()
+	if !err.IsOk() {
+		return err
+	}
+	conn.versions[version] = name
+	return sabi.Ok()
+}
+
+func (dax MemDax) RemoveAppliedVersion(version int64) sabi.Err {
+	conn, err := dax.getConn()
+	if !err.IsOk() {
+		return err
+	}
+	delete(conn.versions, version)
+	return sabi.Ok()
+}
+
+func (dax MemDax) CreateTable(name string) sabi.Err {
+	conn, err := dax.getConn()
+	if !err.IsOk() {
+		return err
+	}
+	logs = append(logs, "create:"+name)
+	conn.tables = append(conn.tables, name)
+	return sabi.Ok()
+}
+
+func (dax MemDax) DropTable(name string) sabi.Err {
+	conn, err := dax.getConn()
+	if !err.IsOk() {
+		return err
+	}
+	logs = append(logs, "drop:"+name)
+	for i, t := range conn.tables {
+		if t == name {
+			conn.tables = append(conn.tables[:i], conn.tables[i+1:]...)
+			break
+		}
+	}
+	return sabi.Ok()
+}
+
+func createTable(name string) func(dax MemDax) sabi.Err {
+	return func(dax MemDax) sabi.Err {
+		return dax.CreateTable(name)
+	}
+}
+
+func dropTable(name string) func(dax MemDax) sabi.Err {
+	return func(dax MemDax) sabi.Err {
+		return dax.DropTable(name)
+	}
+}
+
+func failToRun(dax MemDax) sabi.Err {
+	return sabi.ErrBy(FailToRun{})
+}
+
+func newMemProc() (sabi.Proc[MemDax], *memStore) {
+	ds := newMemDaxSrc()
+	base := sabi.NewDaxBase()
+	base.AddLocalDaxSrc("mem", ds)
+	return sabi.NewProc[MemDax](base, MemDax{Dax: base}), ds.store
+}
+
+func newMigrations() []Migration[MemDax] {
+	return []Migration[MemDax]{
+		{Version: 3, Name: "create orders",
+			Up: createTable("orders"), Down: dropTable("orders")},
+		{Version: 1, Name: "create users",
+			Up: createTable("users"), Down: dropTable("users")},
+		{Version: 2, Name: "create items",
+			Up: createTable("items"), Down: dropTable("items")},
+	}
+}
+
+func TestMigrator_Up(t *testing.T) {
+	logs = nil
+
+	proc, store := newMemProc()
+	m := NewMigrator(proc, newMigrations()...)
+
+	err := m.Up().Run()
+	assert.True(t, err.IsOk())
+
+	assert.Equal(t, store.tables, []string{"users", "items", "orders"})
+	assert.Equal(t, store.versions, map[int64]string{
+		1: "create users", 2: "create items", 3: "create orders",
+	})
+	assert.Equal(t, logs, []string{
+		"create:users", "commit",
+		"create:items", "commit",
+		"create:orders", "commit",
+	})
+
+	logs = nil
+
+	err = m.Up().Run()
+	assert.True(t, err.IsOk())
+	assert.Equal(t, logs, []string{"commit", "commit", "commit"})
+}
+
+func TestMigrator_Up_failed(t *testing.T) {
+	logs = nil
+
+	proc, store := newMemProc()
+	migs := newMigrations()
+	migs[2].Up = failToRun
+	m := NewMigrator(proc, migs...)
+
+	err := m.Up().Run()
+	switch err.Reason().(type) {
+	case FailToMigrate:
+		assert.Equal(t, err.Get("Version"), int64(2))
+		assert.Equal(t, err.Get("Name"), "create items")
+		assert.Equal(t, err.Get("Direction"), "up")
+		assert.Equal(t, err.Cause().(sabi.Err).ReasonName(), "FailToRun")
+	default:
+		assert.Fail(t, err.Error())
+	}
+
+	assert.Equal(t, store.tables, []string{"users"})
+	assert.Equal(t, store.versions, map[int64]string{1: "create users"})
+}
+
+func TestMigrator_UpTo(t *testing.T) {
+	logs = nil
+
+	proc, store := newMemProc()
+	m := NewMigrator(proc, newMigrations()...)
+
+	err := m.UpTo(2).Run()
+	assert.True(t, err.IsOk())
+	assert.Equal(t, store.tables, []string{"users", "items"})
+}
+
+func TestMigrator_Up_withSeq(t *testing.T) {
+	logs = nil
+
+	proc, store := newMemProc()
+	m := NewMigrator(proc, newMigrations()...)
+
+	err := sabi.RunSeq(
+		m.UpTo(1),
+		proc.Txn(createTable("other")),
+		m.Up(),
+	)
+	assert.True(t, err.IsOk())
+	assert.Equal(t, store.tables, []string{"users", "other", "items", "orders"})
+}
+
+func TestMigrator_Down(t *testing.T) {
+	logs = nil
+
+	proc, store := newMemProc()
+	m := NewMigrator(proc, newMigrations()...)
+
+	err := m.Up().Run()
+	assert.True(t, err.IsOk())
+
+	logs = nil
+
+	err = m.Down().Run()
+	assert.True(t, err.IsOk())
+	assert.Equal(t, store.tables, []string{"users", "items"})
+	assert.Equal(t, store.versions, map[int64]string{
+		1: "create users", 2: "create items",
+	})
+
+	err = m.Down().Run()
+	assert.True(t, err.IsOk())
+	err = m.Down().Run()
+	assert.True(t, err.IsOk())
+	assert.Equal(t, store.tables, []string{})
+	assert.Equal(t, store.versions, map[int64]string{})
+
+	err = m.Down().Run()
+	assert.True(t, err.IsOk())
+
+	assert.Equal(t, logs, []string{
+		"commit", "drop:orders", "commit",
+		"commit", "drop:items", "commit",
+		"commit", "drop:users", "commit",
+		"commit",
+	})
+}
+
+func TestMigrator_DownTo(t *testing.T) {
+	logs = nil
+
+	proc, store := newMemProc()
+	m := NewMigrator(proc, newMigrations()...)
+
+	err := m.Up().Run()
+	assert.True(t, err.IsOk())
+
+	err = m.DownTo(1).Run()
+	assert.True(t, err.IsOk())
+	assert.Equal(t, store.tables, []string{"users"})
+	assert.Equal(t, store.versions, map[int64]string{1: "create users"})
+
+	err = m.DownTo(0).Run()
+	assert.True(t, err.IsOk())
+	assert.Equal(t, store.tables, []string{})
+}
+
+func TestMigrator_Down_notReversible(t *testing.T) {
+	logs = nil
+
+	proc, store := newMemProc()
+	migs := newMigrations()
+	migs[0].Down = nil
+	m := NewMigrator(proc, migs...)
+
+	err := m.Up().Run()
+	assert.True(t, err.IsOk())
+
+	err = m.Down().Run()
+	switch err.Reason().(type) {
+	case FailToMigrate:
+		assert.Equal(t, err.Get("Version"), int64(3))
+		assert.Equal(t, err.Get("Direction"), "down")
+		cause := err.Cause().(sabi.Err)
+		switch cause.Reason().(type) {
+		case MigrationIsNotReversible:
+			assert.Equal(t, cause.Get("Name"), "create orders")
+		default:
+			assert.Fail(t, cause.Error())
+		}
+	default:
+		assert.Fail(t, err.Error())
+	}
+
+	assert.Equal(t, len(store.versions), 3)
+}
+
+func TestMigrator_Down_failed(t *testing.T) {
+	logs = nil
+
+	proc, store := newMemProc()
+	migs := newMigrations()
+	migs[2].Down = failToRun
+	m := NewMigrator(proc, migs...)
+
+	err := m.Up().Run()
+	assert.True(t, err.IsOk())
+
+	err = m.DownTo(0).Run()
+	switch err.Reason().(type) {
+	case FailToMigrate:
+		assert.Equal(t, err.Get("Version"), int64(2))
+		assert.Equal(t, err.Get("Direction"), "down")
+	default:
+		assert.Fail(t, err.Error())
+	}
+
+	assert.Equal(t, store.tables, []string{"users", "items"})
+}
+
+func TestMigrator_Status(t *testing.T) {
+	logs = nil
+
+	proc, _ := newMemProc()
+	m := NewMigrator(proc, newMigrations()...)
+
+	err := m.UpTo(2).Run()
+	assert.True(t, err.IsOk())
+
+	logs = nil
+
+	statuses, err := m.Status()
+	assert.True(t, err.IsOk())
+	assert.Equal(t, statuses, []MigrationStatus{
+		{Version: 1, Name: "create users", Applied: true},
+		{Version: 2, Name: "create items", Applied: true},
+		{Version: 3, Name: "create orders", Applied: false},
+	})
+	assert.Equal(t, logs, []string{"rollback"})
+}
+
+func TestMigrator_Status_migrationIsNotFound(t *testing.T) {
+	logs = nil
+
+	proc, store := newMemProc()
+	store.versions[5] = "unknown"
+	store.versions[4] = "unknown"
+	m := NewMigrator(proc, newMigrations()...)
+
+	_, err := m.Status()
+	switch err.Reason().(type) {
+	case MigrationIsNotFound:
+		assert.Equal(t, err.Get("Version"), int64(4))
+	default:
+		assert.Fail(t, err.Error())
+	}
+
+	err = m.Down().Run()
+	switch err.Reason().(type) {
+	case MigrationIsNotFound:
+		assert.Equal(t, err.Get("Version"), int64(5))
+	default:
+		assert.Fail(t, err.Error())
+	}
+}
+
+func TestNewMigrator_duplicatedMigrationVersion(t *testing.T) {
+	logs = nil
+
+	proc, store := newMemProc()
+	migs := append(newMigrations(), Migration[MemDax]{
+		Version: 2, Name: "dup", Up: createTable("dup"),
+	})
+	m := NewMigrator(proc, migs...)
+
+	for _, runner := range []sabi.Runner{m.Up(), m.UpTo(1), m.Down(), m.DownTo(0)} {
+		err := runner.Run()
+		switch err.Reason().(type) {
+		case DuplicatedMigrationVersion:
+			assert.Equal(t, err.Get("Version"), int64(2))
+		default:
+			assert.Fail(t, err.Error())
+		}
+	}
+
+	_, err := m.Status()
+	assert.Equal(t, err.ReasonName(), "DuplicatedMigrationVersion")
+
+	assert.Equal(t, len(store.tables), 0)
+}
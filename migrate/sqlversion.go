@@ -0,0 +1,107 @@
+// Copyright (C) 2023 Takayuki Sato. All Rights Reserved.
+// This program is free software under MIT License.
+// See the file LICENSE in this distribution for more details.
+
+package migrate
+
+import (
+	"github.com/sttk-go/sabi"
+	"github.com/sttk-go/sabi/sqldax"
+)
+
+// DefaultVersionTable is the default name of a version table used by
+// SqlVersionDax.
+const DefaultVersionTable = "sabi_migrations"
+
+// SqlVersionDax is a structure type which implements VersionDax with a table
+// in a database accessed through a sqldax.SqlDaxConn.
+// The table has columns: version and name, and is created by
+// #CreateVersionTable before a migration is applied if it does not exist.
+// Queries of this dax use "?" as placeholders, so a database which uses other
+// placeholders requires another implementation of VersionDax.
+type SqlVersionDax struct {
+	sqldax.SqlDax
+	connName string
+	table    string
+}
+
+// NewSqlVersionDax is a function which creates a new SqlVersionDax which uses
+// a SqlDaxConn registered with a specified name and the default version
+// table.
+func NewSqlVersionDax(dax sabi.Dax, connName string) SqlVersionDax {
+	return SqlVersionDax{
+		SqlDax:   sqldax.NewSqlDax(dax),
+		connName: connName,
+		table:    DefaultVersionTable,
+	}
+}
+
+// WithTable is a method which returns a copy of this SqlVersionDax which uses
+// a version table with a specified name.
+func (dax SqlVersionDax) WithTable(table string) SqlVersionDax {
+	dax.table = table
+	return dax
+}
+
+// CreateVersionTable is a method which creates the version table if it does
+// not exist.
+func (dax SqlVersionDax) CreateVersionTable() sabi.Err {
+	conn, err := dax.GetSqlDaxConn(dax.connName)
+	if !err.IsOk() {
+		return err
+	}
+
+	_, err = conn.Exec("CREATE TABLE IF NOT EXISTS " + dax.table +
+		" (version BIGINT NOT NULL PRIMARY KEY, name VARCHAR(255) NOT NULL)")
+	return err
+}
+
+// GetAppliedVersions is a method which returns versions of applied migrations
+// recorded in the version table in ascending order.
+// If a query selecting no row from the version table fails to be prepared or
+// executed, this method regards the table as not existing and returns no
+// version.
+func (dax SqlVersionDax) GetAppliedVersions() ([]int64, sabi.Err) {
+	conn, err := dax.GetSqlDaxConn(dax.connName)
+	if !err.IsOk() {
+		return nil, err
+	}
+
+	_, err = sqldax.QueryAll[int64](conn,
+		"SELECT version FROM "+dax.table+" WHERE 1 = 0")
+	switch err.Reason().(type) {
+	case sqldax.FailToPrepare, sqldax.FailToQuery:
+		return []int64{}, sabi.Ok()
+	}
+	if !err.IsOk() {
+		return nil, err
+	}
+
+	return sqldax.QueryAll[int64](conn,
+		"SELECT version FROM "+dax.table+" ORDER BY version")
+}
+
+// AddAppliedVersion is a method which records a version and a name of an
+// applied migration into the version table.
+func (dax SqlVersionDax) AddAppliedVersion(version int64, name string) sabi.Err {
+	conn, err := dax.GetSqlDaxConn(dax.connName)
+	if !err.IsOk() {
+		return err
+	}
+
+	_, err = conn.Exec(
+		"INSERT INTO "+dax.table+" (version, name) VALUES (?, ?)", version, name)
+	return err
+}
+
+// RemoveAppliedVersion is a method which removes a version of a reverted
+// migration from the version table.
+func (dax SqlVersionDax) RemoveAppliedVersion(version int64) sabi.Err {
+	conn, err := dax.GetSqlDaxConn(dax.connName)
+	if !err.IsOk() {
+		return err
+	}
+
+	_, err = conn.Exec("DELETE FROM "+dax.table+" WHERE version = ?", version)
+	return err
+}
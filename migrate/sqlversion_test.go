@@ -0,0 +1,279 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/sttk-go/sabi"
+	"github.com/sttk-go/sabi/sqldax"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// versionDriver is a fake database driver which supports only queries issued
+// by SqlVersionDax, and records executed queries into sqlLogs.
+type versionDriver struct{}
+
+var (
+	sqlMutex    sync.Mutex
+	sqlLogs     []string
+	sqlVersions map[int64]bool
+	sqlTables   map[string]bool
+)
+
+func init() {
+	sql.Register("sabi-migrate-fake", versionDriver{})
+}
+
+func clearSql() {
+	sqlMutex.Lock()
+	defer sqlMutex.Unlock()
+
+	sqlLogs = nil
+	sqlVersions = make(map[int64]bool)
+	sqlTables = make(map[string]bool)
+}
+
+func (d versionDriver) Open(name string) (driver.Conn, error) {
+	return &versionConn{}, nil
+}
+
+type versionConn struct {
+	staged map[int64]bool
+}
+
+func (c *versionConn) Prepare(query string) (driver.Stmt, error) {
+	return versionStmt{conn: c, query: query}, nil
+}
+
+func (c *versionConn) Close() error {
+	return nil
+}
+
+func (c *versionConn) Begin() (driver.Tx, error) {
+	sqlMutex.Lock()
+	defer sqlMutex.Unlock()
+
+	c.staged = make(map[int64]bool)
+	for k, v := range sqlVersions {
+		c.staged[k] = v
+	}
+	return versionTx{conn: c}, nil
+}
+
+func (c *versionConn) BeginTx(
+	ctx context.Context, opts driver.TxOptions,
+) (driver.Tx, error) {
+	return c.Begin()
+}
+
+type versionTx struct {
+	conn *versionConn
+}
+
+func (tx versionTx) Commit() error {
+	sqlMutex.Lock()
+	defer sqlMutex.Unlock()
+
+	sqlVersions = tx.conn.staged
+	sqlLogs = append(sqlLogs, "COMMIT")
+	return nil
+}
+
+func (tx versionTx) Rollback() error {
+	sqlMutex.Lock()
+	defer sqlMutex.Unlock()
+
+	sqlLogs = append(sqlLogs, "ROLLBACK")
+	return nil
+}
+
+type versionStmt struct {
+	conn  *versionConn
+	query string
+}
+
+func (s versionStmt) Close() error {
+	return nil
+}
+
+func (s versionStmt) NumInput() int {
+	return -1
+}
+
+func (s versionStmt) Exec(args []driver.Value) (driver.Result, error) {
+	sqlMutex.Lock()
+	defer sqlMutex.Unlock()
+
+	sqlLogs = append(sqlLogs, s.query)
+
+	switch {
+	case strings.HasPrefix(s.query, "CREATE TABLE IF NOT EXISTS"):
+		sqlTables[strings.Fields(s.query)[5]] = true
+	case strings.HasPrefix(s.query, "INSERT"):
+		s.conn.staged[args[0].(int64)] = true
+	case strings.HasPrefix(s.query, "DELETE"):
+		delete(s.conn.staged, args[0].(int64))
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (s versionStmt) Query(args []driver.Value) (driver.Rows, error) {
+	sqlMutex.Lock()
+	defer sqlMutex.Unlock()
+
+	sqlLogs = append(sqlLogs, s.query)
+
+	if !sqlTables[strings.Fields(s.query)[3]] {
+		return nil, errors.New("no such table")
+	}
+	if strings.HasSuffix(s.query, "WHERE 1 = 0") {
+		return &versionRows{}, nil
+	}
+
+	a := make([]int64, 0, len(s.conn.staged))
+	for v := range s.conn.staged {
+		a = append(a, v)
+	}
+	sort.Slice(a, func(i, j int) bool { return a[i] < a[j] })
+	return &versionRows{versions: a}, nil
+}
+
+type versionRows struct {
+	versions []int64
+	index    int
+}
+
+func (r *versionRows) Columns() []string {
+	return []string{"version"}
+}
+
+func (r *versionRows) Close() error {
+	return nil
+}
+
+func (r *versionRows) Next(dest []driver.Value) error {
+	if r.index >= len(r.versions) {
+		return io.EOF
+	}
+	dest[0] = r.versions[r.index]
+	r.index++
+	return nil
+}
+
+type SqlMigrationDax struct {
+	SqlVersionDax
+}
+
+func (dax SqlMigrationDax) CreateUsers() sabi.Err {
+	conn, err := dax.GetSqlDaxConn("sql")
+	if !err.IsOk() {
+		return err
+	}
+	_, err = conn.Exec("CREATE TABLE users (id BIGINT)")
+	return err
+}
+
+func (dax SqlMigrationDax) DropUsers() sabi.Err {
+	conn, err := dax.GetSqlDaxConn("sql")
+	if !err.IsOk() {
+		return err
+	}
+	_, err = conn.Exec("DROP TABLE users")
+	return err
+}
+
+func newSqlMigrator(table string) Migrator[SqlMigrationDax] {
+	db, _ := sql.Open("sabi-migrate-fake", "")
+	db.SetMaxOpenConns(1)
+
+	base := sabi.NewDaxBase()
+	base.AddLocalDaxSrc("sql", sqldax.NewSqlDaxSrc(db))
+
+	vdax := NewSqlVersionDax(base, "sql")
+	if len(table) > 0 {
+		vdax = vdax.WithTable(table)
+	}
+	proc := sabi.NewProc[SqlMigrationDax](base, SqlMigrationDax{vdax})
+
+	return NewMigrator(proc, Migration[SqlMigrationDax]{
+		Version: 1,
+		Name:    "create users",
+		Up:      func(dax SqlMigrationDax) sabi.Err { return dax.CreateUsers() },
+		Down:    func(dax SqlMigrationDax) sabi.Err { return dax.DropUsers() },
+	})
+}
+
+func TestSqlVersionDax_Up(t *testing.T) {
+	clearSql()
+	defer clearSql()
+
+	m := newSqlMigrator("")
+
+	err := m.Up().Run()
+	assert.True(t, err.IsOk())
+
+	assert.Equal(t, sqlLogs, []string{
+		"CREATE TABLE IF NOT EXISTS sabi_migrations (version BIGINT NOT NULL PRIMARY KEY, name VARCHAR(255) NOT NULL)",
+		"SELECT version FROM sabi_migrations WHERE 1 = 0",
+		"SELECT version FROM sabi_migrations ORDER BY version",
+		"CREATE TABLE users (id BIGINT)",
+		"INSERT INTO sabi_migrations (version, name) VALUES (?, ?)",
+		"COMMIT",
+	})
+	assert.Equal(t, sqlVersions, map[int64]bool{1: true})
+
+	statuses, err := m.Status()
+	assert.True(t, err.IsOk())
+	assert.Equal(t, statuses, []MigrationStatus{
+		{Version: 1, Name: "create users", Applied: true},
+	})
+}
+
+func TestSqlVersionDax_Down(t *testing.T) {
+	clearSql()
+	defer clearSql()
+
+	m := newSqlMigrator("versions")
+
+	err := m.Up().Run()
+	assert.True(t, err.IsOk())
+
+	sqlLogs = nil
+
+	err = m.Down().Run()
+	assert.True(t, err.IsOk())
+
+	assert.Equal(t, sqlLogs[len(sqlLogs)-5:], []string{
+		"SELECT version FROM versions WHERE 1 = 0",
+		"SELECT version FROM versions ORDER BY version",
+		"DROP TABLE users",
+		"DELETE FROM versions WHERE version = ?",
+		"COMMIT",
+	})
+	assert.Equal(t, sqlVersions, map[int64]bool{})
+}
+
+func TestSqlVersionDax_Status_noVersionTable(t *testing.T) {
+	clearSql()
+	defer clearSql()
+
+	m := newSqlMigrator("")
+
+	statuses, err := m.Status()
+	assert.True(t, err.IsOk())
+	assert.Equal(t, statuses, []MigrationStatus{
+		{Version: 1, Name: "create users", Applied: false},
+	})
+
+	assert.Equal(t, sqlLogs, []string{
+		"SELECT version FROM sabi_migrations WHERE 1 = 0",
+		"ROLLBACK",
+	})
+	assert.Equal(t, sqlTables, map[string]bool{})
+}
@@ -0,0 +1,272 @@
+// Copyright (C) 2023 Takayuki Sato. All Rights Reserved.
+// This program is free software under MIT License.
+// See the file LICENSE in this distribution for more details.
+
+// Package outbox provides a DaxSrc and a DaxConn of sabi which buffer
+// messages in a transaction and publish them only after the other DaxConn in
+// the transaction have committed.
+//
+// Messages are stored into a file-backed Spool when the transaction is
+// prepared, before any DaxConn in it commits, and are published on commit.
+// Messages which failed to be published, or were not published because the
+// process died, remain in the Spool and are published again by a Relay.
+// So messages are delivered at least once, and a Publisher or its consumers
+// should handle duplicated messages by their IDs.
+package outbox
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/sttk-go/sabi"
+	"sync"
+)
+
+type /* error reasons */ (
+	// FailToPublishMessage is an error reason which indicates that a Publisher
+	// failed to publish a message.
+	// The field ID and Topic are the ID and the topic of the message.
+	// The cause of an Err having this reason is an Err returned by the
+	// Publisher.
+	FailToPublishMessage struct {
+		ID    string
+		Topic string
+	}
+)
+
+// Message is a structure type which represents a message published through
+// an OutboxDaxConn.
+type Message struct {
+	ID      string
+	Topic   string
+	Payload []byte
+}
+
+// Publisher is an interface which publishes a message to a message broker or
+// other destinations.
+type Publisher interface {
+	Publish(msg Message) sabi.Err
+}
+
+// PublisherFunc is a function type which implements Publisher.
+type PublisherFunc func(msg Message) sabi.Err
+
+// Publish is a method which calls this function itself.
+func (fn PublisherFunc) Publish(msg Message) sabi.Err {
+	return fn(msg)
+}
+
+// OutboxDaxSrc is a structure type which implements sabi.DaxSrc and creates
+// OutboxDaxConn.
+// This also implements sabi.OrderedDaxSrc and its commit order is
+// sabi.AfterCommitOrder.
+type OutboxDaxSrc struct {
+	spool     *Spool
+	publisher Publisher
+}
+
+// NewOutboxDaxSrc is a function which creates a new OutboxDaxSrc which stores
+// messages into a specified Spool and publishes them with a specified
+// Publisher.
+func NewOutboxDaxSrc(spool *Spool, publisher Publisher) OutboxDaxSrc {
+	return OutboxDaxSrc{spool: spool, publisher: publisher}
+}
+
+// CreateDaxConn is a method which creates a new OutboxDaxConn.
+func (ds OutboxDaxSrc) CreateDaxConn() (sabi.DaxConn, sabi.Err) {
+	return &OutboxDaxConn{spool: ds.spool, publisher: ds.publisher}, sabi.Ok()
+}
+
+// CommitOrder is a method which returns sabi.AfterCommitOrder.
+func (ds OutboxDaxSrc) CommitOrder() int {
+	return sabi.AfterCommitOrder
+}
+
+// OutboxDaxConn is a structure type which implements sabi.DaxConn, and
+// buffers messages published in a transaction.
+// This implements sabi.PreparableDaxConn, and on #Prepare, buffered messages
+// are stored into a Spool, so that a failure to store them makes the
+// transaction fail before the other DaxConn commit.
+// On #Commit, the stored messages are published in order.
+// If a message failed to be published, an Err having the reason
+// FailToPublishMessage is created and notified to Err handlers, and the
+// message and the following messages are left in the Spool for a Relay.
+// Such failures do not make the transaction fail because the other DaxConn
+// have already committed.
+// This also implements sabi.ReadOnlyDaxConn.
+type OutboxDaxConn struct {
+	spool     *Spool
+	publisher Publisher
+	msgs      []Message
+	spooled   []Message
+	readOnly  bool
+	mutex     sync.Mutex
+}
+
+// Publish is a method which buffers a message to be published after commit.
+// If the ID of the message is empty, this method assigns a random ID.
+// In a read-only transaction, this method returns an Err having the reason
+// sabi.TxnIsReadOnly.
+func (conn *OutboxDaxConn) Publish(msg Message) sabi.Err {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	if conn.readOnly {
+		return sabi.ErrBy(sabi.TxnIsReadOnly{})
+	}
+
+	if len(msg.ID) == 0 {
+		msg.ID = newMessageID()
+	}
+	conn.msgs = append(conn.msgs, msg)
+	return sabi.Ok()
+}
+
+// Prepare is a method which stores buffered messages into a Spool.
+// The stored messages are not published by a Relay in this process until
+// this transaction is committed or rollbacked.
+// If it failed to store the messages, this method returns an Err having the
+// reason FailToSpoolMessage.
+func (conn *OutboxDaxConn) Prepare() sabi.Err {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	return conn.prepare()
+}
+
+func (conn *OutboxDaxConn) prepare() sabi.Err {
+	if len(conn.msgs) == 0 {
+		return sabi.Ok()
+	}
+
+	err := conn.spool.putPending(conn.msgs)
+	if !err.IsOk() {
+		return err
+	}
+
+	conn.spooled = append(conn.spooled, conn.msgs...)
+	conn.msgs = nil
+	return sabi.Ok()
+}
+
+// Commit is a method which publishes messages stored by #Prepare.
+// Messages buffered after #Prepare, or without #Prepare, are stored into the
+// Spool before they are published.
+func (conn *OutboxDaxConn) Commit() sabi.Err {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	err := conn.prepare()
+	if !err.IsOk() {
+		return err
+	}
+
+	if len(conn.spooled) == 0 {
+		return sabi.Ok()
+	}
+
+	msgs := conn.spooled
+	conn.spooled = nil
+
+	conn.spool.releasePending(msgs)
+
+	deliver(conn.spool, conn.publisher, msgs)
+	return sabi.Ok()
+}
+
+// Rollback is a method which discards buffered messages and removes messages
+// stored by #Prepare from the Spool.
+func (conn *OutboxDaxConn) Rollback() {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	conn.msgs = nil
+
+	if len(conn.spooled) > 0 {
+		conn.spool.discardPending(conn.spooled)
+		conn.spooled = nil
+	}
+}
+
+// Close is a method which discards buffered messages.
+func (conn *OutboxDaxConn) Close() {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	conn.msgs = nil
+}
+
+// SetReadOnly is a method which makes this OutboxDaxConn refuse publishing.
+func (conn *OutboxDaxConn) SetReadOnly() sabi.Err {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	conn.readOnly = true
+	return sabi.Ok()
+}
+
+// Relay is a structure type which implements sabi.Runner and publishes
+// messages left in a Spool.
+// A Relay is supposed to be run periodically or at the start of an
+// application to retry publishing messages which failed to be published on
+// commit.
+type Relay struct {
+	spool     *Spool
+	publisher Publisher
+}
+
+// NewRelay is a function which creates a new Relay which publishes messages
+// in a specified Spool with a specified Publisher.
+func NewRelay(spool *Spool, publisher Publisher) Relay {
+	return Relay{spool: spool, publisher: publisher}
+}
+
+// Run is a method which publishes messages in the Spool in order and removes
+// them from the Spool.
+// If a message failed to be published, this method stops and returns an Err
+// having the reason FailToPublishMessage, and the message and the following
+// messages are left in the Spool.
+func (relay Relay) Run() sabi.Err {
+	msgs, err := relay.spool.Messages()
+	if !err.IsOk() {
+		return err
+	}
+	return deliver(relay.spool, relay.publisher, msgs)
+}
+
+func deliver(spool *Spool, publisher Publisher, msgs []Message) sabi.Err {
+	for _, msg := range msgs {
+		err := publisher.Publish(msg)
+		if !err.IsOk() {
+			return sabi.ErrBy(FailToPublishMessage{ID: msg.ID, Topic: msg.Topic}, err)
+		}
+		err = spool.Remove(msg.ID)
+		if !err.IsOk() {
+			return err
+		}
+	}
+	return sabi.Ok()
+}
+
+func newMessageID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// OutboxDax is a structure type which is embedded in a dax structure
+// publishing messages, and provides a method to get an OutboxDaxConn.
+type OutboxDax struct {
+	sabi.Dax
+}
+
+// NewOutboxDax is a function which creates a new OutboxDax with a specified
+// Dax.
+func NewOutboxDax(dax sabi.Dax) OutboxDax {
+	return OutboxDax{Dax: dax}
+}
+
+// GetOutboxDaxConn is a method which gets an OutboxDaxConn by a specified
+// name.
+func (dax OutboxDax) GetOutboxDaxConn(name string) (*OutboxDaxConn, sabi.Err) {
+	return sabi.GetDaxConn[*OutboxDaxConn](dax.Dax, name)
+}
@@ -0,0 +1,306 @@
+package outbox
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/sttk-go/sabi"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+type (
+	FailToRun    struct{}
+	FailToCommit struct{}
+	BrokerIsDown struct{}
+)
+
+var (
+	logMutex sync.Mutex
+	logs     []string
+)
+
+func clearLogs() {
+	logMutex.Lock()
+	defer logMutex.Unlock()
+	logs = nil
+}
+
+func appendLog(s string) {
+	logMutex.Lock()
+	defer logMutex.Unlock()
+	logs = append(logs, s)
+}
+
+type RecDaxSrc struct {
+	failToCommit bool
+}
+
+func (ds RecDaxSrc) CreateDaxConn() (sabi.DaxConn, sabi.Err) {
+	return RecDaxConn{failToCommit: ds.failToCommit}, sabi.Ok()
+}
+
+type RecDaxConn struct {
+	failToCommit bool
+}
+
+func (conn RecDaxConn) Commit() sabi.Err {
+	if conn.failToCommit {
+		return sabi.ErrBy(FailToCommit{})
+	}
+	appendLog("rec:commit")
+	return sabi.Ok()
+}
+
+func (conn RecDaxConn) Rollback() {
+	appendLog("rec:rollback")
+}
+
+func (conn RecDaxConn) Close() {}
+
+type fakePublisher struct {
+	fails map[string]bool
+}
+
+func (p fakePublisher) Publish(msg Message) sabi.Err {
+	if p.fails[msg.ID] {
+		return sabi.ErrBy(BrokerIsDown{})
+	}
+	appendLog("publish:" + msg.ID + ":" + string(msg.Payload))
+	return sabi.Ok()
+}
+
+type EventDax struct {
+	OutboxDax
+}
+
+func (dax EventDax) Touch() sabi.Err {
+	_, err := dax.GetDaxConn("rec")
+	return err
+}
+
+func (dax EventDax) PublishEvent(id, payload string) sabi.Err {
+	conn, err := dax.GetOutboxDaxConn("outbox")
+	if !err.IsOk() {
+		return err
+	}
+	return conn.Publish(Message{ID: id, Topic: "events", Payload: []byte(payload)})
+}
+
+func newEventProc(
+	spool *Spool, pub Publisher, failToCommit bool,
+) sabi.Proc[EventDax] {
+	base := sabi.NewDaxBase()
+	base.AddLocalDaxSrc("rec", RecDaxSrc{failToCommit: failToCommit})
+	base.AddLocalDaxSrc("outbox", NewOutboxDaxSrc(spool, pub))
+	return sabi.NewProc[EventDax](base, EventDax{NewOutboxDax(base)})
+}
+
+func newSpool(t *testing.T) *Spool {
+	spool, err := OpenSpool(filepath.Join(t.TempDir(), "spool"))
+	assert.True(t, err.IsOk())
+	return spool
+}
+
+func TestOutboxDaxConn_publishAfterCommit(t *testing.T) {
+	clearLogs()
+	defer clearLogs()
+
+	spool := newSpool(t)
+	proc := newEventProc(spool, fakePublisher{}, false)
+
+	err := proc.RunTxn(func(dax EventDax) sabi.Err {
+		err := dax.PublishEvent("1", "a")
+		if !err.IsOk() {
+			return err
+		}
+		err = dax.Touch()
+		if !err.IsOk() {
+			return err
+		}
+		return dax.PublishEvent("2", "b")
+	})
+	assert.True(t, err.IsOk())
+	assert.Equal(t, logs, []string{"rec:commit", "publish:1:a", "publish:2:b"})
+
+	msgs, err := spool.Messages()
+	assert.True(t, err.IsOk())
+	assert.Equal(t, len(msgs), 0)
+}
+
+func TestOutboxDaxConn_rollback(t *testing.T) {
+	clearLogs()
+	defer clearLogs()
+
+	spool := newSpool(t)
+	proc := newEventProc(spool, fakePublisher{}, false)
+
+	err := proc.RunTxn(func(dax EventDax) sabi.Err {
+		err := dax.Touch()
+		if !err.IsOk() {
+			return err
+		}
+		err = dax.PublishEvent("1", "a")
+		if !err.IsOk() {
+			return err
+		}
+		return sabi.ErrBy(FailToRun{})
+	})
+	assert.Equal(t, err.ReasonName(), "FailToRun")
+	assert.Equal(t, logs, []string{"rec:rollback"})
+
+	msgs, err := spool.Messages()
+	assert.True(t, err.IsOk())
+	assert.Equal(t, len(msgs), 0)
+}
+
+func TestOutboxDaxConn_otherDaxConnFailedToCommit(t *testing.T) {
+	clearLogs()
+	defer clearLogs()
+
+	spool := newSpool(t)
+	proc := newEventProc(spool, fakePublisher{}, true)
+
+	err := proc.RunTxn(func(dax EventDax) sabi.Err {
+		err := dax.Touch()
+		if !err.IsOk() {
+			return err
+		}
+		return dax.PublishEvent("1", "a")
+	})
+	switch err.Reason().(type) {
+	case sabi.FailToCommitDaxConn:
+		errs := err.Get("Errors").(map[string]sabi.Err)
+		assert.Equal(t, errs["rec"].ReasonName(), "FailToCommit")
+	default:
+		assert.Fail(t, err.Error())
+	}
+	assert.Equal(t, logs, []string{"rec:rollback"})
+
+	msgs, err := spool.Messages()
+	assert.True(t, err.IsOk())
+	assert.Equal(t, len(msgs), 0)
+}
+
+func TestOutboxDaxConn_failToPublishAndRelay(t *testing.T) {
+	clearLogs()
+	defer clearLogs()
+
+	spool := newSpool(t)
+	pub := fakePublisher{fails: map[string]bool{"2": true}}
+	proc := newEventProc(spool, pub, false)
+
+	err := proc.RunTxn(func(dax EventDax) sabi.Err {
+		for _, id := range []string{"1", "2", "3"} {
+			err := dax.PublishEvent(id, id)
+			if !err.IsOk() {
+				return err
+			}
+		}
+		return sabi.Ok()
+	})
+	assert.True(t, err.IsOk())
+	assert.Equal(t, logs, []string{"publish:1:1"})
+
+	msgs, err := spool.Messages()
+	assert.True(t, err.IsOk())
+	assert.Equal(t, msgs, []Message{
+		{ID: "2", Topic: "events", Payload: []byte("2")},
+		{ID: "3", Topic: "events", Payload: []byte("3")},
+	})
+
+	err = NewRelay(spool, pub).Run()
+	switch err.Reason().(type) {
+	case FailToPublishMessage:
+		assert.Equal(t, err.Get("ID"), "2")
+		assert.Equal(t, err.Get("Topic"), "events")
+		assert.Equal(t, err.Cause().(sabi.Err).ReasonName(), "BrokerIsDown")
+	default:
+		assert.Fail(t, err.Error())
+	}
+	assert.Equal(t, logs, []string{"publish:1:1"})
+
+	err = NewRelay(spool, fakePublisher{}).Run()
+	assert.True(t, err.IsOk())
+	assert.Equal(t, logs, []string{"publish:1:1", "publish:2:2", "publish:3:3"})
+
+	msgs, err = spool.Messages()
+	assert.True(t, err.IsOk())
+	assert.Equal(t, len(msgs), 0)
+}
+
+func TestOutboxDaxConn_failToSpoolMessage(t *testing.T) {
+	clearLogs()
+	defer clearLogs()
+
+	spool := newSpool(t)
+	assert.Nil(t, os.Remove(spool.Dir()))
+
+	proc := newEventProc(spool, fakePublisher{}, false)
+
+	err := proc.RunTxn(func(dax EventDax) sabi.Err {
+		err := dax.Touch()
+		if !err.IsOk() {
+			return err
+		}
+		return dax.PublishEvent("1", "a")
+	})
+	switch err.Reason().(type) {
+	case sabi.FailToPrepareDaxConn:
+		errs := err.Get("Errors").(map[string]sabi.Err)
+		assert.Equal(t, errs["outbox"].ReasonName(), "FailToSpoolMessage")
+		assert.Equal(t, errs["outbox"].Get("ID"), "1")
+	default:
+		assert.Fail(t, err.Error())
+	}
+	assert.Equal(t, logs, []string{"rec:rollback"})
+}
+
+func TestOutboxDaxConn_notRelayedBeforeCommit(t *testing.T) {
+	clearLogs()
+	defer clearLogs()
+
+	spool := newSpool(t)
+	conn := &OutboxDaxConn{spool: spool, publisher: fakePublisher{}}
+
+	assert.True(t, conn.Publish(Message{ID: "1", Payload: []byte("a")}).IsOk())
+	assert.True(t, conn.Prepare().IsOk())
+
+	err := NewRelay(spool, fakePublisher{}).Run()
+	assert.True(t, err.IsOk())
+	assert.Equal(t, len(logs), 0)
+
+	conn.Rollback()
+
+	entries, e := os.ReadDir(spool.Dir())
+	assert.Nil(t, e)
+	assert.Equal(t, len(entries), 0)
+
+	assert.True(t, conn.Publish(Message{ID: "2", Payload: []byte("b")}).IsOk())
+	assert.True(t, conn.Prepare().IsOk())
+	assert.True(t, conn.Commit().IsOk())
+	assert.Equal(t, logs, []string{"publish:2:b"})
+}
+
+func TestOutboxDaxConn_readOnly(t *testing.T) {
+	spool := newSpool(t)
+	proc := newEventProc(spool, fakePublisher{}, false)
+
+	err := proc.ReadOnly().RunTxn(func(dax EventDax) sabi.Err {
+		return dax.PublishEvent("1", "a")
+	})
+	assert.Equal(t, err.ReasonName(), "TxnIsReadOnly")
+}
+
+func TestOutboxDaxConn_Publish_assignID(t *testing.T) {
+	conn := &OutboxDaxConn{}
+	assert.True(t, conn.Publish(Message{Topic: "t"}).IsOk())
+	assert.True(t, conn.Publish(Message{Topic: "t"}).IsOk())
+	assert.Equal(t, len(conn.msgs[0].ID), 32)
+	assert.NotEqual(t, conn.msgs[0].ID, conn.msgs[1].ID)
+}
+
+func TestOutboxDaxSrc_CommitOrder(t *testing.T) {
+	var ds sabi.OrderedDaxSrc = NewOutboxDaxSrc(nil, nil)
+	assert.Equal(t, ds.CommitOrder(), sabi.AfterCommitOrder)
+}
@@ -0,0 +1,235 @@
+// Copyright (C) 2023 Takayuki Sato. All Rights Reserved.
+// This program is free software under MIT License.
+// See the file LICENSE in this distribution for more details.
+
+package outbox
+
+import (
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"github.com/sttk-go/sabi"
+	"github.com/sttk-go/sabi/internal/gobfile"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+type /* error reasons */ (
+	// FailToSpoolMessage is an error reason which indicates that it failed to
+	// write a message to a Spool.
+	// The field ID is the ID of the message.
+	// The cause of an Err having this reason is an error returned by the os
+	// package or the encoding/gob package.
+	FailToSpoolMessage struct {
+		ID string
+	}
+
+	// FailToReadSpool is an error reason which indicates that it failed to
+	// read messages in a Spool.
+	// The field Dir is the directory of the Spool.
+	// The cause of an Err having this reason is an error returned by the os
+	// package or the encoding/gob package.
+	FailToReadSpool struct {
+		Dir string
+	}
+
+	// FailToRemoveMessage is an error reason which indicates that it failed to
+	// remove a delivered message from a Spool.
+	// The field ID is the ID of the message.
+	// The cause of an Err having this reason is an error returned by the os
+	// package.
+	FailToRemoveMessage struct {
+		ID string
+	}
+)
+
+const spoolFileExt = ".msg"
+
+// Spool is a structure type which stores messages as files in a directory
+// until they are delivered.
+// Each message is stored in its own file, and messages are listed in the
+// order in which they were put.
+type Spool struct {
+	dir     string
+	seq     int64
+	pending map[string]bool
+	mutex   sync.Mutex
+}
+
+// OpenSpool is a function which creates a new Spool which stores messages in
+// a specified directory.
+// If the directory does not exist, this function creates it.
+func OpenSpool(dir string) (*Spool, sabi.Err) {
+	e := os.MkdirAll(dir, 0755)
+	if e != nil {
+		return nil, sabi.ErrBy(FailToReadSpool{Dir: dir}, e)
+	}
+	return &Spool{dir: dir, pending: make(map[string]bool)}, sabi.Ok()
+}
+
+// Dir is a method which returns the directory of this Spool.
+func (spool *Spool) Dir() string {
+	return spool.dir
+}
+
+// Put is a method which stores specified messages into this Spool.
+// If it failed to store some of the messages, this method removes the
+// messages already stored in this call.
+func (spool *Spool) Put(msgs ...Message) sabi.Err {
+	spool.mutex.Lock()
+	defer spool.mutex.Unlock()
+
+	return spool.putAll(msgs)
+}
+
+// putPending stores specified messages into this Spool as pending ones,
+// which are not listed by #Messages until they are released.
+func (spool *Spool) putPending(msgs []Message) sabi.Err {
+	spool.mutex.Lock()
+	defer spool.mutex.Unlock()
+
+	err := spool.putAll(msgs)
+	if !err.IsOk() {
+		return err
+	}
+
+	for _, msg := range msgs {
+		spool.pending[msg.ID] = true
+	}
+	return sabi.Ok()
+}
+
+func (spool *Spool) releasePending(msgs []Message) {
+	spool.mutex.Lock()
+	defer spool.mutex.Unlock()
+
+	for _, msg := range msgs {
+		delete(spool.pending, msg.ID)
+	}
+}
+
+func (spool *Spool) discardPending(msgs []Message) {
+	spool.mutex.Lock()
+	defer spool.mutex.Unlock()
+
+	for _, msg := range msgs {
+		err := spool.Remove(msg.ID)
+		if err.IsOk() {
+			delete(spool.pending, msg.ID)
+		}
+	}
+}
+
+func (spool *Spool) putAll(msgs []Message) sabi.Err {
+	stored := make([]string, 0, len(msgs))
+
+	for _, msg := range msgs {
+		path, e := spool.put(msg)
+		if e != nil {
+			for _, p := range stored {
+				os.Remove(p)
+			}
+			return sabi.ErrBy(FailToSpoolMessage{ID: msg.ID}, e)
+		}
+		stored = append(stored, path)
+	}
+	return sabi.Ok()
+}
+
+func (spool *Spool) put(msg Message) (string, error) {
+	seq := time.Now().UnixNano()
+	if seq <= spool.seq {
+		seq = spool.seq + 1
+	}
+	spool.seq = seq
+
+	name := fmt.Sprintf("%020d-%s%s", seq, encodeID(msg.ID), spoolFileExt)
+	path := filepath.Join(spool.dir, name)
+
+	e := gobfile.Save(path, msg)
+	if e != nil {
+		return "", e
+	}
+	return path, nil
+}
+
+// Messages is a method which returns messages stored in this Spool in the
+// order in which they were put.
+// Messages stored by a transaction which is not finished yet are not
+// returned.
+func (spool *Spool) Messages() ([]Message, sabi.Err) {
+	entries, e := os.ReadDir(spool.dir)
+	if e != nil {
+		return nil, sabi.ErrBy(FailToReadSpool{Dir: spool.dir}, e)
+	}
+
+	msgs := make([]Message, 0, len(entries))
+
+	for _, ent := range entries {
+		if ent.IsDir() || !isSpoolFile(ent.Name()) {
+			continue
+		}
+
+		f, e := os.Open(filepath.Join(spool.dir, ent.Name()))
+		if os.IsNotExist(e) {
+			continue
+		}
+		if e != nil {
+			return nil, sabi.ErrBy(FailToReadSpool{Dir: spool.dir}, e)
+		}
+
+		var msg Message
+		e = gob.NewDecoder(f).Decode(&msg)
+		f.Close()
+		if e != nil {
+			return nil, sabi.ErrBy(FailToReadSpool{Dir: spool.dir}, e)
+		}
+
+		if spool.isPending(msg.ID) {
+			continue
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, sabi.Ok()
+}
+
+// Remove is a method which removes a message with a specified ID from this
+// Spool.
+// This method does nothing if the message is not found.
+func (spool *Spool) Remove(id string) sabi.Err {
+	suffix := "-" + encodeID(id) + spoolFileExt
+
+	entries, e := os.ReadDir(spool.dir)
+	if e != nil {
+		return sabi.ErrBy(FailToRemoveMessage{ID: id}, e)
+	}
+
+	for _, ent := range entries {
+		if !strings.HasSuffix(ent.Name(), suffix) || !isSpoolFile(ent.Name()) {
+			continue
+		}
+		e = os.Remove(filepath.Join(spool.dir, ent.Name()))
+		if e != nil && !os.IsNotExist(e) {
+			return sabi.ErrBy(FailToRemoveMessage{ID: id}, e)
+		}
+	}
+	return sabi.Ok()
+}
+
+func (spool *Spool) isPending(id string) bool {
+	spool.mutex.Lock()
+	defer spool.mutex.Unlock()
+
+	return spool.pending[id]
+}
+
+func encodeID(id string) string {
+	return hex.EncodeToString([]byte(id))
+}
+
+func isSpoolFile(name string) bool {
+	return !strings.HasPrefix(name, ".") && strings.HasSuffix(name, spoolFileExt)
+}
@@ -0,0 +1,54 @@
+package outbox
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSpool_PutAndRemove(t *testing.T) {
+	spool := newSpool(t)
+
+	err := spool.Put(
+		Message{ID: "b/1", Topic: "t", Payload: []byte("x")},
+		Message{ID: "a", Topic: "t", Payload: []byte("y")},
+	)
+	assert.True(t, err.IsOk())
+	err = spool.Put(Message{ID: "c", Topic: "u"})
+	assert.True(t, err.IsOk())
+
+	msgs, err := spool.Messages()
+	assert.True(t, err.IsOk())
+	assert.Equal(t, msgs, []Message{
+		{ID: "b/1", Topic: "t", Payload: []byte("x")},
+		{ID: "a", Topic: "t", Payload: []byte("y")},
+		{ID: "c", Topic: "u"},
+	})
+
+	assert.True(t, spool.Remove("a").IsOk())
+	assert.True(t, spool.Remove("x").IsOk())
+
+	reopened, err := OpenSpool(spool.Dir())
+	assert.True(t, err.IsOk())
+	msgs, err = reopened.Messages()
+	assert.True(t, err.IsOk())
+	assert.Equal(t, msgs, []Message{
+		{ID: "b/1", Topic: "t", Payload: []byte("x")},
+		{ID: "c", Topic: "u"},
+	})
+}
+
+func TestSpool_Messages_failToReadSpool(t *testing.T) {
+	spool := newSpool(t)
+	path := filepath.Join(spool.Dir(), "00000000000000000001-61.msg")
+	assert.Nil(t, os.WriteFile(path, []byte("broken"), 0644))
+
+	_, err := spool.Messages()
+	switch err.Reason().(type) {
+	case FailToReadSpool:
+		assert.Equal(t, err.Get("Dir"), spool.Dir())
+	default:
+		assert.Fail(t, err.Error())
+	}
+}
@@ -1,13 +1,145 @@
-// Copyright (C) 2022 Takayuki Sato. All Rights Reserved.
+// Copyright (C) 2022-2023 Takayuki Sato. All Rights Reserved.
 // This program is free software under MIT License.
 // See the file LICENSE in this distribution for more details.
 
 package sabi
 
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+type /* error reasons */ (
+	// TxnIsCanceled is an error reason which indicates that a transaction is
+	// stopped because its context.Context is canceled or its deadline is
+	// exceeded.
+	// The cause of an Err having this reason is the error of the context.
+	TxnIsCanceled struct{}
+
+	// TxnIsMarkedRollbackOnly is an error reason which indicates that a
+	// transaction cannot be committed because a joined inner transaction
+	// failed.
+	TxnIsMarkedRollbackOnly struct{}
+
+	// DaxBaseIsAlreadyInTxn is an error reason which indicates that a DaxBase
+	// cannot start a new transaction because it is already running another
+	// transaction, or it is still used by a logic or a commit of a previous
+	// transaction which was abandoned by a timeout.
+	DaxBaseIsAlreadyInTxn struct{}
+
+	// TxnIsReadOnly is an error reason which indicates that a write or a commit
+	// is requested in a read-only transaction.
+	// This reason is also supposed to be used by ReadOnlyDaxConn to refuse
+	// writes.
+	TxnIsReadOnly struct{}
+
+	// TxnRetryFailed is an error reason which indicates that a transaction
+	// failed even after it was retried.
+	// The field Attempts is the number of attempts, and the field Errors is a
+	// list of Err returned by every attempt.
+	// The cause of an Err having this reason is the Err of the last attempt.
+	TxnRetryFailed struct {
+		Attempts int
+		Errors   []Err
+	}
+
+	// TxnTimedOut is an error reason which indicates that a transaction did not
+	// finish within its timeout.
+	// The field Phase is the phase which was running when the timeout expired,
+	// and it is one of "logic", "commit", "rollback" and "close".
+	// The cause of an Err having this reason is the error of the context, or
+	// the Err which caused the rollback if Phase is "rollback".
+	// A rollback and a close are waited for within the same duration as the
+	// timeout even after the timeout of the transaction expired.
+	TxnTimedOut struct {
+		Phase string
+	}
+)
+
+// Propagation is a type which represents how a transaction of a Proc behaves
+// when it runs in another transaction.
+// An outer transaction is found from a context.Context passed to
+// Proc#RunTxnCtx or Proc#TxnCtx, so the context is required to be
+// DaxBase#Context of the outer transaction or a context derived from it.
+type Propagation int
+
+const (
+	// PropagationRequiresNew is a Propagation which indicates that a Proc runs
+	// in a new transaction independent of an outer transaction.
+	// This is the default propagation.
+	PropagationRequiresNew Propagation = iota
+
+	// PropagationRequired is a Propagation which indicates that a Proc joins an
+	// outer transaction if it exists, or runs in a new transaction otherwise.
+	// DaxConn in a joined Proc are obtained from and committed by the outer
+	// transaction, and if the joined Proc fails, the outer transaction is
+	// marked as rollback-only.
+	PropagationRequired
+
+	// PropagationNotSupported is a Propagation which indicates that a Proc
+	// runs without a transaction, which means DaxConn are only created and
+	// closed, and are neither committed nor rollbacked.
+	PropagationNotSupported
+)
+
+// RetryPolicy is a structure type which represents how to retry a
+// transaction which failed.
+// The field MaxAttempts is the maximum number of attempts including the first
+// one.
+// The field Backoff is a wait time before the first retry, which is doubled
+// for each subsequent retry up to the field MaxBackoff if it is positive.
+// The field Jitter is a ratio between 0 and 1 to shorten each wait time
+// randomly.
+// The field IsRetryable is a function which determines whether a transaction
+// is retried by an Err of the last attempt.
+type RetryPolicy struct {
+	MaxAttempts int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+	Jitter      float64
+	IsRetryable func(err Err) bool
+}
+
+func (policy RetryPolicy) wait(ctx context.Context, retry int) Err {
+	d := policy.Backoff
+	for i := 1; i < retry; i++ {
+		d *= 2
+		if policy.MaxBackoff > 0 && d >= policy.MaxBackoff {
+			d = policy.MaxBackoff
+			break
+		}
+	}
+	if policy.MaxBackoff > 0 && d > policy.MaxBackoff {
+		d = policy.MaxBackoff
+	}
+	if policy.Jitter > 0 {
+		d -= time.Duration(float64(d) * policy.Jitter * rand.Float64())
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return Ok()
+	case <-ctx.Done():
+		return ErrBy(TxnIsCanceled{}, ctx.Err())
+	}
+}
+
+type txnOpts struct {
+	propagation Propagation
+	readOnly    bool
+	retry       *RetryPolicy
+	timeout     time.Duration
+}
+
 // Proc is a structure type which represents a procedure.
 type Proc[D any] struct {
 	daxBase *DaxBase
 	dax     D
+	opts    txnOpts
 }
 
 // NewProc is a function which create a new Proc.
@@ -21,70 +153,357 @@ func (proc Proc[D]) AddLocalDaxSrc(name string, ds DaxSrc) {
 	proc.daxBase.AddLocalDaxSrc(name, ds)
 }
 
+// AddLocalDaxSrcWithOrder is a method which registers a procedure-local
+// DaxSrc with a specified name and an order of commit, rollback and close of
+// its DaxConn.
+func (proc Proc[D]) AddLocalDaxSrcWithOrder(name string, ds DaxSrc, order int) {
+	proc.daxBase.AddLocalDaxSrcWithOrder(name, ds, order)
+}
+
+// WithPropagation is a method which returns a copy of this Proc of which
+// transactions behave with a specified Propagation.
+func (proc Proc[D]) WithPropagation(propagation Propagation) Proc[D] {
+	proc.opts.propagation = propagation
+	return proc
+}
+
+// ReadOnly is a method which returns a copy of this Proc of which
+// transactions are read-only.
+// DaxConn which implement ReadOnlyDaxConn are notified that they are used in
+// a read-only transaction, and all DaxConn are rollbacked instead of being
+// committed at the end of a read-only transaction.
+// So writes through DaxConn which do not implement ReadOnlyDaxConn are not
+// refused but are always discarded, even if the logic functions succeed.
+// A read-only transaction which joins an outer transaction does not change
+// the outer one, so its writes are committed or rollbacked with the outer
+// transaction.
+func (proc Proc[D]) ReadOnly() Proc[D] {
+	proc.opts.readOnly = true
+	return proc
+}
+
+// WithRetry is a method which returns a copy of this Proc of which
+// transactions are retried with a specified RetryPolicy.
+// A failed transaction is rollbacked and its DaxConn are closed, and then all
+// logic functions are rerun from scratch.
+// A transaction which joins an outer transaction is not retried.
+func (proc Proc[D]) WithRetry(policy RetryPolicy) Proc[D] {
+	proc.opts.retry = &policy
+	return proc
+}
+
+// WithTimeout is a method which returns a copy of this Proc of which
+// transactions time out after a specified duration.
+// When a transaction times out, this Proc stops waiting for a running logic
+// function or commit, rollbacks and closes all DaxConn, and returns an Err
+// having the reason TxnTimedOut.
+// A logic function which is no longer waited for is expected to stop by
+// checking DaxBase#Context.
+func (proc Proc[D]) WithTimeout(timeout time.Duration) Proc[D] {
+	proc.opts.timeout = timeout
+	return proc
+}
+
 // RunTxn is a method which runs logic functions specified as arguments in a
 // transaction.
 func (proc Proc[D]) RunTxn(logics ...func(dax D) Err) Err {
-	proc.daxBase.begin()
+	return runTxn(context.Background(), proc.daxBase, proc.dax, logics, proc.opts)
+}
 
-	err := Ok()
+// RunTxnCtx is a method which runs logic functions specified as arguments in
+// a transaction with a context.Context.
+// If the context is canceled, this method stops running remaining logics and
+// rollbacks the transaction.
+func (proc Proc[D]) RunTxnCtx(ctx context.Context, logics ...func(dax D) Err) Err {
+	return runTxn(ctx, proc.daxBase, proc.dax, logics, proc.opts)
+}
 
-	for _, logic := range logics {
-		err = logic(proc.dax)
-		if !err.IsOk() {
-			break
-		}
-	}
+// Txn is a method which creates a transaction having specified logic
+// functions.
+func (proc Proc[D]) Txn(logics ...func(dax D) Err) Runner {
+	return proc.TxnCtx(context.Background(), logics...)
+}
 
-	if err.IsOk() {
-		err = proc.daxBase.commit()
+// TxnCtx is a method which creates a transaction having specified logic
+// functions and a context.Context.
+func (proc Proc[D]) TxnCtx(ctx context.Context, logics ...func(dax D) Err) Runner {
+	return txnRunner[D]{
+		ctx:     ctx,
+		logics:  logics,
+		daxBase: proc.daxBase,
+		dax:     proc.dax,
+		opts:    proc.opts,
 	}
+}
 
-	if !err.IsOk() {
-		proc.daxBase.rollback()
-	}
+// Run is a method which runs logic functions specified as arguments without
+// a transaction.
+// DaxConn used in the logic functions are only created and closed, and are
+// neither committed nor rollbacked.
+func (proc Proc[D]) Run(logics ...func(dax D) Err) Err {
+	return proc.RunCtx(context.Background(), logics...)
+}
 
-	proc.daxBase.close()
+// RunCtx is a method which runs logic functions specified as arguments
+// without a transaction with a context.Context.
+func (proc Proc[D]) RunCtx(ctx context.Context, logics ...func(dax D) Err) Err {
+	opts := proc.opts
+	opts.propagation = PropagationNotSupported
+	return runTxn(ctx, proc.daxBase, proc.dax, logics, opts)
+}
 
-	return err
+// NoTxn is a method which creates a runner which runs specified logic
+// functions without a transaction.
+func (proc Proc[D]) NoTxn(logics ...func(dax D) Err) Runner {
+	return proc.NoTxnCtx(context.Background(), logics...)
 }
 
-// Txn is a method which creates a transaction having specified logic
-// functions.
-func (proc Proc[D]) Txn(logics ...func(dax D) Err) Runner {
+// NoTxnCtx is a method which creates a runner which runs specified logic
+// functions without a transaction with a context.Context.
+func (proc Proc[D]) NoTxnCtx(ctx context.Context, logics ...func(dax D) Err) Runner {
+	opts := proc.opts
+	opts.propagation = PropagationNotSupported
 	return txnRunner[D]{
+		ctx:     ctx,
 		logics:  logics,
 		daxBase: proc.daxBase,
 		dax:     proc.dax,
+		opts:    opts,
 	}
 }
 
 type txnRunner[D any] struct {
+	ctx     context.Context
 	logics  []func(D) Err
 	daxBase *DaxBase
 	dax     D
+	opts    txnOpts
 }
 
 func (txn txnRunner[D]) Run() Err {
-	txn.daxBase.begin()
+	return runTxn(txn.ctx, txn.daxBase, txn.dax, txn.logics, txn.opts)
+}
 
-	err := Ok()
+func runTxn[D any](
+	ctx context.Context, base *DaxBase, dax D, logics []func(D) Err, opts txnOpts,
+) Err {
+	outer := getTxnDaxBase(ctx)
 
-	for _, logic := range txn.logics {
-		err = logic(txn.dax)
-		if !err.IsOk() {
+	switch opts.propagation {
+	case PropagationRequired:
+		if outer != nil {
+			return joinTxn(outer, base, dax, logics, opts)
+		}
+	case PropagationNotSupported:
+		if outer == base {
+			return ErrBy(DaxBaseIsAlreadyInTxn{})
+		}
+		return runWithRetry(ctx, opts, func() Err {
+			return runNoTxn(ctx, base, dax, logics, opts)
+		})
+	default:
+		if outer == base {
+			return ErrBy(DaxBaseIsAlreadyInTxn{})
+		}
+	}
+
+	return runWithRetry(ctx, opts, func() Err {
+		return runNewTxn(ctx, base, dax, logics, opts)
+	})
+}
+
+func runWithRetry(ctx context.Context, opts txnOpts, fn func() Err) Err {
+	err := fn()
+
+	policy := opts.retry
+	if policy == nil || err.IsOk() {
+		return err
+	}
+
+	errs := []Err{err}
+
+	for len(errs) < policy.MaxAttempts {
+		if policy.IsRetryable == nil || !policy.IsRetryable(err) {
 			break
 		}
+
+		e := policy.wait(ctx, len(errs))
+		if !e.IsOk() {
+			break
+		}
+
+		err = fn()
+		if err.IsOk() {
+			return err
+		}
+		errs = append(errs, err)
+	}
+
+	if len(errs) == 1 {
+		return err
+	}
+	return ErrBy(TxnRetryFailed{Attempts: len(errs), Errors: errs}, err)
+}
+
+func runNewTxn[D any](
+	ctx context.Context, base *DaxBase, dax D, logics []func(D) Err, opts txnOpts,
+) Err {
+	tctx, cancel := withTxnTimeout(ctx, opts.timeout)
+	defer cancel()
+
+	err := beginTxn(ctx, tctx, base, opts)
+	if !err.IsOk() {
+		return err
+	}
+
+	txnCtx := base.ctx
+	logicDone, err := runTxnPhase(ctx, tctx, opts, "logic", func() Err {
+		return runLogics(txnCtx, dax, logics)
+	})
+
+	if err.IsOk() && base.isMarkedRollbackOnly() {
+		err = ErrBy(TxnIsMarkedRollbackOnly{})
+	}
+
+	var commitDone <-chan struct{}
+	if err.IsOk() && !opts.readOnly {
+		commitDone, err = runTxnPhase(ctx, tctx, opts, "commit", base.commit)
+	}
+
+	_, isTimedOut := err.Reason().(TxnTimedOut)
+
+	rollback := !err.IsOk() || opts.readOnly
+	if !base.end(rollback, logicDone, commitDone, opts.timeout) && !isTimedOut {
+		if rollback {
+			err = ErrBy(TxnTimedOut{Phase: "rollback"}, err)
+		} else {
+			err = ErrBy(TxnTimedOut{Phase: "close"}, err)
+		}
+	}
+
+	return err
+}
+
+func runNoTxn[D any](
+	ctx context.Context, base *DaxBase, dax D, logics []func(D) Err, opts txnOpts,
+) Err {
+	tctx, cancel := withTxnTimeout(ctx, opts.timeout)
+	defer cancel()
+
+	err := beginTxn(ctx, tctx, base, opts)
+	if !err.IsOk() {
+		return err
+	}
+
+	txnCtx := base.ctx
+	logicDone, err := runTxnPhase(ctx, tctx, opts, "logic", func() Err {
+		return runLogics(txnCtx, dax, logics)
+	})
+
+	_, isTimedOut := err.Reason().(TxnTimedOut)
+
+	if !base.end(false, logicDone, nil, opts.timeout) && !isTimedOut {
+		err = ErrBy(TxnTimedOut{Phase: "close"}, err)
 	}
 
-	if err.IsOk() {
-		err = txn.daxBase.commit()
+	return err
+}
+
+func withTxnTimeout(
+	ctx context.Context, timeout time.Duration,
+) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return ctx, func() {}
 	}
+	return context.WithTimeout(ctx, timeout)
+}
 
+func beginTxn(
+	ctx, tctx context.Context, base *DaxBase, opts txnOpts,
+) Err {
+	err := base.waitForFence(tctx)
 	if !err.IsOk() {
-		txn.daxBase.rollback()
+		if ctx.Err() == nil {
+			return ErrBy(DaxBaseIsAlreadyInTxn{}, tctx.Err())
+		}
+		return err
+	}
+
+	base.begin(tctx, opts.readOnly)
+	return Ok()
+}
+
+// runTxnPhase runs a specified function within a timeout, and if the timeout
+// expires, returns a channel which is closed when the abandoned function
+// finishes.
+func runTxnPhase(
+	ctx, tctx context.Context, opts txnOpts, phase string, fn func() Err,
+) (<-chan struct{}, Err) {
+	if opts.timeout <= 0 {
+		return nil, fn()
+	}
+
+	ch := make(chan Err, 1)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ch <- fn()
+	}()
+
+	var err Err
+	var abandoned <-chan struct{}
+	select {
+	case err = <-ch:
+	case <-tctx.Done():
+		err = ErrBy(TxnIsCanceled{}, tctx.Err())
+		abandoned = done
 	}
 
-	txn.daxBase.close()
+	_, isCanceled := err.Reason().(TxnIsCanceled)
+	if isCanceled && ctx.Err() == nil {
+		return abandoned, ErrBy(TxnTimedOut{Phase: phase}, tctx.Err())
+	}
+	return abandoned, err
+}
+
+func joinTxn[D any](
+	outer, base *DaxBase, dax D, logics []func(D) Err, opts txnOpts,
+) Err {
+	if outer.isReadOnly && !opts.readOnly {
+		return ErrBy(TxnIsReadOnly{})
+	}
+
+	if outer != base {
+		base.join(outer)
+		defer base.leave()
+	}
+
+	err := runLogics(outer.ctx, dax, logics)
+
+	if !err.IsOk() {
+		outer.markRollbackOnly()
+	}
 
 	return err
 }
+
+func runLogics[D any](ctx context.Context, dax D, logics []func(D) Err) Err {
+	for _, logic := range logics {
+		e := ctx.Err()
+		if e != nil {
+			return ErrBy(TxnIsCanceled{}, e)
+		}
+
+		err := logic(dax)
+		if !err.IsOk() {
+			return err
+		}
+	}
+
+	e := ctx.Err()
+	if e != nil {
+		return ErrBy(TxnIsCanceled{}, e)
+	}
+
+	return Ok()
+}
@@ -1,10 +1,12 @@
 package sabi_test
 
 import (
+	"context"
 	"github.com/stretchr/testify/assert"
 	"github.com/sttk-go/sabi"
 	"strings"
 	"testing"
+	"time"
 )
 
 // ====== Logic part =======
@@ -230,3 +232,813 @@ func TestTxn_Run_failToCommitConn(t *testing.T) {
 
 	assert.Equal(t, store["result"], "GETDATA")
 }
+
+func TestProc_RunTxnCtx(t *testing.T) {
+	sabi.Clear()
+	defer sabi.Clear()
+
+	store := make(map[string]string)
+
+	proc := NewProc()
+	proc.AddLocalDaxSrc("foo", sabi.FooDaxSrc{})
+	proc.AddLocalDaxSrc("bar", &sabi.BarDaxSrc{Store: store})
+
+	err := proc.RunTxnCtx(context.Background(), GetAndSetDataLogic)
+	assert.True(t, err.IsOk())
+
+	assert.Equal(t, store["result"], "GETDATA")
+}
+
+func TestProc_RunTxnCtx_canceled(t *testing.T) {
+	sabi.Clear()
+	defer sabi.Clear()
+
+	store := make(map[string]string)
+
+	proc := NewProc()
+	proc.AddLocalDaxSrc("foo", sabi.FooDaxSrc{})
+	proc.AddLocalDaxSrc("bar", &sabi.BarDaxSrc{Store: store})
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	var called bool
+	err := proc.RunTxnCtx(ctx, func(dax MyDax) sabi.Err {
+		cancel()
+		return sabi.Ok()
+	}, func(dax MyDax) sabi.Err {
+		called = true
+		return sabi.Ok()
+	})
+	switch err.Reason().(type) {
+	case sabi.TxnIsCanceled:
+		assert.Equal(t, err.Cause(), context.Canceled)
+	default:
+		assert.Fail(t, err.Error())
+	}
+
+	assert.False(t, called)
+}
+
+func TestTxnCtx_Run(t *testing.T) {
+	sabi.Clear()
+	defer sabi.Clear()
+
+	store := make(map[string]string)
+
+	proc := NewProc()
+	proc.AddLocalDaxSrc("foo", sabi.FooDaxSrc{})
+	proc.AddLocalDaxSrc("bar", &sabi.BarDaxSrc{Store: store})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	txn := proc.TxnCtx(ctx, GetAndSetDataLogic)
+
+	err := txn.Run()
+	switch err.Reason().(type) {
+	case sabi.TxnIsCanceled:
+	default:
+		assert.Fail(t, err.Error())
+	}
+
+	assert.Equal(t, store["result"], "")
+}
+
+func TestProc_RunTxn_failToPrepareDaxConn(t *testing.T) {
+	sabi.Clear()
+	defer sabi.Clear()
+
+	store := make(map[string]string)
+
+	base := sabi.NewDaxBase()
+	dax := struct {
+		FooGetDataDax
+		BarSetDataDax
+	}{
+		FooGetDataDax: NewFooGetDataDax(base),
+		BarSetDataDax: NewBarSetDataDax(base),
+	}
+	proc := sabi.NewProc[MyDax](base, dax)
+	proc.AddLocalDaxSrc("foo", sabi.FooDaxSrc{})
+	proc.AddLocalDaxSrc("bar", &sabi.BarDaxSrc{Store: store})
+	proc.AddLocalDaxSrc("qux", sabi.QuxDaxSrc{})
+
+	sabi.WillFailToPrepareQuxDaxConn = true
+
+	err := proc.RunTxn(GetAndSetDataLogic, func(dax MyDax) sabi.Err {
+		_, err := base.GetDaxConn("qux")
+		return err
+	})
+	switch err.Reason().(type) {
+	case sabi.FailToPrepareDaxConn:
+		errs := err.Get("Errors").(map[string]sabi.Err)
+		assert.Equal(t, len(errs), 1)
+		switch errs["qux"].Reason().(type) {
+		case sabi.InvalidDaxConn:
+		default:
+			assert.Fail(t, err.Error())
+		}
+	default:
+		assert.Fail(t, err.Error())
+	}
+}
+
+func TestProc_WithPropagation_required(t *testing.T) {
+	sabi.Clear()
+	defer sabi.Clear()
+
+	store := make(map[string]string)
+
+	outerBase := sabi.NewDaxBase()
+	outerBase.AddLocalDaxSrc("foo", sabi.FooDaxSrc{Label: "outer"})
+	outerProc := sabi.NewProc[sabi.FooDax](outerBase, sabi.NewFooDax(outerBase))
+
+	innerProc := NewProc().WithPropagation(sabi.PropagationRequired)
+	innerProc.AddLocalDaxSrc("foo", sabi.FooDaxSrc{Label: "inner"})
+	innerProc.AddLocalDaxSrc("bar", &sabi.BarDaxSrc{Store: store})
+
+	err := outerProc.RunTxn(func(dax sabi.FooDax) sabi.Err {
+		conn, err := dax.GetFooDaxConn("foo")
+		assert.True(t, err.IsOk())
+		assert.Equal(t, conn.Label, "outer")
+
+		err = innerProc.RunTxnCtx(outerBase.Context(), GetAndSetDataLogic)
+		assert.True(t, err.IsOk())
+		assert.Equal(t, sabi.GetLogs(), []string{})
+		return err
+	})
+	assert.True(t, err.IsOk())
+
+	assert.Equal(t, store["result"], "GETDATA")
+	assert.Equal(t, len(sabi.GetLogs()), 4)
+}
+
+func TestProc_WithPropagation_requiredAndInnerFailed(t *testing.T) {
+	sabi.Clear()
+	defer sabi.Clear()
+
+	outerBase := sabi.NewDaxBase()
+	outerBase.AddLocalDaxSrc("foo", sabi.FooDaxSrc{})
+	outerProc := sabi.NewProc[sabi.FooDax](outerBase, sabi.NewFooDax(outerBase))
+
+	innerProc := NewProc().WithPropagation(sabi.PropagationRequired)
+
+	err := outerProc.RunTxn(func(dax sabi.FooDax) sabi.Err {
+		err := innerProc.RunTxnCtx(outerBase.Context(), GetAndSetDataLogic)
+		switch err.Reason().(type) {
+		case sabi.DaxSrcIsNotFound:
+			assert.Equal(t, err.Get("Name"), "bar")
+		default:
+			assert.Fail(t, err.Error())
+		}
+		return sabi.Ok()
+	})
+	switch err.Reason().(type) {
+	case sabi.TxnIsMarkedRollbackOnly:
+	default:
+		assert.Fail(t, err.Error())
+	}
+
+	assert.Equal(t, sabi.GetLogs(), []string{
+		"FooDaxConn#Rollback",
+		"FooDaxConn#Close",
+	})
+}
+
+func TestProc_WithPropagation_requiredWithoutOuterTxn(t *testing.T) {
+	sabi.Clear()
+	defer sabi.Clear()
+
+	store := make(map[string]string)
+
+	proc := NewProc().WithPropagation(sabi.PropagationRequired)
+	proc.AddLocalDaxSrc("foo", sabi.FooDaxSrc{})
+	proc.AddLocalDaxSrc("bar", &sabi.BarDaxSrc{Store: store})
+
+	err := proc.RunTxnCtx(context.Background(), GetAndSetDataLogic)
+	assert.True(t, err.IsOk())
+
+	assert.Equal(t, store["result"], "GETDATA")
+	assert.Equal(t, len(sabi.GetLogs()), 4)
+}
+
+func TestProc_WithPropagation_requiresNew(t *testing.T) {
+	sabi.Clear()
+	defer sabi.Clear()
+
+	store := make(map[string]string)
+
+	outerBase := sabi.NewDaxBase()
+	outerBase.AddLocalDaxSrc("foo", sabi.FooDaxSrc{})
+	outerProc := sabi.NewProc[sabi.FooDax](outerBase, sabi.NewFooDax(outerBase))
+
+	innerProc := NewProc().WithPropagation(sabi.PropagationRequiresNew)
+	innerProc.AddLocalDaxSrc("foo", sabi.FooDaxSrc{})
+	innerProc.AddLocalDaxSrc("bar", &sabi.BarDaxSrc{Store: store})
+
+	err := outerProc.RunTxn(func(dax sabi.FooDax) sabi.Err {
+		_, err := dax.GetFooDaxConn("foo")
+		assert.True(t, err.IsOk())
+
+		err = innerProc.RunTxnCtx(outerBase.Context(), GetAndSetDataLogic)
+		assert.True(t, err.IsOk())
+		assert.Equal(t, len(sabi.GetLogs()), 4)
+		return err
+	})
+	assert.True(t, err.IsOk())
+
+	assert.Equal(t, len(sabi.GetLogs()), 6)
+}
+
+func TestProc_WithPropagation_requiresNewOnSameDaxBase(t *testing.T) {
+	sabi.Clear()
+	defer sabi.Clear()
+
+	base := sabi.NewDaxBase()
+	proc := sabi.NewProc[sabi.FooDax](base, sabi.NewFooDax(base))
+
+	err := proc.RunTxn(func(dax sabi.FooDax) sabi.Err {
+		err := proc.RunTxnCtx(base.Context())
+		switch err.Reason().(type) {
+		case sabi.DaxBaseIsAlreadyInTxn:
+		default:
+			assert.Fail(t, err.Error())
+		}
+		return sabi.Ok()
+	})
+	assert.True(t, err.IsOk())
+}
+
+func TestProc_WithPropagation_notSupported(t *testing.T) {
+	sabi.Clear()
+	defer sabi.Clear()
+
+	store := make(map[string]string)
+
+	proc := NewProc().WithPropagation(sabi.PropagationNotSupported)
+	proc.AddLocalDaxSrc("foo", sabi.FooDaxSrc{})
+	proc.AddLocalDaxSrc("bar", &sabi.BarDaxSrc{Store: store})
+
+	err := proc.Txn(GetAndSetDataLogic).Run()
+	assert.True(t, err.IsOk())
+
+	assert.Equal(t, store["result"], "GETDATA")
+
+	logs := sabi.GetLogs()
+	assert.Equal(t, len(logs), 2)
+	assert.Contains(t, logs, "FooDaxConn#Close")
+	assert.Contains(t, logs, "BarDaxConn#Close")
+}
+
+func TestProc_Run(t *testing.T) {
+	sabi.Clear()
+	defer sabi.Clear()
+
+	store := make(map[string]string)
+
+	proc := NewProc()
+	proc.AddLocalDaxSrc("foo", sabi.FooDaxSrc{})
+	proc.AddLocalDaxSrc("bar", &sabi.BarDaxSrc{Store: store})
+
+	err := proc.Run(GetAndSetDataLogic)
+	assert.True(t, err.IsOk())
+
+	assert.Equal(t, store["result"], "GETDATA")
+
+	logs := sabi.GetLogs()
+	assert.Equal(t, len(logs), 2)
+	assert.Contains(t, logs, "FooDaxConn#Close")
+	assert.Contains(t, logs, "BarDaxConn#Close")
+}
+
+func TestProc_Run_failToGetDaxConn(t *testing.T) {
+	sabi.Clear()
+	defer sabi.Clear()
+
+	proc := NewProc()
+	proc.AddLocalDaxSrc("foo", sabi.FooDaxSrc{})
+
+	err := proc.Run(GetAndSetDataLogic)
+	switch err.Reason().(type) {
+	case sabi.DaxSrcIsNotFound:
+		assert.Equal(t, err.Get("Name"), "bar")
+	default:
+		assert.Fail(t, err.Error())
+	}
+
+	assert.Equal(t, sabi.GetLogs(), []string{"FooDaxConn#Close"})
+}
+
+func TestNoTxn_Run(t *testing.T) {
+	sabi.Clear()
+	defer sabi.Clear()
+
+	store := make(map[string]string)
+
+	proc := NewProc()
+	proc.AddLocalDaxSrc("foo", sabi.FooDaxSrc{})
+	proc.AddLocalDaxSrc("bar", &sabi.BarDaxSrc{Store: store})
+
+	err := sabi.RunSeq(proc.NoTxn(GetAndSetDataLogic))
+	assert.True(t, err.IsOk())
+
+	assert.Equal(t, store["result"], "GETDATA")
+	assert.Equal(t, len(sabi.GetLogs()), 2)
+}
+
+func TestProc_ReadOnly(t *testing.T) {
+	sabi.Clear()
+	defer sabi.Clear()
+
+	base := sabi.NewDaxBase()
+	proc := sabi.NewProc[sabi.Dax](base, base).ReadOnly()
+	proc.AddLocalDaxSrc("qux", sabi.QuxDaxSrc{})
+
+	err := proc.RunTxn(func(dax sabi.Dax) sabi.Err {
+		_, err := dax.GetDaxConn("qux")
+		return err
+	})
+	assert.True(t, err.IsOk())
+
+	assert.Equal(t, sabi.GetLogs(), []string{
+		"QuxDaxConn#SetReadOnly",
+		"QuxDaxConn#Rollback",
+		"QuxDaxConn#Close",
+	})
+}
+
+func TestProc_ReadOnly_notReadOnlyDaxConnIsRollbacked(t *testing.T) {
+	sabi.Clear()
+	defer sabi.Clear()
+
+	store := make(map[string]string)
+
+	base := sabi.NewDaxBase()
+	proc := sabi.NewProc[sabi.Dax](base, base).ReadOnly()
+	proc.AddLocalDaxSrc("bar", &sabi.BarDaxSrc{Store: store})
+
+	err := proc.RunTxn(func(dax sabi.Dax) sabi.Err {
+		_, err := dax.GetDaxConn("bar")
+		return err
+	})
+	assert.True(t, err.IsOk())
+
+	assert.Equal(t, sabi.GetLogs(), []string{
+		"BarDaxConn#Rollback",
+		"BarDaxConn#Close",
+	})
+}
+
+func TestProc_ReadOnly_joiningNotReadOnlyProc(t *testing.T) {
+	sabi.Clear()
+	defer sabi.Clear()
+
+	outerBase := sabi.NewDaxBase()
+	outerProc := sabi.NewProc[sabi.Dax](outerBase, outerBase)
+	outerProc.AddLocalDaxSrc("bar", &sabi.BarDaxSrc{})
+
+	innerBase := sabi.NewDaxBase()
+	innerProc := sabi.NewProc[sabi.Dax](innerBase, innerBase).
+		WithPropagation(sabi.PropagationRequired).ReadOnly()
+
+	err := outerProc.RunTxn(func(dax sabi.Dax) sabi.Err {
+		return innerProc.RunTxnCtx(outerBase.Context(), func(dax sabi.Dax) sabi.Err {
+			_, err := dax.GetDaxConn("bar")
+			return err
+		})
+	})
+	assert.True(t, err.IsOk())
+
+	assert.Equal(t, sabi.GetLogs(), []string{
+		"BarDaxConn#Commit",
+		"BarDaxConn#Close",
+	})
+}
+
+func TestProc_ReadOnly_joinedByNotReadOnlyProc(t *testing.T) {
+	sabi.Clear()
+	defer sabi.Clear()
+
+	outerBase := sabi.NewDaxBase()
+	outerProc := sabi.NewProc[sabi.Dax](outerBase, outerBase).ReadOnly()
+
+	innerBase := sabi.NewDaxBase()
+	innerProc := sabi.NewProc[sabi.Dax](innerBase, innerBase).
+		WithPropagation(sabi.PropagationRequired)
+
+	err := outerProc.RunTxn(func(dax sabi.Dax) sabi.Err {
+		return innerProc.RunTxnCtx(outerBase.Context())
+	})
+	switch err.Reason().(type) {
+	case sabi.TxnIsReadOnly:
+	default:
+		assert.Fail(t, err.Error())
+	}
+
+	err = outerProc.RunTxn(func(dax sabi.Dax) sabi.Err {
+		return innerProc.ReadOnly().RunTxnCtx(outerBase.Context())
+	})
+	assert.True(t, err.IsOk())
+}
+
+type Deadlock struct{}
+
+func isDeadlock(err sabi.Err) bool {
+	_, ok := err.Reason().(Deadlock)
+	return ok
+}
+
+func TestProc_WithRetry(t *testing.T) {
+	sabi.Clear()
+	defer sabi.Clear()
+
+	store := make(map[string]string)
+
+	proc := NewProc().WithRetry(sabi.RetryPolicy{
+		MaxAttempts: 3,
+		Backoff:     time.Millisecond,
+		Jitter:      0.5,
+		IsRetryable: isDeadlock,
+	})
+	proc.AddLocalDaxSrc("foo", sabi.FooDaxSrc{})
+	proc.AddLocalDaxSrc("bar", &sabi.BarDaxSrc{Store: store})
+
+	count := 0
+	err := proc.RunTxn(GetAndSetDataLogic, func(dax MyDax) sabi.Err {
+		count++
+		if count < 3 {
+			return sabi.ErrBy(Deadlock{})
+		}
+		return sabi.Ok()
+	})
+	assert.True(t, err.IsOk())
+	assert.Equal(t, count, 3)
+
+	assert.Equal(t, store["result"], "GETDATA")
+	assert.Equal(t, len(sabi.GetLogs()), 12)
+}
+
+func TestProc_WithRetry_exhausted(t *testing.T) {
+	sabi.Clear()
+	defer sabi.Clear()
+
+	proc := NewProc().WithRetry(sabi.RetryPolicy{
+		MaxAttempts: 3,
+		Backoff:     time.Millisecond,
+		MaxBackoff:  2 * time.Millisecond,
+		IsRetryable: isDeadlock,
+	})
+
+	count := 0
+	err := proc.Txn(func(dax MyDax) sabi.Err {
+		count++
+		return sabi.ErrBy(Deadlock{})
+	}).Run()
+	switch err.Reason().(type) {
+	case sabi.TxnRetryFailed:
+		assert.Equal(t, err.Get("Attempts"), 3)
+		errs := err.Get("Errors").([]sabi.Err)
+		assert.Equal(t, len(errs), 3)
+		for _, e := range errs {
+			assert.Equal(t, e.ReasonName(), "Deadlock")
+		}
+		assert.Equal(t, err.Cause().(sabi.Err).ReasonName(), "Deadlock")
+	default:
+		assert.Fail(t, err.Error())
+	}
+	assert.Equal(t, count, 3)
+}
+
+func TestProc_WithRetry_notRetryable(t *testing.T) {
+	sabi.Clear()
+	defer sabi.Clear()
+
+	proc := NewProc().WithRetry(sabi.RetryPolicy{
+		MaxAttempts: 3,
+		IsRetryable: isDeadlock,
+	})
+
+	count := 0
+	err := proc.RunTxn(func(dax MyDax) sabi.Err {
+		count++
+		if count == 1 {
+			return sabi.ErrBy(Deadlock{})
+		}
+		return sabi.ErrBy(FailToRun{})
+	})
+	switch err.Reason().(type) {
+	case sabi.TxnRetryFailed:
+		assert.Equal(t, err.Get("Attempts"), 2)
+		assert.Equal(t, err.Cause().(sabi.Err).ReasonName(), "FailToRun")
+	default:
+		assert.Fail(t, err.Error())
+	}
+	assert.Equal(t, count, 2)
+
+	count = 1
+	err = proc.RunTxn(func(dax MyDax) sabi.Err {
+		count++
+		return sabi.ErrBy(FailToRun{})
+	})
+	switch err.Reason().(type) {
+	case FailToRun:
+	default:
+		assert.Fail(t, err.Error())
+	}
+	assert.Equal(t, count, 2)
+}
+
+func TestProc_WithRetry_canceledWhileWaiting(t *testing.T) {
+	sabi.Clear()
+	defer sabi.Clear()
+
+	proc := NewProc().WithRetry(sabi.RetryPolicy{
+		MaxAttempts: 3,
+		Backoff:     time.Hour,
+		IsRetryable: isDeadlock,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+
+	count := 0
+	err := proc.RunTxnCtx(ctx, func(dax MyDax) sabi.Err {
+		count++
+		return sabi.ErrBy(Deadlock{})
+	})
+	switch err.Reason().(type) {
+	case Deadlock:
+	default:
+		assert.Fail(t, err.Error())
+	}
+	assert.Equal(t, count, 1)
+}
+
+func TestProc_WithTimeout(t *testing.T) {
+	sabi.Clear()
+	defer sabi.Clear()
+
+	store := make(map[string]string)
+
+	proc := NewProc().WithTimeout(time.Second)
+	proc.AddLocalDaxSrc("foo", sabi.FooDaxSrc{})
+	proc.AddLocalDaxSrc("bar", &sabi.BarDaxSrc{Store: store})
+
+	err := proc.RunTxn(GetAndSetDataLogic)
+	assert.True(t, err.IsOk())
+
+	assert.Equal(t, store["result"], "GETDATA")
+	assert.Equal(t, len(sabi.GetLogs()), 4)
+}
+
+func TestProc_WithTimeout_timedOutInLogic(t *testing.T) {
+	sabi.Clear()
+	defer sabi.Clear()
+
+	store := make(map[string]string)
+
+	proc := NewProc().WithTimeout(10 * time.Millisecond)
+	proc.AddLocalDaxSrc("foo", sabi.FooDaxSrc{})
+	proc.AddLocalDaxSrc("bar", &sabi.BarDaxSrc{Store: store})
+
+	hung := make(chan struct{})
+	defer close(hung)
+
+	err := proc.RunTxn(GetAndSetDataLogic, func(dax MyDax) sabi.Err {
+		<-hung
+		return sabi.Ok()
+	})
+	switch err.Reason().(type) {
+	case sabi.TxnTimedOut:
+		assert.Equal(t, err.Get("Phase"), "logic")
+		assert.Equal(t, err.Cause(), context.DeadlineExceeded)
+	default:
+		assert.Fail(t, err.Error())
+	}
+
+	logs := sabi.GetLogs()
+	assert.Equal(t, len(logs), 4)
+	assert.Contains(t, logs, "FooDaxConn#Rollback")
+	assert.Contains(t, logs, "BarDaxConn#Rollback")
+	assert.Contains(t, logs, "FooDaxConn#Close")
+	assert.Contains(t, logs, "BarDaxConn#Close")
+}
+
+func TestProc_WithTimeout_timedOutBetweenLogics(t *testing.T) {
+	sabi.Clear()
+	defer sabi.Clear()
+
+	proc := NewProc().WithTimeout(10 * time.Millisecond)
+
+	called := false
+	err := proc.RunTxn(func(dax MyDax) sabi.Err {
+		time.Sleep(20 * time.Millisecond)
+		return sabi.Ok()
+	}, func(dax MyDax) sabi.Err {
+		called = true
+		return sabi.Ok()
+	})
+	switch err.Reason().(type) {
+	case sabi.TxnTimedOut:
+		assert.Equal(t, err.Get("Phase"), "logic")
+	default:
+		assert.Fail(t, err.Error())
+	}
+	assert.False(t, called)
+}
+
+func TestProc_WithTimeout_canceled(t *testing.T) {
+	sabi.Clear()
+	defer sabi.Clear()
+
+	proc := NewProc().WithTimeout(time.Second)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	hung := make(chan struct{})
+	defer close(hung)
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+
+	err := proc.RunTxnCtx(ctx, func(dax MyDax) sabi.Err {
+		<-hung
+		return sabi.Ok()
+	})
+	switch err.Reason().(type) {
+	case sabi.TxnIsCanceled:
+		assert.Equal(t, err.Cause(), context.Canceled)
+	default:
+		assert.Fail(t, err.Error())
+	}
+}
+
+func TestProc_WithTimeout_timedOutInCommit(t *testing.T) {
+	sabi.Clear()
+	defer sabi.Clear()
+
+	hung := make(chan struct{})
+	defer close(hung)
+
+	base := sabi.NewDaxBase()
+	proc := sabi.NewProc[sabi.Dax](base, base).WithTimeout(10 * time.Millisecond)
+	proc.AddLocalDaxSrc("hung", HungDaxSrc{CommitHung: hung})
+
+	err := proc.RunTxn(func(dax sabi.Dax) sabi.Err {
+		_, err := dax.GetDaxConn("hung")
+		return err
+	})
+	switch err.Reason().(type) {
+	case sabi.TxnTimedOut:
+		assert.Equal(t, err.Get("Phase"), "commit")
+	default:
+		assert.Fail(t, err.Error())
+	}
+}
+
+func TestProc_WithTimeout_timedOutInRollback(t *testing.T) {
+	sabi.Clear()
+	defer sabi.Clear()
+
+	hung := make(chan struct{})
+	defer close(hung)
+
+	base := sabi.NewDaxBase()
+	proc := sabi.NewProc[sabi.Dax](base, base).WithTimeout(10 * time.Millisecond)
+	proc.AddLocalDaxSrc("hung", HungDaxSrc{RollbackHung: hung})
+
+	err := proc.RunTxn(func(dax sabi.Dax) sabi.Err {
+		_, err := dax.GetDaxConn("hung")
+		if !err.IsOk() {
+			return err
+		}
+		return sabi.ErrBy(FailToRun{})
+	})
+	switch err.Reason().(type) {
+	case sabi.TxnTimedOut:
+		assert.Equal(t, err.Get("Phase"), "rollback")
+		assert.Equal(t, err.Cause().(sabi.Err).ReasonName(), "FailToRun")
+	default:
+		assert.Fail(t, err.Error())
+	}
+}
+
+func TestProc_WithTimeout_abandonedLogicCannotGetDaxConn(t *testing.T) {
+	sabi.Clear()
+	defer sabi.Clear()
+
+	proc := NewProc().WithTimeout(10 * time.Millisecond)
+	proc.AddLocalDaxSrc("foo", sabi.FooDaxSrc{})
+
+	hung := make(chan struct{})
+	got := make(chan sabi.Err, 1)
+
+	err := proc.RunTxn(func(dax MyDax) sabi.Err {
+		<-hung
+		_, err := dax.GetData()
+		got <- err
+		return err
+	})
+	switch err.Reason().(type) {
+	case sabi.TxnTimedOut:
+		assert.Equal(t, err.Get("Phase"), "logic")
+	default:
+		assert.Fail(t, err.Error())
+	}
+
+	err = proc.RunTxn(func(dax MyDax) sabi.Err {
+		return sabi.Ok()
+	})
+	switch err.Reason().(type) {
+	case sabi.DaxBaseIsAlreadyInTxn:
+	default:
+		assert.Fail(t, err.Error())
+	}
+
+	close(hung)
+
+	err = <-got
+	switch err.Reason().(type) {
+	case sabi.TxnIsCanceled:
+	default:
+		assert.Fail(t, err.Error())
+	}
+
+	err = proc.RunTxn(func(dax MyDax) sabi.Err {
+		return sabi.Ok()
+	})
+	assert.True(t, err.IsOk())
+	assert.Equal(t, len(sabi.GetLogs()), 0)
+}
+
+func TestProc_WithTimeout_timedOutInCommitAndRollback(t *testing.T) {
+	sabi.Clear()
+	defer sabi.Clear()
+
+	hung := make(chan struct{})
+	defer close(hung)
+
+	base := sabi.NewDaxBase()
+	proc := sabi.NewProc[sabi.Dax](base, base).WithTimeout(10 * time.Millisecond)
+	proc.AddLocalDaxSrc("hung", HungDaxSrc{CommitHung: hung, RollbackHung: hung})
+
+	start := time.Now()
+	err := proc.RunTxn(func(dax sabi.Dax) sabi.Err {
+		_, err := dax.GetDaxConn("hung")
+		return err
+	})
+	switch err.Reason().(type) {
+	case sabi.TxnTimedOut:
+		assert.Equal(t, err.Get("Phase"), "commit")
+	default:
+		assert.Fail(t, err.Error())
+	}
+	assert.Less(t, time.Since(start), time.Second)
+
+	err = proc.RunTxn(func(dax sabi.Dax) sabi.Err {
+		return sabi.Ok()
+	})
+	switch err.Reason().(type) {
+	case sabi.DaxBaseIsAlreadyInTxn:
+	default:
+		assert.Fail(t, err.Error())
+	}
+}
+
+type HungDaxConn struct {
+	commitHung   chan struct{}
+	rollbackHung chan struct{}
+}
+
+func (conn HungDaxConn) Commit() sabi.Err {
+	if conn.commitHung != nil {
+		<-conn.commitHung
+	}
+	return sabi.Ok()
+}
+
+func (conn HungDaxConn) Rollback() {
+	if conn.rollbackHung != nil {
+		<-conn.rollbackHung
+	}
+}
+
+func (conn HungDaxConn) Close() {
+}
+
+type HungDaxSrc struct {
+	CommitHung   chan struct{}
+	RollbackHung chan struct{}
+}
+
+func (ds HungDaxSrc) CreateDaxConn() (sabi.DaxConn, sabi.Err) {
+	return HungDaxConn{
+		commitHung:   ds.CommitHung,
+		rollbackHung: ds.RollbackHung,
+	}, sabi.Ok()
+}
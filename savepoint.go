@@ -0,0 +1,184 @@
+// Copyright (C) 2023 Takayuki Sato. All Rights Reserved.
+// This program is free software under MIT License.
+// See the file LICENSE in this distribution for more details.
+
+package sabi
+
+import (
+	"strconv"
+)
+
+type /* error reasons */ (
+	// DaxBaseIsNotFound is an error reason which indicates that a specified Dax
+	// is neither a DaxBase nor a structure embedding a DaxBase.
+	DaxBaseIsNotFound struct{}
+
+	// DaxConnDoesNotSupportSavepoint is an error reason which indicates that a
+	// DaxConn used in a nested transaction does not implement SavepointDaxConn.
+	// The field Name is a registered name of the DaxConn.
+	DaxConnDoesNotSupportSavepoint struct {
+		Name string
+	}
+
+	// FailToSetSavepoint is an error reason which indicates that some
+	// connections failed to set a savepoint.
+	// The field Errors is a map of which keys are registered names of DaxConn
+	// which failed, and of which values are Err instances holding their error
+	// reasons.
+	FailToSetSavepoint struct {
+		Errors map[string]Err
+	}
+
+	// FailToRollbackToSavepoint is an error reason which indicates that some
+	// connections failed to rollback to a savepoint.
+	// The field Errors is a map of which keys are registered names of DaxConn
+	// which failed, and of which values are Err instances holding their error
+	// reasons.
+	// The cause of an Err having this reason is an Err returned by the nested
+	// transaction.
+	FailToRollbackToSavepoint struct {
+		Errors map[string]Err
+	}
+
+	// FailToReleaseSavepoint is an error reason which indicates that some
+	// connections failed to release a savepoint.
+	// The field Errors is a map of which keys are registered names of DaxConn
+	// which failed, and of which values are Err instances holding their error
+	// reasons.
+	FailToReleaseSavepoint struct {
+		Errors map[string]Err
+	}
+)
+
+// SavepointDaxConn is an interface which is optionally implemented by a
+// DaxConn to support nested transactions.
+// #Savepoint sets a savepoint with a specified name, #RollbackTo undoes
+// changes after the savepoint, and #Release discards the savepoint with
+// keeping the changes.
+type SavepointDaxConn interface {
+	DaxConn
+	Savepoint(name string) Err
+	RollbackTo(name string) Err
+	Release(name string) Err
+}
+
+type daxBaseHolder interface {
+	daxBase() *DaxBase
+}
+
+func (base *DaxBase) daxBase() *DaxBase {
+	return base
+}
+
+// Savepoint is a function which runs a specified function as a nested
+// transaction in a transaction running on a specified Dax.
+// If the function returns an Err which is not ok, only changes in the
+// function are undone and the Err is returned.
+// A specified Dax is required to be a DaxBase or a structure embedding a
+// DaxBase, and all DaxConn used in the function and before it are required to
+// implement SavepointDaxConn.
+// If the DaxBase joins a transaction of another DaxBase, the savepoint is set
+// on DaxConn of the joined transaction.
+func Savepoint(dax Dax, fn func() Err) Err {
+	h, ok := dax.(daxBaseHolder)
+	if !ok {
+		return ErrBy(DaxBaseIsNotFound{})
+	}
+	return h.daxBase().txnBase().runInSavepoint(fn)
+}
+
+func (base *DaxBase) txnBase() *DaxBase {
+	base.daxConnMutex.Lock()
+	defer base.daxConnMutex.Unlock()
+
+	if base.joinedBase != nil {
+		return base.joinedBase
+	}
+	return base
+}
+
+func (base *DaxBase) runInSavepoint(fn func() Err) Err {
+	base.daxConnMutex.Lock()
+
+	base.savepointSeq++
+	sp := "sabi_sp_" + strconv.Itoa(base.savepointSeq)
+
+	connMap := make(map[string]DaxConn)
+	for name, conn := range base.daxConnMap {
+		_, ok := conn.(SavepointDaxConn)
+		if !ok {
+			base.daxConnMutex.Unlock()
+			return ErrBy(DaxConnDoesNotSupportSavepoint{Name: name})
+		}
+		connMap[name] = conn
+	}
+
+	errs := runDaxConnsInParallel(connMap, func(conn DaxConn) Err {
+		return conn.(SavepointDaxConn).Savepoint(sp)
+	})
+	if len(errs) > 0 {
+		for name := range errs {
+			delete(connMap, name)
+		}
+		releaseSavepoint(connMap, sp)
+		base.daxConnMutex.Unlock()
+		return ErrBy(FailToSetSavepoint{Errors: errs})
+	}
+
+	base.savepoints = append(base.savepoints, sp)
+
+	base.daxConnMutex.Unlock()
+
+	err := fn()
+
+	base.daxConnMutex.Lock()
+	defer base.daxConnMutex.Unlock()
+
+	base.savepoints = base.savepoints[:len(base.savepoints)-1]
+
+	connMap = make(map[string]DaxConn)
+	for name, conn := range base.daxConnMap {
+		connMap[name] = conn
+	}
+
+	if !err.IsOk() {
+		errs = runDaxConnsInParallel(connMap, func(conn DaxConn) Err {
+			return conn.(SavepointDaxConn).RollbackTo(sp)
+		})
+		if len(errs) > 0 {
+			return ErrBy(FailToRollbackToSavepoint{Errors: errs}, err)
+		}
+		return err
+	}
+
+	errs = releaseSavepoint(connMap, sp)
+	if len(errs) > 0 {
+		return ErrBy(FailToReleaseSavepoint{Errors: errs})
+	}
+	return Ok()
+}
+
+func releaseSavepoint(connMap map[string]DaxConn, sp string) map[string]Err {
+	return runDaxConnsInParallel(connMap, func(conn DaxConn) Err {
+		return conn.(SavepointDaxConn).Release(sp)
+	})
+}
+
+func (base *DaxBase) setSavepoints(name string, conn DaxConn) Err {
+	if len(base.savepoints) == 0 {
+		return Ok()
+	}
+
+	spc, ok := conn.(SavepointDaxConn)
+	if !ok {
+		return ErrBy(DaxConnDoesNotSupportSavepoint{Name: name})
+	}
+
+	for _, sp := range base.savepoints {
+		err := spc.Savepoint(sp)
+		if !err.IsOk() {
+			return ErrBy(FailToSetSavepoint{Errors: map[string]Err{name: err}})
+		}
+	}
+	return Ok()
+}
@@ -0,0 +1,351 @@
+package sabi
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+var WillFailToRollbackToSpDaxConn bool = false
+
+type SpDaxConn struct {
+	Label string
+	Data  map[string]string
+	sps   map[string]map[string]string
+}
+
+func (conn *SpDaxConn) Commit() Err {
+	logs.PushBack("SpDaxConn#Commit:" + conn.Label)
+	return Ok()
+}
+
+func (conn *SpDaxConn) Rollback() {
+	logs.PushBack("SpDaxConn#Rollback:" + conn.Label)
+}
+
+func (conn *SpDaxConn) Close() {
+	logs.PushBack("SpDaxConn#Close:" + conn.Label)
+}
+
+func (conn *SpDaxConn) Savepoint(name string) Err {
+	logs.PushBack("SpDaxConn#Savepoint:" + conn.Label + ":" + name)
+	m := make(map[string]string)
+	for k, v := range conn.Data {
+		m[k] = v
+	}
+	conn.sps[name] = m
+	return Ok()
+}
+
+func (conn *SpDaxConn) RollbackTo(name string) Err {
+	if WillFailToRollbackToSpDaxConn {
+		return ErrBy(InvalidDaxConn{})
+	}
+	logs.PushBack("SpDaxConn#RollbackTo:" + conn.Label + ":" + name)
+	m := conn.sps[name]
+	delete(conn.sps, name)
+	for k := range conn.Data {
+		delete(conn.Data, k)
+	}
+	for k, v := range m {
+		conn.Data[k] = v
+	}
+	return Ok()
+}
+
+func (conn *SpDaxConn) Release(name string) Err {
+	logs.PushBack("SpDaxConn#Release:" + conn.Label + ":" + name)
+	delete(conn.sps, name)
+	return Ok()
+}
+
+type SpDaxSrc struct {
+	Label string
+	Data  map[string]string
+}
+
+func (ds SpDaxSrc) CreateDaxConn() (DaxConn, Err) {
+	sps := make(map[string]map[string]string)
+	return &SpDaxConn{Label: ds.Label, Data: ds.Data, sps: sps}, Ok()
+}
+
+type NotDaxBase struct{}
+
+func (dax NotDaxBase) GetDaxConn(name string) (DaxConn, Err) {
+	return nil, Ok()
+}
+
+func TestSavepoint(t *testing.T) {
+	Clear()
+	defer Clear()
+
+	data := make(map[string]string)
+
+	base := NewDaxBase()
+	base.AddLocalDaxSrc("sp", SpDaxSrc{Label: "a", Data: data})
+	base.begin(context.Background(), false)
+
+	conn, err := base.GetDaxConn("sp")
+	assert.True(t, err.IsOk())
+	conn.(*SpDaxConn).Data["k0"] = "v0"
+
+	err = Savepoint(base, func() Err {
+		conn.(*SpDaxConn).Data["k1"] = "v1"
+		return Ok()
+	})
+	assert.True(t, err.IsOk())
+
+	assert.Equal(t, data, map[string]string{"k0": "v0", "k1": "v1"})
+	assert.Equal(t, GetLogs(), []string{
+		"SpDaxConn#Savepoint:a:sabi_sp_1",
+		"SpDaxConn#Release:a:sabi_sp_1",
+	})
+}
+
+func TestSavepoint_rollbackTo(t *testing.T) {
+	Clear()
+	defer Clear()
+
+	data := make(map[string]string)
+
+	base := NewDaxBase()
+	base.AddLocalDaxSrc("sp", SpDaxSrc{Label: "a", Data: data})
+	base.begin(context.Background(), false)
+
+	conn, err := base.GetDaxConn("sp")
+	assert.True(t, err.IsOk())
+	conn.(*SpDaxConn).Data["k0"] = "v0"
+
+	err = Savepoint(base, func() Err {
+		conn.(*SpDaxConn).Data["k1"] = "v1"
+		return ErrBy(InvalidDaxConn{})
+	})
+	switch err.Reason().(type) {
+	case InvalidDaxConn:
+	default:
+		assert.Fail(t, err.Error())
+	}
+
+	assert.Equal(t, data, map[string]string{"k0": "v0"})
+	assert.Equal(t, GetLogs(), []string{
+		"SpDaxConn#Savepoint:a:sabi_sp_1",
+		"SpDaxConn#RollbackTo:a:sabi_sp_1",
+	})
+}
+
+func TestSavepoint_nested(t *testing.T) {
+	Clear()
+	defer Clear()
+
+	data := make(map[string]string)
+
+	base := NewDaxBase()
+	base.AddLocalDaxSrc("sp", SpDaxSrc{Label: "a", Data: data})
+	base.begin(context.Background(), false)
+
+	conn, err := base.GetDaxConn("sp")
+	assert.True(t, err.IsOk())
+
+	err = Savepoint(base, func() Err {
+		conn.(*SpDaxConn).Data["k1"] = "v1"
+		err := Savepoint(base, func() Err {
+			conn.(*SpDaxConn).Data["k2"] = "v2"
+			return ErrBy(InvalidDaxConn{})
+		})
+		assert.False(t, err.IsOk())
+		return Ok()
+	})
+	assert.True(t, err.IsOk())
+
+	assert.Equal(t, data, map[string]string{"k1": "v1"})
+	assert.Equal(t, GetLogs(), []string{
+		"SpDaxConn#Savepoint:a:sabi_sp_1",
+		"SpDaxConn#Savepoint:a:sabi_sp_2",
+		"SpDaxConn#RollbackTo:a:sabi_sp_2",
+		"SpDaxConn#Release:a:sabi_sp_1",
+	})
+}
+
+func TestSavepoint_daxConnCreatedInSavepoint(t *testing.T) {
+	Clear()
+	defer Clear()
+
+	data := make(map[string]string)
+
+	base := NewDaxBase()
+	base.AddLocalDaxSrc("sp", SpDaxSrc{Label: "a", Data: data})
+	base.begin(context.Background(), false)
+
+	err := Savepoint(base, func() Err {
+		conn, err := base.GetDaxConn("sp")
+		assert.True(t, err.IsOk())
+		conn.(*SpDaxConn).Data["k1"] = "v1"
+		return ErrBy(InvalidDaxConn{})
+	})
+	assert.False(t, err.IsOk())
+
+	assert.Equal(t, data, map[string]string{})
+	assert.Equal(t, GetLogs(), []string{
+		"SpDaxConn#Savepoint:a:sabi_sp_1",
+		"SpDaxConn#RollbackTo:a:sabi_sp_1",
+	})
+}
+
+func TestSavepoint_daxConnDoesNotSupportSavepoint(t *testing.T) {
+	Clear()
+	defer Clear()
+
+	base := NewDaxBase()
+	base.AddLocalDaxSrc("foo", FooDaxSrc{})
+	base.begin(context.Background(), false)
+
+	_, err := base.GetDaxConn("foo")
+	assert.True(t, err.IsOk())
+
+	called := false
+	err = Savepoint(base, func() Err {
+		called = true
+		return Ok()
+	})
+	switch err.Reason().(type) {
+	case DaxConnDoesNotSupportSavepoint:
+		assert.Equal(t, err.Get("Name"), "foo")
+	default:
+		assert.Fail(t, err.Error())
+	}
+	assert.False(t, called)
+}
+
+func TestSavepoint_daxConnCreatedInSavepointDoesNotSupportSavepoint(t *testing.T) {
+	Clear()
+	defer Clear()
+
+	base := NewDaxBase()
+	base.AddLocalDaxSrc("foo", FooDaxSrc{})
+	base.begin(context.Background(), false)
+
+	err := Savepoint(base, func() Err {
+		conn, err := base.GetDaxConn("foo")
+		assert.Nil(t, conn)
+		return err
+	})
+	switch err.Reason().(type) {
+	case DaxConnDoesNotSupportSavepoint:
+		assert.Equal(t, err.Get("Name"), "foo")
+	default:
+		assert.Fail(t, err.Error())
+	}
+
+	assert.Equal(t, len(base.daxConnMap), 0)
+	assert.Equal(t, GetLogs(), []string{
+		"FooDaxConn#Rollback",
+		"FooDaxConn#Close",
+	})
+}
+
+func TestSavepoint_failToRollbackTo(t *testing.T) {
+	Clear()
+	defer Clear()
+	defer func() { WillFailToRollbackToSpDaxConn = false }()
+
+	base := NewDaxBase()
+	base.AddLocalDaxSrc("sp", SpDaxSrc{Label: "a", Data: map[string]string{}})
+	base.begin(context.Background(), false)
+
+	_, err := base.GetDaxConn("sp")
+	assert.True(t, err.IsOk())
+
+	WillFailToRollbackToSpDaxConn = true
+
+	err = Savepoint(base, func() Err {
+		return ErrBy(DaxSrcIsNotFound{Name: "x"})
+	})
+	switch err.Reason().(type) {
+	case FailToRollbackToSavepoint:
+		errs := err.Get("Errors").(map[string]Err)
+		assert.Equal(t, errs["sp"].ReasonName(), "InvalidDaxConn")
+		assert.Equal(t, err.Cause().(Err).ReasonName(), "DaxSrcIsNotFound")
+	default:
+		assert.Fail(t, err.Error())
+	}
+}
+
+func TestSavepoint_daxIsNotDaxBase(t *testing.T) {
+	Clear()
+	defer Clear()
+
+	err := Savepoint(NotDaxBase{}, func() Err {
+		return Ok()
+	})
+	switch err.Reason().(type) {
+	case DaxBaseIsNotFound:
+	default:
+		assert.Fail(t, err.Error())
+	}
+}
+
+func TestSavepoint_structEmbeddingDaxBase(t *testing.T) {
+	Clear()
+	defer Clear()
+
+	base := NewDaxBase()
+	dax := struct {
+		*DaxBase
+		FooDax
+	}{
+		DaxBase: base,
+		FooDax:  NewFooDax(base),
+	}
+
+	err := Savepoint(dax, func() Err {
+		return Ok()
+	})
+	assert.True(t, err.IsOk())
+}
+
+func TestSavepoint_inJoinedTxn(t *testing.T) {
+	Clear()
+	defer Clear()
+
+	data := make(map[string]string)
+
+	outer := NewDaxBase()
+	outer.AddLocalDaxSrc("sp", SpDaxSrc{Label: "a", Data: data})
+	outer.begin(context.Background(), false)
+
+	conn, err := outer.GetDaxConn("sp")
+	assert.True(t, err.IsOk())
+	conn.(*SpDaxConn).Data["k0"] = "v0"
+
+	inner := NewDaxBase()
+	inner.AddLocalDaxSrc("sp2", SpDaxSrc{Label: "b", Data: make(map[string]string)})
+	inner.join(outer)
+	defer inner.leave()
+
+	err = Savepoint(inner, func() Err {
+		conn, err := inner.GetDaxConn("sp")
+		assert.True(t, err.IsOk())
+		conn.(*SpDaxConn).Data["k1"] = "v1"
+
+		_, err = inner.GetDaxConn("sp2")
+		assert.True(t, err.IsOk())
+		return ErrBy(InvalidDaxConn{})
+	})
+	switch err.Reason().(type) {
+	case InvalidDaxConn:
+	default:
+		assert.Fail(t, err.Error())
+	}
+
+	assert.Equal(t, data, map[string]string{"k0": "v0"})
+	assert.Equal(t, len(inner.daxConnMap), 0)
+	assert.Equal(t, len(outer.daxConnMap), 2)
+
+	logs := GetLogs()
+	assert.Equal(t, logs[0], "SpDaxConn#Savepoint:a:sabi_sp_1")
+	assert.Equal(t, logs[1], "SpDaxConn#Savepoint:b:sabi_sp_1")
+	assert.ElementsMatch(t, logs[2:], []string{
+		"SpDaxConn#RollbackTo:a:sabi_sp_1",
+		"SpDaxConn#RollbackTo:b:sabi_sp_1",
+	})
+}
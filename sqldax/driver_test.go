@@ -0,0 +1,224 @@
+package sqldax
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// fakeDriver is a database/sql/driver implementation for tests, which records
+// executed operations into logs and returns rows registered in results.
+type fakeDriver struct{}
+
+var (
+	fakeMutex   sync.Mutex
+	fakeLogs    []string
+	fakeResults map[string]fakeRows
+	fakeErrors  map[string]error
+)
+
+var errFake = errors.New("fake driver error")
+
+func init() {
+	sql.Register("sabi-fake", fakeDriver{})
+}
+
+func clearFake() {
+	fakeMutex.Lock()
+	defer fakeMutex.Unlock()
+
+	fakeLogs = nil
+	fakeResults = make(map[string]fakeRows)
+	fakeErrors = make(map[string]error)
+}
+
+func getFakeLogs() []string {
+	fakeMutex.Lock()
+	defer fakeMutex.Unlock()
+
+	a := make([]string, len(fakeLogs))
+	copy(a, fakeLogs)
+	return a
+}
+
+// setFakeResult registers rows which are returned for a query.
+func setFakeResult(query string, columns []string, values ...[]driver.Value) {
+	fakeMutex.Lock()
+	defer fakeMutex.Unlock()
+
+	fakeResults[query] = fakeRows{columns: columns, values: values}
+}
+
+// setFakeError makes an operation fail. An operation is "begin", "commit",
+// "rollback", "prepare:<query>", "exec:<query>" or "query:<query>".
+func setFakeError(op string) {
+	fakeMutex.Lock()
+	defer fakeMutex.Unlock()
+
+	fakeErrors[op] = errFake
+}
+
+// fakeOp records an operation into logs and returns an error registered with
+// the operation or a key of it without arguments.
+func fakeOp(op string, key string) error {
+	fakeMutex.Lock()
+	defer fakeMutex.Unlock()
+
+	fakeLogs = append(fakeLogs, op)
+	e := fakeErrors[op]
+	if e == nil {
+		e = fakeErrors[key]
+	}
+	return e
+}
+
+func openFakeDB() *sql.DB {
+	db, _ := sql.Open("sabi-fake", "")
+	return db
+}
+
+func (d fakeDriver) Open(name string) (driver.Conn, error) {
+	return &fakeConn{}, nil
+}
+
+type fakeConn struct{}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	e := fakeOp("prepare:"+query, "prepare:"+query)
+	if e != nil {
+		return nil, e
+	}
+	return &fakeStmt{query: query}, nil
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *fakeConn) BeginTx(
+	ctx context.Context, opts driver.TxOptions,
+) (driver.Tx, error) {
+	op := "begin"
+	if opts.ReadOnly {
+		op = "begin:read-only"
+	}
+	e := fakeOp(op, "begin")
+	if e != nil {
+		return nil, e
+	}
+	return fakeTx{}, nil
+}
+
+func (c *fakeConn) ExecContext(
+	ctx context.Context, query string, args []driver.NamedValue,
+) (driver.Result, error) {
+	e := fakeOp("exec:"+query+fakeArgs(args), "exec:"+query)
+	if e != nil {
+		return nil, e
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (c *fakeConn) QueryContext(
+	ctx context.Context, query string, args []driver.NamedValue,
+) (driver.Rows, error) {
+	e := fakeOp("query:"+query+fakeArgs(args), "query:"+query)
+	if e != nil {
+		return nil, e
+	}
+	return fakeQuery(query), nil
+}
+
+func fakeArgs(args []driver.NamedValue) string {
+	if len(args) == 0 {
+		return ""
+	}
+	a := make([]string, len(args))
+	for i, arg := range args {
+		a[i] = fmt.Sprint(arg.Value)
+	}
+	return ":" + strings.Join(a, ",")
+}
+
+func fakeQuery(query string) *fakeRows {
+	fakeMutex.Lock()
+	defer fakeMutex.Unlock()
+
+	r := fakeResults[query]
+	return &fakeRows{columns: r.columns, values: r.values}
+}
+
+type fakeTx struct{}
+
+func (tx fakeTx) Commit() error {
+	return fakeOp("commit", "commit")
+}
+
+func (tx fakeTx) Rollback() error {
+	return fakeOp("rollback", "rollback")
+}
+
+type fakeStmt struct {
+	query string
+}
+
+func (s *fakeStmt) Close() error {
+	fakeOp("close:"+s.query, "close:"+s.query)
+	return nil
+}
+
+func (s *fakeStmt) NumInput() int {
+	return -1
+}
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return nil, driver.ErrSkip
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return nil, driver.ErrSkip
+}
+
+func (s *fakeStmt) ExecContext(
+	ctx context.Context, args []driver.NamedValue,
+) (driver.Result, error) {
+	return (&fakeConn{}).ExecContext(ctx, s.query, args)
+}
+
+func (s *fakeStmt) QueryContext(
+	ctx context.Context, args []driver.NamedValue,
+) (driver.Rows, error) {
+	return (&fakeConn{}).QueryContext(ctx, s.query, args)
+}
+
+type fakeRows struct {
+	columns []string
+	values  [][]driver.Value
+	index   int
+}
+
+func (r *fakeRows) Columns() []string {
+	return r.columns
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.index >= len(r.values) {
+		return io.EOF
+	}
+	copy(dest, r.values[r.index])
+	r.index++
+	return nil
+}
@@ -0,0 +1,218 @@
+// Copyright (C) 2023 Takayuki Sato. All Rights Reserved.
+// This program is free software under MIT License.
+// See the file LICENSE in this distribution for more details.
+
+package sqldax
+
+import (
+	"database/sql"
+	"github.com/sttk-go/sabi"
+	"github.com/sttk-go/sabi/internal/rowmap"
+	"reflect"
+	"strings"
+	"time"
+)
+
+type /* error reasons */ (
+	// FailToPrepare is an error reason which indicates that it failed to
+	// prepare a statement.
+	// The field Query is the query of the statement.
+	// The cause of an Err having this reason is an error returned by the
+	// driver.
+	FailToPrepare struct {
+		Query string
+	}
+
+	// NoRowsFound is an error reason which indicates that a query which is
+	// expected to return a row returned no rows.
+	NoRowsFound struct{}
+
+	// FailToScanRow is an error reason which indicates that it failed to scan
+	// a column value of a row into a destination.
+	// The field Column is the name of the column.
+	// The cause of an Err having this reason is an error returned by
+	// *sql.Rows#Scan.
+	FailToScanRow struct {
+		Column string
+	}
+)
+
+// PrepareStmt is a method which creates a prepared statement for a specified
+// query in the database transaction of this SqlDaxConn.
+// A prepared statement is cached in this SqlDaxConn until it is closed, so
+// this method returns the same statement for the same query.
+func (conn *SqlDaxConn) PrepareStmt(query string) (*sql.Stmt, sabi.Err) {
+	tx, err := conn.Tx()
+	if !err.IsOk() {
+		return nil, err
+	}
+
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	stmt := conn.stmts[query]
+	if stmt != nil {
+		return stmt, sabi.Ok()
+	}
+
+	stmt, e := tx.PrepareContext(conn.ctx, query)
+	if e != nil {
+		return nil, sabi.ErrBy(FailToPrepare{Query: query}, e)
+	}
+
+	if conn.stmts == nil {
+		conn.stmts = make(map[string]*sql.Stmt)
+	}
+	conn.stmts[query] = stmt
+	return stmt, sabi.Ok()
+}
+
+// QueryOne is a function which executes a query with a cached prepared
+// statement of a specified SqlDaxConn, and maps the first row of the result
+// into a value of a type specified by the type parameter.
+// If the type is a structure type, each column is mapped into a field of
+// which tag `db` or name matches the column name, and columns matching no
+// field are ignored.
+// Otherwise, the result is required to have only one column.
+// If the query returns no rows, this function returns an Err having the
+// reason NoRowsFound.
+func QueryOne[T any](conn *SqlDaxConn, query string, args ...any) (T, sabi.Err) {
+	var t T
+
+	rows, err := queryPrepared(conn, query, args)
+	if !err.IsOk() {
+		return t, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		e := rows.Err()
+		if e != nil {
+			return t, sabi.ErrBy(FailToQuery{Query: query}, e)
+		}
+		return t, sabi.ErrBy(NoRowsFound{})
+	}
+
+	err = scanRow(rows, &t)
+	return t, err
+}
+
+// QueryAll is a function which executes a query with a cached prepared
+// statement of a specified SqlDaxConn, and maps all rows of the result into
+// values of a type specified by the type parameter.
+// Rows are mapped in the same way as QueryOne.
+// If the query returns no rows, this function returns an empty slice.
+func QueryAll[T any](conn *SqlDaxConn, query string, args ...any) ([]T, sabi.Err) {
+	rows, err := queryPrepared(conn, query, args)
+	if !err.IsOk() {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ts := make([]T, 0)
+	for rows.Next() {
+		var t T
+		err = scanRow(rows, &t)
+		if !err.IsOk() {
+			return nil, err
+		}
+		ts = append(ts, t)
+	}
+
+	e := rows.Err()
+	if e != nil {
+		return nil, sabi.ErrBy(FailToQuery{Query: query}, e)
+	}
+	return ts, sabi.Ok()
+}
+
+func queryPrepared(conn *SqlDaxConn, query string, args []any) (*sql.Rows, sabi.Err) {
+	stmt, err := conn.PrepareStmt(query)
+	if !err.IsOk() {
+		return nil, err
+	}
+	rows, e := stmt.QueryContext(conn.ctx, args...)
+	if e != nil {
+		return nil, sabi.ErrBy(FailToQuery{Query: query}, e)
+	}
+	return rows, sabi.Ok()
+}
+
+var (
+	scannerType = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+	timeType    = reflect.TypeOf(time.Time{})
+)
+
+func isScannedDirectly(t reflect.Type) bool {
+	return t.Kind() != reflect.Struct || t == timeType ||
+		reflect.PtrTo(t).Implements(scannerType)
+}
+
+func scanRow(rows *sql.Rows, ptr any) sabi.Err {
+	cols, e := rows.Columns()
+	if e != nil {
+		return sabi.ErrBy(FailToScanRow{}, e)
+	}
+
+	dests := make([]any, len(cols))
+
+	v := reflect.ValueOf(ptr).Elem()
+	if isScannedDirectly(v.Type()) {
+		if len(cols) != 1 {
+			return sabi.ErrBy(FailToScanRow{Column: strings.Join(cols, ",")})
+		}
+		dests[0] = ptr
+	} else {
+		fields := fieldIndexes(v.Type())
+		for i, col := range cols {
+			index, ok := fields[rowmap.NormalizeColumnName(col)]
+			if ok {
+				dests[i] = v.FieldByIndex(index).Addr().Interface()
+			} else {
+				dests[i] = new(any)
+			}
+		}
+	}
+
+	e = rows.Scan(dests...)
+	if e != nil {
+		col := findFailedColumn(rows, cols, dests)
+		return sabi.ErrBy(FailToScanRow{Column: col}, e)
+	}
+	return sabi.Ok()
+}
+
+// findFailedColumn scans a current row column by column to find a column
+// which failed to be scanned, because *sql.Rows#Scan does not return the
+// failed column in a structured form.
+func findFailedColumn(rows *sql.Rows, cols []string, dests []any) string {
+	for i := range dests {
+		a := make([]any, len(dests))
+		for j := range a {
+			a[j] = new(any)
+		}
+		a[i] = dests[i]
+		if rows.Scan(a...) != nil {
+			return cols[i]
+		}
+	}
+	return ""
+}
+
+func fieldIndexes(t reflect.Type) map[string][]int {
+	m := make(map[string][]int)
+	for _, f := range reflect.VisibleFields(t) {
+		if !f.IsExported() || f.Anonymous || rowmap.IsPromotedByPtr(t, f.Index) {
+			continue
+		}
+		name, ok := f.Tag.Lookup("db")
+		if name == "-" {
+			continue
+		}
+		if !ok || len(name) == 0 {
+			name = f.Name
+		}
+		m[rowmap.NormalizeColumnName(name)] = f.Index
+	}
+	return m
+}
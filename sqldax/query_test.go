@@ -0,0 +1,272 @@
+package sqldax
+
+import (
+	"database/sql/driver"
+	"github.com/stretchr/testify/assert"
+	"github.com/sttk-go/sabi"
+	"testing"
+	"time"
+)
+
+type User struct {
+	Id        int64
+	Name      string `db:"user_name"`
+	CreatedAt time.Time
+	Ignored   string `db:"-"`
+}
+
+func newFakeConn(t *testing.T) *SqlDaxConn {
+	conn, err := NewSqlDaxSrc(openFakeDB()).CreateDaxConn()
+	assert.True(t, err.IsOk())
+	return conn.(*SqlDaxConn)
+}
+
+func TestSqlDaxConn_PrepareStmt_cached(t *testing.T) {
+	clearFake()
+	defer clearFake()
+
+	conn := newFakeConn(t)
+
+	stmt1, err := conn.PrepareStmt("SELECT 1")
+	assert.True(t, err.IsOk())
+	stmt2, err := conn.PrepareStmt("SELECT 1")
+	assert.True(t, err.IsOk())
+	assert.Same(t, stmt1, stmt2)
+
+	stmt3, err := conn.PrepareStmt("SELECT 2")
+	assert.True(t, err.IsOk())
+	assert.NotSame(t, stmt1, stmt3)
+
+	conn.Close()
+
+	assert.Equal(t, getFakeLogs()[0:3], []string{
+		"begin",
+		"prepare:SELECT 1",
+		"prepare:SELECT 2",
+	})
+	assert.Nil(t, conn.stmts)
+}
+
+func TestSqlDaxConn_PrepareStmt_failToPrepare(t *testing.T) {
+	clearFake()
+	defer clearFake()
+
+	setFakeError("prepare:SELECT 1")
+
+	conn := newFakeConn(t)
+	defer conn.Close()
+
+	stmt, err := conn.PrepareStmt("SELECT 1")
+	assert.Nil(t, stmt)
+	switch err.Reason().(type) {
+	case FailToPrepare:
+		assert.Equal(t, err.Get("Query"), "SELECT 1")
+		assert.Equal(t, err.Cause(), errFake)
+	default:
+		assert.Fail(t, err.Error())
+	}
+}
+
+func TestQueryOne_struct(t *testing.T) {
+	clearFake()
+	defer clearFake()
+
+	tm := time.Date(2023, 4, 5, 6, 7, 8, 0, time.UTC)
+	setFakeResult("SELECT * FROM users WHERE id = ?",
+		[]string{"id", "user_name", "created_at", "ignored", "unknown"},
+		[]driver.Value{int64(1), "alice", tm, "x", "y"})
+
+	conn := newFakeConn(t)
+	defer conn.Close()
+
+	user, err := QueryOne[User](conn, "SELECT * FROM users WHERE id = ?", 1)
+	assert.True(t, err.IsOk())
+	assert.Equal(t, user, User{Id: 1, Name: "alice", CreatedAt: tm})
+}
+
+func TestQueryOne_scalar(t *testing.T) {
+	clearFake()
+	defer clearFake()
+
+	setFakeResult("SELECT name FROM users LIMIT 1", []string{"name"},
+		[]driver.Value{"alice"})
+
+	conn := newFakeConn(t)
+	defer conn.Close()
+
+	name, err := QueryOne[string](conn, "SELECT name FROM users LIMIT 1")
+	assert.True(t, err.IsOk())
+	assert.Equal(t, name, "alice")
+
+	name, err = QueryOne[string](conn, "SELECT name FROM users LIMIT 1")
+	assert.True(t, err.IsOk())
+	assert.Equal(t, name, "alice")
+
+	assert.Equal(t, getFakeLogs(), []string{
+		"begin",
+		"prepare:SELECT name FROM users LIMIT 1",
+		"query:SELECT name FROM users LIMIT 1",
+		"query:SELECT name FROM users LIMIT 1",
+	})
+}
+
+func TestQueryOne_noRowsFound(t *testing.T) {
+	clearFake()
+	defer clearFake()
+
+	setFakeResult("SELECT name FROM users", []string{"name"})
+
+	conn := newFakeConn(t)
+	defer conn.Close()
+
+	_, err := QueryOne[string](conn, "SELECT name FROM users")
+	switch err.Reason().(type) {
+	case NoRowsFound:
+	default:
+		assert.Fail(t, err.Error())
+	}
+}
+
+func TestQueryOne_failToScanRow(t *testing.T) {
+	clearFake()
+	defer clearFake()
+
+	setFakeResult("SELECT * FROM users",
+		[]string{"id", "user_name"},
+		[]driver.Value{"not-a-number", "alice"})
+
+	conn := newFakeConn(t)
+	defer conn.Close()
+
+	_, err := QueryOne[User](conn, "SELECT * FROM users")
+	switch err.Reason().(type) {
+	case FailToScanRow:
+		assert.Equal(t, err.Get("Column"), "id")
+		assert.NotNil(t, err.Cause())
+	default:
+		assert.Fail(t, err.Error())
+	}
+}
+
+func TestQueryOne_scalarWithMultipleColumns(t *testing.T) {
+	clearFake()
+	defer clearFake()
+
+	setFakeResult("SELECT * FROM users",
+		[]string{"id", "user_name"},
+		[]driver.Value{int64(1), "alice"})
+
+	conn := newFakeConn(t)
+	defer conn.Close()
+
+	_, err := QueryOne[string](conn, "SELECT * FROM users")
+	switch err.Reason().(type) {
+	case FailToScanRow:
+		assert.Equal(t, err.Get("Column"), "id,user_name")
+	default:
+		assert.Fail(t, err.Error())
+	}
+}
+
+func TestQueryOne_failToQuery(t *testing.T) {
+	clearFake()
+	defer clearFake()
+
+	setFakeError("query:SELECT name FROM users")
+
+	conn := newFakeConn(t)
+	defer conn.Close()
+
+	_, err := QueryOne[string](conn, "SELECT name FROM users")
+	switch err.Reason().(type) {
+	case FailToQuery:
+		assert.Equal(t, err.Get("Query"), "SELECT name FROM users")
+	default:
+		assert.Fail(t, err.Error())
+	}
+}
+
+func TestQueryAll(t *testing.T) {
+	clearFake()
+	defer clearFake()
+
+	setFakeResult("SELECT id, user_name FROM users",
+		[]string{"id", "user_name"},
+		[]driver.Value{int64(1), "alice"},
+		[]driver.Value{int64(2), "bob"})
+
+	conn := newFakeConn(t)
+	defer conn.Close()
+
+	users, err := QueryAll[User](conn, "SELECT id, user_name FROM users")
+	assert.True(t, err.IsOk())
+	assert.Equal(t, users, []User{
+		{Id: 1, Name: "alice"},
+		{Id: 2, Name: "bob"},
+	})
+}
+
+func TestQueryAll_noRows(t *testing.T) {
+	clearFake()
+	defer clearFake()
+
+	setFakeResult("SELECT id FROM users", []string{"id"})
+
+	conn := newFakeConn(t)
+	defer conn.Close()
+
+	ids, err := QueryAll[int64](conn, "SELECT id FROM users")
+	assert.True(t, err.IsOk())
+	assert.Equal(t, ids, []int64{})
+}
+
+func TestQueryAll_failToScanRow(t *testing.T) {
+	clearFake()
+	defer clearFake()
+
+	setFakeResult("SELECT id, user_name FROM users",
+		[]string{"id", "user_name"},
+		[]driver.Value{int64(1), "alice"},
+		[]driver.Value{int64(2), nil})
+
+	conn := newFakeConn(t)
+	defer conn.Close()
+
+	users, err := QueryAll[User](conn, "SELECT id, user_name FROM users")
+	assert.Nil(t, users)
+	switch err.Reason().(type) {
+	case FailToScanRow:
+		assert.Equal(t, err.Get("Column"), "user_name")
+	default:
+		assert.Fail(t, err.Error())
+	}
+}
+
+func TestQueryAll_inTxn(t *testing.T) {
+	clearFake()
+	defer clearFake()
+
+	setFakeResult("SELECT user_name FROM users", []string{"user_name"},
+		[]driver.Value{"alice"})
+
+	var names []string
+	proc, _ := newUserProc()
+	err := proc.RunTxn(func(dax UserDax) sabi.Err {
+		conn, err := dax.GetSqlDaxConn("sql")
+		if !err.IsOk() {
+			return err
+		}
+		names, err = QueryAll[string](conn, "SELECT user_name FROM users")
+		return err
+	})
+	assert.True(t, err.IsOk())
+	assert.Equal(t, names, []string{"alice"})
+
+	logs := getFakeLogs()
+	assert.Equal(t, logs[0:4], []string{
+		"begin",
+		"prepare:SELECT user_name FROM users",
+		"query:SELECT user_name FROM users",
+		"commit",
+	})
+}
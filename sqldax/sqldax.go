@@ -0,0 +1,265 @@
+// Copyright (C) 2023 Takayuki Sato. All Rights Reserved.
+// This program is free software under MIT License.
+// See the file LICENSE in this distribution for more details.
+
+// Package sqldax provides a DaxSrc and a DaxConn of sabi which access a
+// database through the standard package database/sql.
+package sqldax
+
+import (
+	"context"
+	"database/sql"
+	"github.com/sttk-go/sabi"
+	"sync"
+)
+
+type /* error reasons */ (
+	// FailToOpenDB is an error reason which indicates that it failed to open a
+	// database.
+	// The field Driver is the name of a database driver.
+	// The cause of an Err having this reason is an error returned by the
+	// driver.
+	FailToOpenDB struct {
+		Driver string
+	}
+
+	// FailToBeginTx is an error reason which indicates that it failed to begin
+	// a database transaction.
+	// The cause of an Err having this reason is an error returned by the
+	// driver.
+	FailToBeginTx struct{}
+
+	// FailToCommitTx is an error reason which indicates that it failed to
+	// commit a database transaction.
+	// The cause of an Err having this reason is an error returned by the
+	// driver.
+	FailToCommitTx struct{}
+
+	// FailToExec is an error reason which indicates that it failed to execute
+	// a query which does not return rows.
+	// The field Query is the executed query.
+	// The cause of an Err having this reason is an error returned by the
+	// driver.
+	FailToExec struct {
+		Query string
+	}
+
+	// FailToQuery is an error reason which indicates that it failed to execute
+	// a query which returns rows.
+	// The field Query is the executed query.
+	// The cause of an Err having this reason is an error returned by the
+	// driver.
+	FailToQuery struct {
+		Query string
+	}
+)
+
+// SqlDaxSrc is a structure type which implements sabi.DaxSrc and creates
+// SqlDaxConn to a database represented by a *sql.DB.
+type SqlDaxSrc struct {
+	db *sql.DB
+}
+
+// NewSqlDaxSrc is a function which creates a new SqlDaxSrc with a specified
+// *sql.DB.
+func NewSqlDaxSrc(db *sql.DB) SqlDaxSrc {
+	return SqlDaxSrc{db: db}
+}
+
+// OpenSqlDaxSrc is a function which opens a database with a specified driver
+// name and a data source name, and creates a new SqlDaxSrc with it.
+func OpenSqlDaxSrc(driver, dsn string) (SqlDaxSrc, sabi.Err) {
+	db, e := sql.Open(driver, dsn)
+	if e != nil {
+		return SqlDaxSrc{}, sabi.ErrBy(FailToOpenDB{Driver: driver}, e)
+	}
+	return SqlDaxSrc{db: db}, sabi.Ok()
+}
+
+// DB is a method which returns the *sql.DB of this SqlDaxSrc.
+func (ds SqlDaxSrc) DB() *sql.DB {
+	return ds.db
+}
+
+// CreateDaxConn is a method which creates a new SqlDaxConn.
+func (ds SqlDaxSrc) CreateDaxConn() (sabi.DaxConn, sabi.Err) {
+	return ds.CreateDaxConnCtx(context.Background())
+}
+
+// CreateDaxConnCtx is a method which creates a new SqlDaxConn which begins a
+// database transaction with a specified context.Context.
+// The database transaction is begun when it is used at first.
+func (ds SqlDaxSrc) CreateDaxConnCtx(ctx context.Context) (sabi.DaxConn, sabi.Err) {
+	return &SqlDaxConn{db: ds.db, ctx: ctx}, sabi.Ok()
+}
+
+// SqlDaxConn is a structure type which implements sabi.DaxConn and holds a
+// *sql.Tx which is committed or rollbacked in a transaction process of sabi.
+// This also implements sabi.ReadOnlyDaxConn and sabi.SavepointDaxConn.
+type SqlDaxConn struct {
+	db       *sql.DB
+	ctx      context.Context
+	readOnly bool
+	tx       *sql.Tx
+	stmts    map[string]*sql.Stmt
+	mutex    sync.Mutex
+}
+
+// Tx is a method which returns a *sql.Tx of this SqlDaxConn.
+// If a database transaction is not begun yet, this method begins it.
+func (conn *SqlDaxConn) Tx() (*sql.Tx, sabi.Err) {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	if conn.tx != nil {
+		return conn.tx, sabi.Ok()
+	}
+
+	opts := &sql.TxOptions{ReadOnly: conn.readOnly}
+	tx, e := conn.db.BeginTx(conn.ctx, opts)
+	if e != nil {
+		return nil, sabi.ErrBy(FailToBeginTx{}, e)
+	}
+	conn.tx = tx
+	return tx, sabi.Ok()
+}
+
+// Exec is a method which executes a query which does not return rows in the
+// database transaction of this SqlDaxConn.
+func (conn *SqlDaxConn) Exec(query string, args ...any) (sql.Result, sabi.Err) {
+	tx, err := conn.Tx()
+	if !err.IsOk() {
+		return nil, err
+	}
+	r, e := tx.ExecContext(conn.ctx, query, args...)
+	if e != nil {
+		return nil, sabi.ErrBy(FailToExec{Query: query}, e)
+	}
+	return r, sabi.Ok()
+}
+
+// Query is a method which executes a query which returns rows in the database
+// transaction of this SqlDaxConn.
+func (conn *SqlDaxConn) Query(query string, args ...any) (*sql.Rows, sabi.Err) {
+	tx, err := conn.Tx()
+	if !err.IsOk() {
+		return nil, err
+	}
+	rows, e := tx.QueryContext(conn.ctx, query, args...)
+	if e != nil {
+		return nil, sabi.ErrBy(FailToQuery{Query: query}, e)
+	}
+	return rows, sabi.Ok()
+}
+
+// QueryRow is a method which executes a query which returns at most one row
+// in the database transaction of this SqlDaxConn.
+// Errors in executing the query are deferred until *sql.Row#Scan is called.
+func (conn *SqlDaxConn) QueryRow(query string, args ...any) (*sql.Row, sabi.Err) {
+	tx, err := conn.Tx()
+	if !err.IsOk() {
+		return nil, err
+	}
+	return tx.QueryRowContext(conn.ctx, query, args...), sabi.Ok()
+}
+
+// Commit is a method which commits the database transaction of this
+// SqlDaxConn if it is begun.
+func (conn *SqlDaxConn) Commit() sabi.Err {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	if conn.tx == nil {
+		return sabi.Ok()
+	}
+	e := conn.tx.Commit()
+	if e != nil {
+		return sabi.ErrBy(FailToCommitTx{}, e)
+	}
+	return sabi.Ok()
+}
+
+// Rollback is a method which rollbacks the database transaction of this
+// SqlDaxConn if it is begun.
+func (conn *SqlDaxConn) Rollback() {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	if conn.tx == nil {
+		return
+	}
+	conn.tx.Rollback()
+}
+
+// Close is a method which releases the database transaction and the cached
+// prepared statements of this SqlDaxConn.
+// If the transaction is neither committed nor rollbacked, this method
+// rollbacks it.
+func (conn *SqlDaxConn) Close() {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	for _, stmt := range conn.stmts {
+		stmt.Close()
+	}
+	conn.stmts = nil
+
+	if conn.tx == nil {
+		return
+	}
+	conn.tx.Rollback()
+	conn.tx = nil
+}
+
+// SetReadOnly is a method which makes the database transaction of this
+// SqlDaxConn read-only.
+// This method is called by sabi.DaxBase in a read-only transaction.
+func (conn *SqlDaxConn) SetReadOnly() sabi.Err {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	if conn.tx != nil {
+		return sabi.ErrBy(sabi.TxnIsReadOnly{})
+	}
+	conn.readOnly = true
+	return sabi.Ok()
+}
+
+// Savepoint is a method which sets a savepoint with a specified name in the
+// database transaction of this SqlDaxConn.
+func (conn *SqlDaxConn) Savepoint(name string) sabi.Err {
+	_, err := conn.Exec("SAVEPOINT " + name)
+	return err
+}
+
+// RollbackTo is a method which rollbacks the database transaction of this
+// SqlDaxConn to a savepoint with a specified name.
+func (conn *SqlDaxConn) RollbackTo(name string) sabi.Err {
+	_, err := conn.Exec("ROLLBACK TO SAVEPOINT " + name)
+	return err
+}
+
+// Release is a method which releases a savepoint with a specified name in the
+// database transaction of this SqlDaxConn.
+func (conn *SqlDaxConn) Release(name string) sabi.Err {
+	_, err := conn.Exec("RELEASE SAVEPOINT " + name)
+	return err
+}
+
+// SqlDax is a structure type which is embedded in a dax structure accessing
+// a database, and provides a method to get a SqlDaxConn.
+type SqlDax struct {
+	sabi.Dax
+}
+
+// NewSqlDax is a function which creates a new SqlDax with a specified Dax.
+func NewSqlDax(dax sabi.Dax) SqlDax {
+	return SqlDax{Dax: dax}
+}
+
+// GetSqlDaxConn is a method which gets a SqlDaxConn by a specified name.
+// If a DaxConn registered with the name is not a SqlDaxConn, this method
+// returns an Err having the reason sabi.DaxConnIsNotExpectedType.
+func (dax SqlDax) GetSqlDaxConn(name string) (*SqlDaxConn, sabi.Err) {
+	return sabi.GetDaxConn[*SqlDaxConn](dax.Dax, name)
+}
@@ -0,0 +1,379 @@
+package sqldax
+
+import (
+	"context"
+	"database/sql/driver"
+	"github.com/stretchr/testify/assert"
+	"github.com/sttk-go/sabi"
+	"testing"
+)
+
+type UserDax struct {
+	SqlDax
+}
+
+func (dax UserDax) AddUser(name string) sabi.Err {
+	conn, err := dax.GetSqlDaxConn("sql")
+	if !err.IsOk() {
+		return err
+	}
+	_, err = conn.Exec("INSERT INTO users (name) VALUES (?)", name)
+	return err
+}
+
+func (dax UserDax) GetUserNames() ([]string, sabi.Err) {
+	conn, err := dax.GetSqlDaxConn("sql")
+	if !err.IsOk() {
+		return nil, err
+	}
+	rows, err := conn.Query("SELECT name FROM users")
+	if !err.IsOk() {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := make([]string, 0)
+	for rows.Next() {
+		var name string
+		e := rows.Scan(&name)
+		if e != nil {
+			return nil, sabi.ErrBy(FailToQuery{}, e)
+		}
+		names = append(names, name)
+	}
+	return names, sabi.Ok()
+}
+
+type FailToRun struct{}
+
+func newUserProc() (sabi.Proc[UserDax], *sabi.DaxBase) {
+	base := sabi.NewDaxBase()
+	base.AddLocalDaxSrc("sql", NewSqlDaxSrc(openFakeDB()))
+	dax := UserDax{SqlDax: NewSqlDax(base)}
+	return sabi.NewProc[UserDax](base, dax), base
+}
+
+func TestOpenSqlDaxSrc(t *testing.T) {
+	ds, err := OpenSqlDaxSrc("sabi-fake", "")
+	assert.True(t, err.IsOk())
+	assert.NotNil(t, ds.DB())
+	ds.DB().Close()
+}
+
+func TestOpenSqlDaxSrc_failToOpenDB(t *testing.T) {
+	_, err := OpenSqlDaxSrc("no-such-driver", "")
+	switch err.Reason().(type) {
+	case FailToOpenDB:
+		assert.Equal(t, err.Get("Driver"), "no-such-driver")
+	default:
+		assert.Fail(t, err.Error())
+	}
+}
+
+func TestSqlDaxConn_commit(t *testing.T) {
+	clearFake()
+	defer clearFake()
+
+	proc, _ := newUserProc()
+	err := proc.RunTxn(func(dax UserDax) sabi.Err {
+		return dax.AddUser("alice")
+	})
+	assert.True(t, err.IsOk())
+
+	assert.Equal(t, getFakeLogs(), []string{
+		"begin",
+		"exec:INSERT INTO users (name) VALUES (?):alice",
+		"commit",
+	})
+}
+
+func TestSqlDaxConn_rollback(t *testing.T) {
+	clearFake()
+	defer clearFake()
+
+	proc, _ := newUserProc()
+	err := proc.RunTxn(func(dax UserDax) sabi.Err {
+		err := dax.AddUser("alice")
+		if !err.IsOk() {
+			return err
+		}
+		return sabi.ErrBy(FailToRun{})
+	})
+	switch err.Reason().(type) {
+	case FailToRun:
+	default:
+		assert.Fail(t, err.Error())
+	}
+
+	assert.Equal(t, getFakeLogs(), []string{
+		"begin",
+		"exec:INSERT INTO users (name) VALUES (?):alice",
+		"rollback",
+	})
+}
+
+func TestSqlDaxConn_notBegunIfNotUsed(t *testing.T) {
+	clearFake()
+	defer clearFake()
+
+	proc, _ := newUserProc()
+	err := proc.RunTxn(func(dax UserDax) sabi.Err {
+		_, err := dax.GetSqlDaxConn("sql")
+		return err
+	})
+	assert.True(t, err.IsOk())
+
+	assert.Equal(t, getFakeLogs(), []string{})
+}
+
+func TestSqlDaxConn_Query(t *testing.T) {
+	clearFake()
+	defer clearFake()
+
+	setFakeResult("SELECT name FROM users", []string{"name"},
+		[]driver.Value{"alice"}, []driver.Value{"bob"})
+
+	var names []string
+	proc, _ := newUserProc()
+	err := proc.RunTxn(func(dax UserDax) sabi.Err {
+		var err sabi.Err
+		names, err = dax.GetUserNames()
+		return err
+	})
+	assert.True(t, err.IsOk())
+	assert.Equal(t, names, []string{"alice", "bob"})
+}
+
+func TestSqlDaxConn_QueryRow(t *testing.T) {
+	clearFake()
+	defer clearFake()
+
+	setFakeResult("SELECT name FROM users WHERE id = ?", []string{"name"},
+		[]driver.Value{"alice"})
+
+	var name string
+	proc, _ := newUserProc()
+	err := proc.RunTxn(func(dax UserDax) sabi.Err {
+		conn, err := dax.GetSqlDaxConn("sql")
+		if !err.IsOk() {
+			return err
+		}
+		row, err := conn.QueryRow("SELECT name FROM users WHERE id = ?", 1)
+		if !err.IsOk() {
+			return err
+		}
+		e := row.Scan(&name)
+		if e != nil {
+			return sabi.ErrBy(FailToRun{}, e)
+		}
+		return sabi.Ok()
+	})
+	assert.True(t, err.IsOk())
+	assert.Equal(t, name, "alice")
+}
+
+func TestSqlDaxConn_readOnly(t *testing.T) {
+	clearFake()
+	defer clearFake()
+
+	proc, _ := newUserProc()
+	err := proc.ReadOnly().RunTxn(func(dax UserDax) sabi.Err {
+		_, err := dax.GetUserNames()
+		return err
+	})
+	assert.True(t, err.IsOk())
+
+	assert.Equal(t, getFakeLogs(), []string{
+		"begin:read-only",
+		"query:SELECT name FROM users",
+		"rollback",
+	})
+}
+
+func TestSqlDaxConn_SetReadOnly_alreadyBegun(t *testing.T) {
+	clearFake()
+	defer clearFake()
+
+	conn, _ := NewSqlDaxSrc(openFakeDB()).CreateDaxConn()
+	sqlConn := conn.(*SqlDaxConn)
+	_, err := sqlConn.Tx()
+	assert.True(t, err.IsOk())
+
+	err = sqlConn.SetReadOnly()
+	switch err.Reason().(type) {
+	case sabi.TxnIsReadOnly:
+	default:
+		assert.Fail(t, err.Error())
+	}
+	sqlConn.Close()
+}
+
+func TestSqlDaxConn_failToBeginTx(t *testing.T) {
+	clearFake()
+	defer clearFake()
+
+	setFakeError("begin")
+
+	proc, _ := newUserProc()
+	err := proc.RunTxn(func(dax UserDax) sabi.Err {
+		return dax.AddUser("alice")
+	})
+	switch err.Reason().(type) {
+	case FailToBeginTx:
+		assert.NotNil(t, err.Cause())
+	default:
+		assert.Fail(t, err.Error())
+	}
+}
+
+func TestSqlDaxConn_failToExec(t *testing.T) {
+	clearFake()
+	defer clearFake()
+
+	setFakeError("exec:INSERT INTO users (name) VALUES (?)")
+
+	proc, _ := newUserProc()
+	err := proc.RunTxn(func(dax UserDax) sabi.Err {
+		return dax.AddUser("alice")
+	})
+	switch err.Reason().(type) {
+	case FailToExec:
+		assert.Equal(t, err.Get("Query"), "INSERT INTO users (name) VALUES (?)")
+		assert.Equal(t, err.Cause(), errFake)
+	default:
+		assert.Fail(t, err.Error())
+	}
+
+	assert.Equal(t, getFakeLogs(), []string{
+		"begin",
+		"exec:INSERT INTO users (name) VALUES (?):alice",
+		"rollback",
+	})
+}
+
+func TestSqlDaxConn_failToQuery(t *testing.T) {
+	clearFake()
+	defer clearFake()
+
+	setFakeError("query:SELECT name FROM users")
+
+	proc, _ := newUserProc()
+	err := proc.RunTxn(func(dax UserDax) sabi.Err {
+		_, err := dax.GetUserNames()
+		return err
+	})
+	switch err.Reason().(type) {
+	case FailToQuery:
+		assert.Equal(t, err.Get("Query"), "SELECT name FROM users")
+	default:
+		assert.Fail(t, err.Error())
+	}
+}
+
+func TestSqlDaxConn_failToCommitTx(t *testing.T) {
+	clearFake()
+	defer clearFake()
+
+	setFakeError("commit")
+
+	proc, _ := newUserProc()
+	err := proc.RunTxn(func(dax UserDax) sabi.Err {
+		return dax.AddUser("alice")
+	})
+	switch err.Reason().(type) {
+	case sabi.FailToCommitDaxConn:
+		errs := err.Get("Errors").(map[string]sabi.Err)
+		assert.Equal(t, errs["sql"].ReasonName(), "FailToCommitTx")
+	default:
+		assert.Fail(t, err.Error())
+	}
+}
+
+func TestSqlDaxConn_Savepoint(t *testing.T) {
+	clearFake()
+	defer clearFake()
+
+	proc, base := newUserProc()
+	err := proc.RunTxn(func(dax UserDax) sabi.Err {
+		err := dax.AddUser("alice")
+		if !err.IsOk() {
+			return err
+		}
+		err = sabi.Savepoint(base, func() sabi.Err {
+			err := dax.AddUser("bob")
+			if !err.IsOk() {
+				return err
+			}
+			return sabi.ErrBy(FailToRun{})
+		})
+		assert.False(t, err.IsOk())
+		return sabi.Savepoint(base, func() sabi.Err {
+			return dax.AddUser("carol")
+		})
+	})
+	assert.True(t, err.IsOk())
+
+	assert.Equal(t, getFakeLogs(), []string{
+		"begin",
+		"exec:INSERT INTO users (name) VALUES (?):alice",
+		"exec:SAVEPOINT sabi_sp_1",
+		"exec:INSERT INTO users (name) VALUES (?):bob",
+		"exec:ROLLBACK TO SAVEPOINT sabi_sp_1",
+		"exec:SAVEPOINT sabi_sp_2",
+		"exec:INSERT INTO users (name) VALUES (?):carol",
+		"exec:RELEASE SAVEPOINT sabi_sp_2",
+		"commit",
+	})
+}
+
+func TestSqlDaxConn_canceled(t *testing.T) {
+	clearFake()
+	defer clearFake()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	conn, _ := NewSqlDaxSrc(openFakeDB()).CreateDaxConnCtx(ctx)
+	_, err := conn.(*SqlDaxConn).Exec("INSERT INTO users (name) VALUES (?)", "a")
+	switch err.Reason().(type) {
+	case FailToBeginTx:
+		assert.Equal(t, err.Cause(), context.Canceled)
+	default:
+		assert.Fail(t, err.Error())
+	}
+
+	assert.Equal(t, getFakeLogs(), []string{})
+}
+
+func TestSqlDax_GetSqlDaxConn_daxConnIsNotExpectedType(t *testing.T) {
+	base := sabi.NewDaxBase()
+	base.AddLocalDaxSrc("sql", otherDaxSrc{})
+
+	conn, err := NewSqlDax(base).GetSqlDaxConn("sql")
+	assert.Nil(t, conn)
+	switch err.Reason().(type) {
+	case sabi.DaxConnIsNotExpectedType:
+		assert.Equal(t, err.Get("Expected"), "*sqldax.SqlDaxConn")
+		assert.Equal(t, err.Get("Actual"), "sqldax.otherDaxConn")
+	default:
+		assert.Fail(t, err.Error())
+	}
+}
+
+type otherDaxConn struct{}
+
+func (conn otherDaxConn) Commit() sabi.Err {
+	return sabi.Ok()
+}
+
+func (conn otherDaxConn) Rollback() {
+}
+
+func (conn otherDaxConn) Close() {
+}
+
+type otherDaxSrc struct{}
+
+func (ds otherDaxSrc) CreateDaxConn() (sabi.DaxConn, sabi.Err) {
+	return otherDaxConn{}, sabi.Ok()
+}
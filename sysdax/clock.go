@@ -0,0 +1,161 @@
+// Copyright (C) 2023 Takayuki Sato. All Rights Reserved.
+// This program is free software under MIT License.
+// See the file LICENSE in this distribution for more details.
+
+// Package sysdax provides DaxSrc and DaxConn of sabi which access system
+// resources: a clock, an ID generator and a random number generator.
+//
+// Logics which get current time, new IDs and random numbers through these
+// DaxConn instead of calling time.Now and so on directly can be tested
+// deterministically by registering fixed or fake versions of them.
+package sysdax
+
+import (
+	"github.com/sttk-go/sabi"
+	"sync"
+	"time"
+)
+
+// Clock is an interface which provides current time.
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock is a structure type which implements Clock and provides
+// current time of the system.
+type SystemClock struct{}
+
+// Now is a method which returns time.Now().
+func (clock SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+// FixedClock is a structure type which implements Clock and always provides
+// a same time.
+type FixedClock struct {
+	Time time.Time
+}
+
+// Now is a method which returns the fixed time.
+func (clock FixedClock) Now() time.Time {
+	return clock.Time
+}
+
+// FakeClock is a structure type which implements Clock and provides time
+// which is changed only by #Set and #Advance.
+type FakeClock struct {
+	now   time.Time
+	mutex sync.Mutex
+}
+
+// NewFakeClock is a function which creates a new FakeClock of which time is
+// a specified time.
+func NewFakeClock(t time.Time) *FakeClock {
+	return &FakeClock{now: t}
+}
+
+// Now is a method which returns the current time of this FakeClock.
+func (clock *FakeClock) Now() time.Time {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+
+	return clock.now
+}
+
+// Set is a method which sets the current time of this FakeClock.
+func (clock *FakeClock) Set(t time.Time) {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+
+	clock.now = t
+}
+
+// Advance is a method which advances the current time of this FakeClock by a
+// specified duration.
+func (clock *FakeClock) Advance(d time.Duration) {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+
+	clock.now = clock.now.Add(d)
+}
+
+// ClockDaxSrc is a structure type which implements sabi.DaxSrc and creates
+// ClockDaxConn.
+type ClockDaxSrc struct {
+	clock Clock
+}
+
+// NewClockDaxSrc is a function which creates a new ClockDaxSrc with a
+// specified Clock.
+// If the clock is nil, SystemClock is used.
+func NewClockDaxSrc(clock Clock) ClockDaxSrc {
+	if clock == nil {
+		clock = SystemClock{}
+	}
+	return ClockDaxSrc{clock: clock}
+}
+
+// CreateDaxConn is a method which creates a new ClockDaxConn.
+func (ds ClockDaxSrc) CreateDaxConn() (sabi.DaxConn, sabi.Err) {
+	return &ClockDaxConn{clock: ds.clock}, sabi.Ok()
+}
+
+// ClockDaxConn is a structure type which implements sabi.DaxConn and
+// provides current time in a transaction.
+type ClockDaxConn struct {
+	clock   Clock
+	txnTime time.Time
+	mutex   sync.Mutex
+}
+
+// Now is a method which returns current time of the Clock.
+func (conn *ClockDaxConn) Now() time.Time {
+	return conn.clock.Now()
+}
+
+// TxnTime is a method which returns time of the Clock at the first call of
+// this method in a transaction, so that all records written in the
+// transaction can have a same timestamp.
+func (conn *ClockDaxConn) TxnTime() time.Time {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	if conn.txnTime.IsZero() {
+		conn.txnTime = conn.clock.Now()
+	}
+	return conn.txnTime
+}
+
+// Commit is a method which does nothing.
+func (conn *ClockDaxConn) Commit() sabi.Err {
+	return sabi.Ok()
+}
+
+// Rollback is a method which does nothing.
+func (conn *ClockDaxConn) Rollback() {
+}
+
+// Close is a method which clears the transaction time.
+func (conn *ClockDaxConn) Close() {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	conn.txnTime = time.Time{}
+}
+
+// ClockDax is a structure type which is embedded in a dax structure using
+// current time, and provides a method to get a ClockDaxConn.
+type ClockDax struct {
+	sabi.Dax
+}
+
+// NewClockDax is a function which creates a new ClockDax with a specified
+// Dax.
+func NewClockDax(dax sabi.Dax) ClockDax {
+	return ClockDax{Dax: dax}
+}
+
+// GetClockDaxConn is a method which gets a ClockDaxConn by a specified name.
+func (dax ClockDax) GetClockDaxConn(name string) (*ClockDaxConn, sabi.Err) {
+	return sabi.GetDaxConn[*ClockDaxConn](dax.Dax, name)
+}
@@ -0,0 +1,124 @@
+package sysdax
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/sttk-go/sabi"
+	"testing"
+	"time"
+)
+
+type Order struct {
+	ID       string
+	Created  time.Time
+	Updated  time.Time
+	Discount int
+}
+
+type OrderDax struct {
+	ClockDax
+	IdDax
+	RandDax
+}
+
+func (dax OrderDax) NewOrder() (Order, sabi.Err) {
+	clock, err := dax.GetClockDaxConn("clock")
+	if !err.IsOk() {
+		return Order{}, err
+	}
+	ids, err := dax.GetIdDaxConn("id")
+	if !err.IsOk() {
+		return Order{}, err
+	}
+	rnd, err := dax.GetRandDaxConn("rand")
+	if !err.IsOk() {
+		return Order{}, err
+	}
+
+	id, err := ids.NewID()
+	if !err.IsOk() {
+		return Order{}, err
+	}
+	return Order{
+		ID:       id,
+		Created:  clock.TxnTime(),
+		Updated:  clock.Now(),
+		Discount: rnd.Intn(100),
+	}, sabi.Ok()
+}
+
+func newOrderProc(clock Clock, gen IdGenerator, rnd RandDaxSrc) sabi.Proc[OrderDax] {
+	base := sabi.NewDaxBase()
+	base.AddLocalDaxSrc("clock", NewClockDaxSrc(clock))
+	base.AddLocalDaxSrc("id", NewIdDaxSrc(gen))
+	base.AddLocalDaxSrc("rand", rnd)
+	dax := OrderDax{
+		ClockDax: NewClockDax(base),
+		IdDax:    NewIdDax(base),
+		RandDax:  NewRandDax(base),
+	}
+	return sabi.NewProc[OrderDax](base, dax)
+}
+
+func TestClockDaxConn_fixedClock(t *testing.T) {
+	tm := time.Date(2023, 4, 5, 6, 7, 8, 0, time.UTC)
+	proc := newOrderProc(FixedClock{Time: tm}, nil, NewRandDaxSrc())
+
+	err := proc.RunTxn(func(dax OrderDax) sabi.Err {
+		order, err := dax.NewOrder()
+		if !err.IsOk() {
+			return err
+		}
+		assert.Equal(t, order.Created, tm)
+		assert.Equal(t, order.Updated, tm)
+		return sabi.Ok()
+	})
+	assert.True(t, err.IsOk())
+}
+
+func TestClockDaxConn_fakeClock(t *testing.T) {
+	tm := time.Date(2023, 4, 5, 6, 7, 8, 0, time.UTC)
+	clock := NewFakeClock(tm)
+	proc := newOrderProc(clock, nil, NewRandDaxSrc())
+
+	err := proc.RunTxn(func(dax OrderDax) sabi.Err {
+		conn, err := dax.GetClockDaxConn("clock")
+		if !err.IsOk() {
+			return err
+		}
+		assert.Equal(t, conn.TxnTime(), tm)
+
+		clock.Advance(time.Minute)
+		assert.Equal(t, conn.TxnTime(), tm)
+		assert.Equal(t, conn.Now(), tm.Add(time.Minute))
+		return sabi.Ok()
+	})
+	assert.True(t, err.IsOk())
+
+	clock.Set(tm.Add(time.Hour))
+
+	err = proc.RunTxn(func(dax OrderDax) sabi.Err {
+		order, err := dax.NewOrder()
+		if !err.IsOk() {
+			return err
+		}
+		assert.Equal(t, order.Created, tm.Add(time.Hour))
+		return sabi.Ok()
+	})
+	assert.True(t, err.IsOk())
+}
+
+func TestClockDaxConn_systemClock(t *testing.T) {
+	proc := newOrderProc(nil, nil, NewRandDaxSrc())
+
+	before := time.Now()
+	err := proc.RunTxn(func(dax OrderDax) sabi.Err {
+		order, err := dax.NewOrder()
+		if !err.IsOk() {
+			return err
+		}
+		assert.False(t, order.Created.Before(before))
+		assert.False(t, order.Updated.Before(order.Created))
+		return sabi.Ok()
+	})
+	assert.True(t, err.IsOk())
+}
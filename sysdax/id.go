@@ -0,0 +1,127 @@
+// Copyright (C) 2023 Takayuki Sato. All Rights Reserved.
+// This program is free software under MIT License.
+// See the file LICENSE in this distribution for more details.
+
+package sysdax
+
+import (
+	"crypto/rand"
+	"fmt"
+	"github.com/sttk-go/sabi"
+	"strconv"
+	"sync"
+)
+
+type /* error reasons */ (
+	// FailToGenerateID is an error reason which indicates that it failed to
+	// generate a new ID.
+	// The cause of an Err having this reason is an error returned by the
+	// crypto/rand package.
+	FailToGenerateID struct{}
+)
+
+// IdGenerator is an interface which generates new IDs.
+type IdGenerator interface {
+	NewID() (string, sabi.Err)
+}
+
+// UuidGenerator is a structure type which implements IdGenerator and
+// generates random UUIDs (version 4).
+type UuidGenerator struct{}
+
+// NewID is a method which generates a new random UUID.
+func (gen UuidGenerator) NewID() (string, sabi.Err) {
+	var b [16]byte
+	_, e := rand.Read(b[:])
+	if e != nil {
+		return "", sabi.ErrBy(FailToGenerateID{}, e)
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), sabi.Ok()
+}
+
+// SeqIdGenerator is a structure type which implements IdGenerator and
+// generates sequential IDs with a prefix, like "id-1", "id-2", ..., and is
+// mainly used in tests.
+type SeqIdGenerator struct {
+	prefix string
+	seq    int
+	mutex  sync.Mutex
+}
+
+// NewSeqIdGenerator is a function which creates a new SeqIdGenerator which
+// generates IDs with a specified prefix.
+func NewSeqIdGenerator(prefix string) *SeqIdGenerator {
+	return &SeqIdGenerator{prefix: prefix}
+}
+
+// NewID is a method which generates a next sequential ID.
+func (gen *SeqIdGenerator) NewID() (string, sabi.Err) {
+	gen.mutex.Lock()
+	defer gen.mutex.Unlock()
+
+	gen.seq++
+	return gen.prefix + strconv.Itoa(gen.seq), sabi.Ok()
+}
+
+// IdDaxSrc is a structure type which implements sabi.DaxSrc and creates
+// IdDaxConn.
+type IdDaxSrc struct {
+	gen IdGenerator
+}
+
+// NewIdDaxSrc is a function which creates a new IdDaxSrc with a specified
+// IdGenerator.
+// If the generator is nil, UuidGenerator is used.
+func NewIdDaxSrc(gen IdGenerator) IdDaxSrc {
+	if gen == nil {
+		gen = UuidGenerator{}
+	}
+	return IdDaxSrc{gen: gen}
+}
+
+// CreateDaxConn is a method which creates a new IdDaxConn.
+func (ds IdDaxSrc) CreateDaxConn() (sabi.DaxConn, sabi.Err) {
+	return &IdDaxConn{gen: ds.gen}, sabi.Ok()
+}
+
+// IdDaxConn is a structure type which implements sabi.DaxConn and generates
+// new IDs in a transaction.
+type IdDaxConn struct {
+	gen IdGenerator
+}
+
+// NewID is a method which generates a new ID with the IdGenerator.
+func (conn *IdDaxConn) NewID() (string, sabi.Err) {
+	return conn.gen.NewID()
+}
+
+// Commit is a method which does nothing.
+func (conn *IdDaxConn) Commit() sabi.Err {
+	return sabi.Ok()
+}
+
+// Rollback is a method which does nothing.
+func (conn *IdDaxConn) Rollback() {
+}
+
+// Close is a method which does nothing.
+func (conn *IdDaxConn) Close() {
+}
+
+// IdDax is a structure type which is embedded in a dax structure generating
+// IDs, and provides a method to get an IdDaxConn.
+type IdDax struct {
+	sabi.Dax
+}
+
+// NewIdDax is a function which creates a new IdDax with a specified Dax.
+func NewIdDax(dax sabi.Dax) IdDax {
+	return IdDax{Dax: dax}
+}
+
+// GetIdDaxConn is a method which gets an IdDaxConn by a specified name.
+func (dax IdDax) GetIdDaxConn(name string) (*IdDaxConn, sabi.Err) {
+	return sabi.GetDaxConn[*IdDaxConn](dax.Dax, name)
+}
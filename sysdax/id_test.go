@@ -0,0 +1,53 @@
+package sysdax
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/sttk-go/sabi"
+	"regexp"
+	"testing"
+)
+
+func TestIdDaxConn_seqIdGenerator(t *testing.T) {
+	proc := newOrderProc(nil, NewSeqIdGenerator("order-"), NewRandDaxSrc())
+
+	ids := make([]string, 0)
+	for i := 0; i < 3; i++ {
+		err := proc.RunTxn(func(dax OrderDax) sabi.Err {
+			order, err := dax.NewOrder()
+			if !err.IsOk() {
+				return err
+			}
+			ids = append(ids, order.ID)
+			return sabi.Ok()
+		})
+		assert.True(t, err.IsOk())
+	}
+	assert.Equal(t, ids, []string{"order-1", "order-2", "order-3"})
+}
+
+func TestIdDaxConn_uuidGenerator(t *testing.T) {
+	proc := newOrderProc(nil, nil, NewRandDaxSrc())
+
+	re := regexp.MustCompile(
+		`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+	err := proc.RunTxn(func(dax OrderDax) sabi.Err {
+		conn, err := dax.GetIdDaxConn("id")
+		if !err.IsOk() {
+			return err
+		}
+		id1, err := conn.NewID()
+		if !err.IsOk() {
+			return err
+		}
+		id2, err := conn.NewID()
+		if !err.IsOk() {
+			return err
+		}
+		assert.Regexp(t, re, id1)
+		assert.Regexp(t, re, id2)
+		assert.NotEqual(t, id1, id2)
+		return sabi.Ok()
+	})
+	assert.True(t, err.IsOk())
+}
@@ -0,0 +1,114 @@
+// Copyright (C) 2023 Takayuki Sato. All Rights Reserved.
+// This program is free software under MIT License.
+// See the file LICENSE in this distribution for more details.
+
+package sysdax
+
+import (
+	"github.com/sttk-go/sabi"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+type lockedRand struct {
+	rand  *rand.Rand
+	mutex sync.Mutex
+}
+
+// RandDaxSrc is a structure type which implements sabi.DaxSrc and creates
+// RandDaxConn.
+// All RandDaxConn created by a same RandDaxSrc share a random number
+// generator.
+type RandDaxSrc struct {
+	rand *lockedRand
+}
+
+// NewRandDaxSrc is a function which creates a new RandDaxSrc of which random
+// number generator is seeded with current time.
+func NewRandDaxSrc() RandDaxSrc {
+	return NewSeededRandDaxSrc(time.Now().UnixNano())
+}
+
+// NewSeededRandDaxSrc is a function which creates a new RandDaxSrc of which
+// random number generator is seeded with a specified seed, so that it
+// generates a deterministic sequence of numbers.
+func NewSeededRandDaxSrc(seed int64) RandDaxSrc {
+	return RandDaxSrc{rand: &lockedRand{rand: rand.New(rand.NewSource(seed))}}
+}
+
+// CreateDaxConn is a method which creates a new RandDaxConn.
+func (ds RandDaxSrc) CreateDaxConn() (sabi.DaxConn, sabi.Err) {
+	return &RandDaxConn{rand: ds.rand}, sabi.Ok()
+}
+
+// RandDaxConn is a structure type which implements sabi.DaxConn and
+// generates pseudo-random numbers in a transaction.
+type RandDaxConn struct {
+	rand *lockedRand
+}
+
+// Int63 is a method which returns a non-negative pseudo-random 63-bit
+// integer.
+func (conn *RandDaxConn) Int63() int64 {
+	conn.rand.mutex.Lock()
+	defer conn.rand.mutex.Unlock()
+
+	return conn.rand.rand.Int63()
+}
+
+// Intn is a method which returns a non-negative pseudo-random integer in
+// [0,n).
+// This method panics if n <= 0.
+func (conn *RandDaxConn) Intn(n int) int {
+	conn.rand.mutex.Lock()
+	defer conn.rand.mutex.Unlock()
+
+	return conn.rand.rand.Intn(n)
+}
+
+// Float64 is a method which returns a pseudo-random number in [0.0,1.0).
+func (conn *RandDaxConn) Float64() float64 {
+	conn.rand.mutex.Lock()
+	defer conn.rand.mutex.Unlock()
+
+	return conn.rand.rand.Float64()
+}
+
+// Shuffle is a method which pseudo-randomizes the order of elements with a
+// specified number of elements and a function swapping elements.
+func (conn *RandDaxConn) Shuffle(n int, swap func(i, j int)) {
+	conn.rand.mutex.Lock()
+	defer conn.rand.mutex.Unlock()
+
+	conn.rand.rand.Shuffle(n, swap)
+}
+
+// Commit is a method which does nothing.
+func (conn *RandDaxConn) Commit() sabi.Err {
+	return sabi.Ok()
+}
+
+// Rollback is a method which does nothing.
+func (conn *RandDaxConn) Rollback() {
+}
+
+// Close is a method which does nothing.
+func (conn *RandDaxConn) Close() {
+}
+
+// RandDax is a structure type which is embedded in a dax structure using
+// random numbers, and provides a method to get a RandDaxConn.
+type RandDax struct {
+	sabi.Dax
+}
+
+// NewRandDax is a function which creates a new RandDax with a specified Dax.
+func NewRandDax(dax sabi.Dax) RandDax {
+	return RandDax{Dax: dax}
+}
+
+// GetRandDaxConn is a method which gets a RandDaxConn by a specified name.
+func (dax RandDax) GetRandDaxConn(name string) (*RandDaxConn, sabi.Err) {
+	return sabi.GetDaxConn[*RandDaxConn](dax.Dax, name)
+}
@@ -0,0 +1,40 @@
+package sysdax
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/sttk-go/sabi"
+	"testing"
+)
+
+func runRand(t *testing.T, ds RandDaxSrc) []any {
+	proc := newOrderProc(nil, nil, ds)
+
+	var a []any
+	err := proc.RunTxn(func(dax OrderDax) sabi.Err {
+		conn, err := dax.GetRandDaxConn("rand")
+		if !err.IsOk() {
+			return err
+		}
+		s := []int{1, 2, 3, 4, 5}
+		conn.Shuffle(len(s), func(i, j int) { s[i], s[j] = s[j], s[i] })
+		a = append(a, conn.Int63(), conn.Intn(10), conn.Float64(), s)
+		return sabi.Ok()
+	})
+	assert.True(t, err.IsOk())
+	return a
+}
+
+func TestRandDaxConn_seeded(t *testing.T) {
+	a := runRand(t, NewSeededRandDaxSrc(42))
+	b := runRand(t, NewSeededRandDaxSrc(42))
+	assert.Equal(t, a, b)
+
+	c := runRand(t, NewSeededRandDaxSrc(43))
+	assert.NotEqual(t, a, c)
+
+	n := a[1].(int)
+	assert.True(t, n >= 0 && n < 10)
+	f := a[2].(float64)
+	assert.True(t, f >= 0.0 && f < 1.0)
+	assert.ElementsMatch(t, a[3], []int{1, 2, 3, 4, 5})
+}
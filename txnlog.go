@@ -0,0 +1,393 @@
+// Copyright (C) 2023 Takayuki Sato. All Rights Reserved.
+// This program is free software under MIT License.
+// See the file LICENSE in this distribution for more details.
+
+package sabi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type /* error reasons */ (
+	// FailToOpenTxnLog is an error reason which indicates that it failed to
+	// open a transaction log file.
+	// The field Path is the path of the file.
+	FailToOpenTxnLog struct {
+		Path string
+	}
+
+	// FailToWriteTxnLog is an error reason which indicates that it failed to
+	// write a record to a transaction log file.
+	// The field Path is the path of the file and the field TxnId is the id of
+	// the transaction of the record.
+	FailToWriteTxnLog struct {
+		Path  string
+		TxnId string
+	}
+
+	// FailToReadTxnLog is an error reason which indicates that it failed to
+	// read records from a transaction log file.
+	// The field Path is the path of the file.
+	FailToReadTxnLog struct {
+		Path string
+	}
+
+	// DaxSrcIsNotRecoverable is an error reason which indicates that a DaxSrc
+	// which participated in an incomplete transaction does not implement
+	// RecoverableDaxSrc.
+	// The field Name is a registered name of the DaxSrc.
+	DaxSrcIsNotRecoverable struct {
+		Name string
+	}
+
+	// FailToRecoverTxn is an error reason which indicates that it failed to
+	// recover an incomplete transaction recorded in a transaction log.
+	// The field TxnId is the id of the transaction, and the field Errors is a
+	// map of which keys are registered names of DaxSrc which failed to recover
+	// and of which values are Err instances holding their error reasons.
+	FailToRecoverTxn struct {
+		TxnId  string
+		Errors map[string]Err
+	}
+)
+
+// RecoverableDaxSrc is an interface which is optionally implemented by a
+// DaxSrc to re-create a DaxConn which participated in an incomplete
+// transaction.
+// The DaxConn created by #RecoverDaxConn is committed or rollbacked and closed
+// by TxnLog#Recover, in the same commit order and with the same rule for
+// PreparableDaxConn as DaxBase commits a transaction.
+// A transaction which reached its commit phase is committed only if all its
+// DaxConn are recovered, and the commit stops at the first failure.
+// Since TxnLog#Recover commits all participants of a transaction which reached
+// its commit phase, including ones which already committed before a crash, a
+// commit of the recovered DaxConn is required to be idempotent.
+type RecoverableDaxSrc interface {
+	DaxSrc
+	RecoverDaxConn(txnId string) (DaxConn, Err)
+}
+
+const (
+	txnPhasePrepare  = "prepare"
+	txnPhaseCommit   = "commit"
+	txnPhaseRollback = "rollback"
+	txnPhaseEnd      = "end"
+)
+
+type txnLogRecord struct {
+	TxnId string   `json:"txn"`
+	Phase string   `json:"phase"`
+	Names []string `json:"names,omitempty"`
+}
+
+// TxnLog is a structure type which represents an append-only file to record
+// transaction ids, participating DaxConn names and phase transitions of
+// commit processes of DaxBase.
+// Records of transactions which reached their end are discarded when a next
+// transaction is prepared while no other transaction recorded in the file is
+// incomplete.
+type TxnLog struct {
+	path        string
+	file        *os.File
+	mutex       sync.Mutex
+	pendingTxns map[string]bool
+}
+
+// OpenTxnLog is a function which opens a transaction log file at a specified
+// path.
+// If the file does not exist, this function creates it.
+func OpenTxnLog(path string) (*TxnLog, Err) {
+	f, e := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0644)
+	if e != nil {
+		return nil, ErrBy(FailToOpenTxnLog{Path: path}, e)
+	}
+
+	log := &TxnLog{path: path, file: f, pendingTxns: make(map[string]bool)}
+
+	recs, err := log.read()
+	if !err.IsOk() {
+		f.Close()
+		return nil, err
+	}
+
+	txnIds, _, phaseMap := summarizeTxnLog(recs)
+	for _, txnId := range txnIds {
+		if phaseMap[txnId] != txnPhaseEnd {
+			log.pendingTxns[txnId] = true
+		}
+	}
+
+	return log, Ok()
+}
+
+// Close is a method which closes the transaction log file.
+func (log *TxnLog) Close() {
+	log.mutex.Lock()
+	defer log.mutex.Unlock()
+
+	log.file.Close()
+}
+
+func (log *TxnLog) write(rec txnLogRecord) Err {
+	b, e := json.Marshal(rec)
+	if e != nil {
+		return ErrBy(FailToWriteTxnLog{Path: log.path, TxnId: rec.TxnId}, e)
+	}
+	b = append(b, '\n')
+
+	log.mutex.Lock()
+	defer log.mutex.Unlock()
+
+	if rec.Phase == txnPhasePrepare && len(log.pendingTxns) == 0 {
+		e = log.file.Truncate(0)
+		if e != nil {
+			return ErrBy(FailToWriteTxnLog{Path: log.path, TxnId: rec.TxnId}, e)
+		}
+	}
+
+	_, e = log.file.Write(b)
+	if e == nil {
+		e = log.file.Sync()
+	}
+	if e != nil {
+		return ErrBy(FailToWriteTxnLog{Path: log.path, TxnId: rec.TxnId}, e)
+	}
+
+	switch rec.Phase {
+	case txnPhasePrepare:
+		log.pendingTxns[rec.TxnId] = true
+	case txnPhaseEnd:
+		delete(log.pendingTxns, rec.TxnId)
+	}
+	return Ok()
+}
+
+func (log *TxnLog) read() ([]txnLogRecord, Err) {
+	log.mutex.Lock()
+	defer log.mutex.Unlock()
+
+	_, e := log.file.Seek(0, 0)
+	if e != nil {
+		return nil, ErrBy(FailToReadTxnLog{Path: log.path}, e)
+	}
+
+	b, e := io.ReadAll(log.file)
+	if e != nil {
+		return nil, ErrBy(FailToReadTxnLog{Path: log.path}, e)
+	}
+
+	recs := make([]txnLogRecord, 0)
+
+	for _, line := range bytes.Split(b, []byte{'\n'}) {
+		var rec txnLogRecord
+		e = json.Unmarshal(line, &rec)
+		if e != nil {
+			// A broken line is written by a process which died while writing it,
+			// so it is ignored.
+			continue
+		}
+		recs = append(recs, rec)
+	}
+
+	// Terminates a broken last line not to join it with a next record.
+	if len(b) > 0 && b[len(b)-1] != '\n' {
+		_, e = log.file.Write([]byte{'\n'})
+		if e != nil {
+			return nil, ErrBy(FailToWriteTxnLog{Path: log.path}, e)
+		}
+	}
+
+	return recs, Ok()
+}
+
+func (log *TxnLog) truncate() Err {
+	log.mutex.Lock()
+	defer log.mutex.Unlock()
+
+	e := log.file.Truncate(0)
+	if e != nil {
+		return ErrBy(FailToWriteTxnLog{Path: log.path}, e)
+	}
+	return Ok()
+}
+
+// Recover is a method which finishes incomplete transactions recorded in this
+// transaction log by using DaxSrc registered in a specified DaxBase or
+// globally.
+// A transaction which reached its commit phase is committed, and other
+// transactions are rollbacked.
+// Each DaxSrc which participated in an incomplete transaction is required to
+// implement RecoverableDaxSrc.
+// If all incomplete transactions are recovered, this method truncates the log
+// file.
+// This method is supposed to be called at the startup of an application before
+// any transaction runs.
+func (log *TxnLog) Recover(base *DaxBase) Err {
+	recs, err := log.read()
+	if !err.IsOk() {
+		return err
+	}
+
+	txnIds, nameMap, phaseMap := summarizeTxnLog(recs)
+
+	for _, txnId := range txnIds {
+		phase := phaseMap[txnId]
+		if phase == txnPhaseEnd {
+			continue
+		}
+
+		err = base.recoverTxn(txnId, nameMap[txnId], phase == txnPhaseCommit)
+		if !err.IsOk() {
+			return err
+		}
+
+		err = log.write(txnLogRecord{TxnId: txnId, Phase: txnPhaseEnd})
+		if !err.IsOk() {
+			return err
+		}
+	}
+
+	return log.truncate()
+}
+
+func summarizeTxnLog(recs []txnLogRecord) (
+	[]string, map[string][]string, map[string]string,
+) {
+	txnIds := make([]string, 0)
+	nameMap := make(map[string][]string)
+	phaseMap := make(map[string]string)
+
+	for _, rec := range recs {
+		_, exists := phaseMap[rec.TxnId]
+		if !exists {
+			txnIds = append(txnIds, rec.TxnId)
+		}
+		if rec.Phase == txnPhasePrepare {
+			nameMap[rec.TxnId] = rec.Names
+		}
+		if phaseMap[rec.TxnId] != txnPhaseEnd {
+			phaseMap[rec.TxnId] = rec.Phase
+		}
+	}
+
+	return txnIds, nameMap, phaseMap
+}
+
+func (base *DaxBase) recoverTxn(txnId string, names []string, isCommit bool) Err {
+	errs := make(map[string]Err)
+	ctx := context.WithValue(context.Background(), txnIdKey{}, txnId)
+
+	connMap := make(map[string]DaxConn)
+	orderMap := make(map[string]int)
+
+	for _, name := range names {
+		ds, order := base.findDaxSrcWithOrder(name)
+		if ds == nil {
+			errs[name] = ErrBy(DaxSrcIsNotFound{Name: name})
+			continue
+		}
+
+		rds, ok := ds.(RecoverableDaxSrc)
+		if !ok {
+			errs[name] = ErrBy(DaxSrcIsNotRecoverable{Name: name})
+			continue
+		}
+
+		conn, err := rds.RecoverDaxConn(txnId)
+		if !err.IsOk() {
+			errs[name] = err
+			continue
+		}
+
+		connMap[name] = conn
+		orderMap[name] = order
+	}
+
+	groups := groupDaxConnsByOrder(connMap, orderMap)
+
+	if !isCommit {
+		for _, group := range groups {
+			runDaxConnsInParallel(group, func(conn DaxConn) Err {
+				rollbackDaxConn(ctx, conn)
+				return Ok()
+			})
+		}
+	} else if len(errs) == 0 {
+		_, errs = commitDaxConnGroups(ctx, groups)
+	}
+
+	for _, group := range groups {
+		runDaxConnsInParallel(group, func(conn DaxConn) Err {
+			closeDaxConn(ctx, conn)
+			return Ok()
+		})
+	}
+
+	if len(errs) > 0 {
+		return ErrBy(FailToRecoverTxn{TxnId: txnId, Errors: errs})
+	}
+	return Ok()
+}
+
+type txnIdKey struct{}
+
+var txnIdSeq uint64
+
+func newTxnId() string {
+	seq := atomic.AddUint64(&txnIdSeq, 1)
+	return fmt.Sprintf("%d-%d-%d", time.Now().UnixNano(), os.Getpid(), seq)
+}
+
+// GetTxnId is a function which returns the id of a transaction which is
+// recorded in a transaction log.
+// This function is used in ContextDaxSrc#CreateDaxConnCtx to associate a
+// DaxConn with a transaction id which is passed to
+// RecoverableDaxSrc#RecoverDaxConn on recovery.
+// If a transaction log is not set to a DaxBase, this function returns an
+// empty string.
+func GetTxnId(ctx context.Context) string {
+	txnId, _ := ctx.Value(txnIdKey{}).(string)
+	return txnId
+}
+
+// SetTxnLog is a method which sets a transaction log to record phase
+// transitions of commit processes of this DaxBase.
+func (base *DaxBase) SetTxnLog(log *TxnLog) {
+	base.daxConnMutex.Lock()
+	defer base.daxConnMutex.Unlock()
+
+	base.txnLog = log
+}
+
+func (base *DaxBase) writeTxnLog(phase string) Err {
+	if base.txnLog == nil || len(base.txnId) == 0 {
+		return Ok()
+	}
+
+	rec := txnLogRecord{TxnId: base.txnId, Phase: phase}
+
+	if phase == txnPhasePrepare {
+		if len(base.daxConnMap) == 0 {
+			return Ok()
+		}
+		rec.Names = make([]string, 0, len(base.daxConnMap))
+		for name := range base.daxConnMap {
+			rec.Names = append(rec.Names, name)
+		}
+		sort.Strings(rec.Names)
+		base.isTxnLogged = true
+	} else if !base.isTxnLogged {
+		return Ok()
+	}
+
+	return base.txnLog.write(rec)
+}
@@ -0,0 +1,417 @@
+package sabi
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type RecoverableFooDaxSrc struct {
+	FooDaxSrc
+}
+
+func (ds RecoverableFooDaxSrc) CreateDaxConnCtx(ctx context.Context) (DaxConn, Err) {
+	logs.PushBack("RecoverableFooDaxSrc#CreateDaxConnCtx:" + GetTxnId(ctx))
+	return ds.CreateDaxConn()
+}
+
+func (ds RecoverableFooDaxSrc) RecoverDaxConn(txnId string) (DaxConn, Err) {
+	logs.PushBack("RecoverableFooDaxSrc#RecoverDaxConn:" + txnId)
+	return ds.CreateDaxConn()
+}
+
+type RecoverableQuxDaxSrc struct {
+	QuxDaxSrc
+}
+
+func (ds RecoverableQuxDaxSrc) RecoverDaxConn(txnId string) (DaxConn, Err) {
+	logs.PushBack("RecoverableQuxDaxSrc#RecoverDaxConn:" + txnId)
+	return ds.CreateDaxConn()
+}
+
+func readTxnLogFile(t *testing.T, path string) []string {
+	b, e := os.ReadFile(path)
+	assert.Nil(t, e)
+	s := strings.TrimSpace(string(b))
+	if len(s) == 0 {
+		return []string{}
+	}
+	return strings.Split(s, "\n")
+}
+
+func TestOpenTxnLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "txn.log")
+
+	log, err := OpenTxnLog(path)
+	assert.True(t, err.IsOk())
+	defer log.Close()
+
+	_, e := os.Stat(path)
+	assert.Nil(t, e)
+}
+
+func TestOpenTxnLog_failToOpen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "no", "such", "txn.log")
+
+	log, err := OpenTxnLog(path)
+	assert.Nil(t, log)
+	switch err.Reason().(type) {
+	case FailToOpenTxnLog:
+		assert.Equal(t, err.Get("Path"), path)
+	default:
+		assert.Fail(t, err.Error())
+	}
+}
+
+func TestDaxBase_SetTxnLog_commit(t *testing.T) {
+	Clear()
+	defer Clear()
+
+	path := filepath.Join(t.TempDir(), "txn.log")
+	log, err := OpenTxnLog(path)
+	assert.True(t, err.IsOk())
+	defer log.Close()
+
+	base := NewDaxBase()
+	base.SetTxnLog(log)
+	base.AddLocalDaxSrc("foo", RecoverableFooDaxSrc{})
+	base.AddLocalDaxSrc("bar", &BarDaxSrc{})
+
+	base.begin(context.Background(), false)
+	txnId := GetTxnId(base.Context())
+	assert.NotEqual(t, txnId, "")
+
+	_, err = base.GetDaxConn("foo")
+	assert.True(t, err.IsOk())
+	_, err = base.GetDaxConn("bar")
+	assert.True(t, err.IsOk())
+
+	err = base.commit()
+	assert.True(t, err.IsOk())
+	base.close()
+
+	assert.Equal(t, GetTxnId(base.Context()), "")
+	assert.Equal(t, logs.Front().Value,
+		"RecoverableFooDaxSrc#CreateDaxConnCtx:"+txnId)
+
+	assert.Equal(t, readTxnLogFile(t, path), []string{
+		`{"txn":"` + txnId + `","phase":"prepare","names":["bar","foo"]}`,
+		`{"txn":"` + txnId + `","phase":"commit"}`,
+		`{"txn":"` + txnId + `","phase":"end"}`,
+	})
+}
+
+func TestDaxBase_SetTxnLog_rollback(t *testing.T) {
+	Clear()
+	defer Clear()
+
+	path := filepath.Join(t.TempDir(), "txn.log")
+	log, err := OpenTxnLog(path)
+	assert.True(t, err.IsOk())
+	defer log.Close()
+
+	base := NewDaxBase()
+	base.SetTxnLog(log)
+	base.AddLocalDaxSrc("foo", FooDaxSrc{})
+	base.AddLocalDaxSrc("qux", QuxDaxSrc{})
+
+	base.begin(context.Background(), false)
+	txnId := GetTxnId(base.Context())
+
+	_, err = base.GetDaxConn("foo")
+	assert.True(t, err.IsOk())
+	_, err = base.GetDaxConn("qux")
+	assert.True(t, err.IsOk())
+
+	WillFailToPrepareQuxDaxConn = true
+
+	err = base.commit()
+	assert.False(t, err.IsOk())
+	base.rollback()
+	base.close()
+
+	assert.Equal(t, readTxnLogFile(t, path), []string{
+		`{"txn":"` + txnId + `","phase":"prepare","names":["foo","qux"]}`,
+		`{"txn":"` + txnId + `","phase":"rollback"}`,
+		`{"txn":"` + txnId + `","phase":"end"}`,
+	})
+}
+
+func TestDaxBase_SetTxnLog_compacted(t *testing.T) {
+	Clear()
+	defer Clear()
+
+	path := filepath.Join(t.TempDir(), "txn.log")
+	log, err := OpenTxnLog(path)
+	assert.True(t, err.IsOk())
+	defer log.Close()
+
+	base := NewDaxBase()
+	base.SetTxnLog(log)
+	base.AddLocalDaxSrc("foo", FooDaxSrc{})
+
+	var txnId string
+	for i := 0; i < 3; i++ {
+		base.begin(context.Background(), false)
+		txnId = GetTxnId(base.Context())
+
+		_, err = base.GetDaxConn("foo")
+		assert.True(t, err.IsOk())
+
+		err = base.commit()
+		assert.True(t, err.IsOk())
+		base.close()
+	}
+
+	assert.Equal(t, readTxnLogFile(t, path), []string{
+		`{"txn":"` + txnId + `","phase":"prepare","names":["foo"]}`,
+		`{"txn":"` + txnId + `","phase":"commit"}`,
+		`{"txn":"` + txnId + `","phase":"end"}`,
+	})
+}
+
+func TestDaxBase_SetTxnLog_notCompactedIfIncomplete(t *testing.T) {
+	Clear()
+	defer Clear()
+
+	path := filepath.Join(t.TempDir(), "txn.log")
+	e := os.WriteFile(path, []byte(
+		`{"txn":"t1","phase":"prepare","names":["foo"]}`+"\n"+
+			`{"txn":"t2","phase":"prepare","names":["foo"]}`+"\n"+
+			`{"txn":"t2","phase":"end"}`+"\n"), 0644)
+	assert.Nil(t, e)
+
+	log, err := OpenTxnLog(path)
+	assert.True(t, err.IsOk())
+	defer log.Close()
+
+	base := NewDaxBase()
+	base.SetTxnLog(log)
+	base.AddLocalDaxSrc("foo", FooDaxSrc{})
+
+	base.begin(context.Background(), false)
+	_, err = base.GetDaxConn("foo")
+	assert.True(t, err.IsOk())
+	err = base.commit()
+	assert.True(t, err.IsOk())
+	base.close()
+
+	assert.Equal(t, len(readTxnLogFile(t, path)), 6)
+}
+
+func TestDaxBase_SetTxnLog_notLoggedIfNotCommitted(t *testing.T) {
+	Clear()
+	defer Clear()
+
+	path := filepath.Join(t.TempDir(), "txn.log")
+	log, err := OpenTxnLog(path)
+	assert.True(t, err.IsOk())
+	defer log.Close()
+
+	base := NewDaxBase()
+	base.SetTxnLog(log)
+	base.AddLocalDaxSrc("foo", FooDaxSrc{})
+
+	base.begin(context.Background(), false)
+	_, err = base.GetDaxConn("foo")
+	assert.True(t, err.IsOk())
+	base.rollback()
+	base.close()
+
+	assert.Equal(t, readTxnLogFile(t, path), []string{})
+}
+
+func TestTxnLog_Recover(t *testing.T) {
+	Clear()
+	defer Clear()
+
+	path := filepath.Join(t.TempDir(), "txn.log")
+	e := os.WriteFile(path, []byte(
+		`{"txn":"t1","phase":"prepare","names":["foo"]}`+"\n"+
+			`{"txn":"t2","phase":"prepare","names":["foo"]}`+"\n"+
+			`{"txn":"t1","phase":"commit"}`+"\n"+
+			`{"txn":"t3","phase":"prepare","names":["foo"]}`+"\n"+
+			`{"txn":"t3","phase":"commit"}`+"\n"+
+			`{"txn":"t3","phase":"end"}`+"\n"+
+			`{"txn":"t4","pha`), 0644)
+	assert.Nil(t, e)
+
+	log, err := OpenTxnLog(path)
+	assert.True(t, err.IsOk())
+	defer log.Close()
+
+	AddGlobalDaxSrc("foo", RecoverableFooDaxSrc{})
+
+	base := NewDaxBase()
+	err = log.Recover(base)
+	assert.True(t, err.IsOk())
+
+	var a []string
+	for el := logs.Front(); el != nil; el = el.Next() {
+		a = append(a, el.Value.(string))
+	}
+	assert.Equal(t, a, []string{
+		"RecoverableFooDaxSrc#RecoverDaxConn:t1",
+		"FooDaxConn#Commit",
+		"FooDaxConn#Close",
+		"RecoverableFooDaxSrc#RecoverDaxConn:t2",
+		"FooDaxConn#Rollback",
+		"FooDaxConn#Close",
+	})
+
+	assert.Equal(t, readTxnLogFile(t, path), []string{})
+}
+
+func TestTxnLog_Recover_inCommitOrder(t *testing.T) {
+	Clear()
+	defer Clear()
+
+	path := filepath.Join(t.TempDir(), "txn.log")
+	e := os.WriteFile(path, []byte(
+		`{"txn":"t1","phase":"prepare","names":["foo","late","qux"]}`+"\n"+
+			`{"txn":"t1","phase":"commit"}`+"\n"+
+			`{"txn":"t2","phase":"prepare","names":["late","foo"]}`+"\n"), 0644)
+	assert.Nil(t, e)
+
+	log, err := OpenTxnLog(path)
+	assert.True(t, err.IsOk())
+	defer log.Close()
+
+	base := NewDaxBase()
+	base.AddLocalDaxSrcWithOrder("late", RecoverableQuxDaxSrc{}, 2)
+	base.AddLocalDaxSrcWithOrder("foo", RecoverableFooDaxSrc{}, 1)
+	base.AddLocalDaxSrcWithOrder("qux", RecoverableQuxDaxSrc{}, 1)
+
+	err = log.Recover(base)
+	assert.True(t, err.IsOk())
+
+	var a []string
+	for el := logs.Front(); el != nil; el = el.Next() {
+		s := el.Value.(string)
+		if strings.HasSuffix(s, "#Commit") || strings.HasSuffix(s, "#Rollback") {
+			a = append(a, s)
+		}
+	}
+	assert.Equal(t, a, []string{
+		"QuxDaxConn#Commit",
+		"FooDaxConn#Commit",
+		"QuxDaxConn#Commit",
+		"FooDaxConn#Rollback",
+		"QuxDaxConn#Rollback",
+	})
+
+	assert.Equal(t, readTxnLogFile(t, path), []string{})
+}
+
+func TestTxnLog_Recover_notCommittedIfSomeAreNotRecoverable(t *testing.T) {
+	Clear()
+	defer Clear()
+
+	path := filepath.Join(t.TempDir(), "txn.log")
+	e := os.WriteFile(path, []byte(
+		`{"txn":"t1","phase":"prepare","names":["bar","foo"]}`+"\n"+
+			`{"txn":"t1","phase":"commit"}`+"\n"), 0644)
+	assert.Nil(t, e)
+
+	log, err := OpenTxnLog(path)
+	assert.True(t, err.IsOk())
+	defer log.Close()
+
+	base := NewDaxBase()
+	base.AddLocalDaxSrc("bar", &BarDaxSrc{})
+	base.AddLocalDaxSrc("foo", RecoverableFooDaxSrc{})
+
+	err = log.Recover(base)
+	switch err.Reason().(type) {
+	case FailToRecoverTxn:
+		errs := err.Get("Errors").(map[string]Err)
+		assert.Equal(t, len(errs), 1)
+		assert.Equal(t, errs["bar"].ReasonName(), "DaxSrcIsNotRecoverable")
+	default:
+		assert.Fail(t, err.Error())
+	}
+
+	var a []string
+	for el := logs.Front(); el != nil; el = el.Next() {
+		a = append(a, el.Value.(string))
+	}
+	assert.Equal(t, a, []string{
+		"RecoverableFooDaxSrc#RecoverDaxConn:t1",
+		"FooDaxConn#Close",
+	})
+
+	assert.Equal(t, len(readTxnLogFile(t, path)), 2)
+}
+
+func TestTxnLog_Recover_daxSrcIsNotRecoverable(t *testing.T) {
+	Clear()
+	defer Clear()
+
+	path := filepath.Join(t.TempDir(), "txn.log")
+	e := os.WriteFile(path, []byte(
+		`{"txn":"t1","phase":"prepare","names":["bar","foo"]}`+"\n"), 0644)
+	assert.Nil(t, e)
+
+	log, err := OpenTxnLog(path)
+	assert.True(t, err.IsOk())
+	defer log.Close()
+
+	base := NewDaxBase()
+	base.AddLocalDaxSrc("foo", FooDaxSrc{})
+
+	err = log.Recover(base)
+	switch err.Reason().(type) {
+	case FailToRecoverTxn:
+		assert.Equal(t, err.Get("TxnId"), "t1")
+		errs := err.Get("Errors").(map[string]Err)
+		assert.Equal(t, len(errs), 2)
+		assert.Equal(t, errs["foo"].ReasonName(), "DaxSrcIsNotRecoverable")
+		assert.Equal(t, errs["bar"].ReasonName(), "DaxSrcIsNotFound")
+	default:
+		assert.Fail(t, err.Error())
+	}
+
+	assert.Equal(t, len(readTxnLogFile(t, path)), 1)
+}
+
+func TestTxnLog_Recover_brokenLastLine(t *testing.T) {
+	Clear()
+	defer Clear()
+
+	path := filepath.Join(t.TempDir(), "txn.log")
+	e := os.WriteFile(path, []byte(
+		`{"txn":"t1","phase":"prepare","names":["foo"]}`+"\n"+
+			`{"txn":"t1","pha`), 0644)
+	assert.Nil(t, e)
+
+	log, err := OpenTxnLog(path)
+	assert.True(t, err.IsOk())
+	defer log.Close()
+
+	base := NewDaxBase()
+	base.AddLocalDaxSrc("foo", FooDaxSrc{})
+
+	err = log.Recover(base)
+	assert.False(t, err.IsOk())
+
+	assert.Equal(t, readTxnLogFile(t, path), []string{
+		`{"txn":"t1","phase":"prepare","names":["foo"]}`,
+		`{"txn":"t1","pha`,
+	})
+
+	base.SetTxnLog(log)
+	base.AddLocalDaxSrc("bar", &BarDaxSrc{})
+	base.begin(context.Background(), false)
+	_, err = base.GetDaxConn("bar")
+	assert.True(t, err.IsOk())
+	err = base.commit()
+	assert.True(t, err.IsOk())
+	base.close()
+
+	recs, err := log.read()
+	assert.True(t, err.IsOk())
+	assert.Equal(t, len(recs), 4)
+}
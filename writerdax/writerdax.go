@@ -0,0 +1,196 @@
+// Copyright (C) 2023 Takayuki Sato. All Rights Reserved.
+// This program is free software under MIT License.
+// See the file LICENSE in this distribution for more details.
+
+// Package writerdax provides a DaxSrc and a DaxConn of sabi which buffer
+// outputs to an io.Writer like the standard output in a transaction, and
+// flush them only when the transaction is committed.
+package writerdax
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/sttk-go/sabi"
+	"io"
+	"os"
+	"sync"
+)
+
+type /* error reasons */ (
+	// FailToWrite is an error reason which indicates that it failed to flush
+	// buffered outputs to an io.Writer.
+	// The cause of an Err having this reason is an error returned by the
+	// io.Writer.
+	FailToWrite struct{}
+)
+
+type lockedWriter struct {
+	w     io.Writer
+	mutex sync.Mutex
+}
+
+func (lw *lockedWriter) write(p []byte) error {
+	lw.mutex.Lock()
+	defer lw.mutex.Unlock()
+
+	_, e := lw.w.Write(p)
+	return e
+}
+
+// WriterDaxSrc is a structure type which implements sabi.DaxSrc and creates
+// WriterDaxConn.
+// Outputs of transactions committed concurrently are written to the
+// io.Writer one transaction at a time, so they are not interleaved.
+// This also implements sabi.OrderedDaxSrc and its commit order is
+// sabi.LateCommitOrder.
+type WriterDaxSrc struct {
+	writer *lockedWriter
+}
+
+// NewWriterDaxSrc is a function which creates a new WriterDaxSrc which
+// flushes outputs to a specified io.Writer.
+func NewWriterDaxSrc(w io.Writer) WriterDaxSrc {
+	return WriterDaxSrc{writer: &lockedWriter{w: w}}
+}
+
+// NewConsoleDaxSrc is a function which creates a new WriterDaxSrc which
+// flushes outputs to the standard output.
+func NewConsoleDaxSrc() WriterDaxSrc {
+	return NewWriterDaxSrc(os.Stdout)
+}
+
+// CreateDaxConn is a method which creates a new WriterDaxConn.
+func (ds WriterDaxSrc) CreateDaxConn() (sabi.DaxConn, sabi.Err) {
+	return &WriterDaxConn{writer: ds.writer}, sabi.Ok()
+}
+
+// CommitOrder is a method which returns sabi.LateCommitOrder.
+func (ds WriterDaxSrc) CommitOrder() int {
+	return sabi.LateCommitOrder
+}
+
+// WriterDaxConn is a structure type which implements sabi.DaxConn and
+// io.Writer, and buffers outputs in a transaction.
+// Buffered outputs are flushed on #Commit and are discarded on #Rollback.
+// This also implements sabi.SavepointDaxConn.
+type WriterDaxConn struct {
+	writer     *lockedWriter
+	buf        bytes.Buffer
+	savepoints map[string]int
+	mutex      sync.Mutex
+}
+
+// Write is a method which buffers specified bytes.
+// This method always succeeds.
+func (conn *WriterDaxConn) Write(p []byte) (int, error) {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	return conn.buf.Write(p)
+}
+
+// Print is a method which buffers operands formatted like fmt.Print.
+func (conn *WriterDaxConn) Print(a ...any) sabi.Err {
+	fmt.Fprint(conn, a...)
+	return sabi.Ok()
+}
+
+// Printf is a method which buffers operands formatted like fmt.Printf.
+func (conn *WriterDaxConn) Printf(format string, a ...any) sabi.Err {
+	fmt.Fprintf(conn, format, a...)
+	return sabi.Ok()
+}
+
+// Println is a method which buffers operands formatted like fmt.Println.
+func (conn *WriterDaxConn) Println(a ...any) sabi.Err {
+	fmt.Fprintln(conn, a...)
+	return sabi.Ok()
+}
+
+// Commit is a method which flushes buffered outputs to the io.Writer.
+func (conn *WriterDaxConn) Commit() sabi.Err {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	if conn.buf.Len() == 0 {
+		return sabi.Ok()
+	}
+
+	e := conn.writer.write(conn.buf.Bytes())
+	conn.buf.Reset()
+	if e != nil {
+		return sabi.ErrBy(FailToWrite{}, e)
+	}
+	return sabi.Ok()
+}
+
+// Rollback is a method which discards buffered outputs.
+func (conn *WriterDaxConn) Rollback() {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	conn.buf.Reset()
+}
+
+// Close is a method which discards buffered outputs.
+func (conn *WriterDaxConn) Close() {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	conn.buf.Reset()
+	conn.savepoints = nil
+}
+
+// Savepoint is a method which saves the length of buffered outputs with a
+// specified name.
+func (conn *WriterDaxConn) Savepoint(name string) sabi.Err {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	if conn.savepoints == nil {
+		conn.savepoints = make(map[string]int)
+	}
+	conn.savepoints[name] = conn.buf.Len()
+	return sabi.Ok()
+}
+
+// RollbackTo is a method which discards outputs buffered after a savepoint
+// with a specified name.
+func (conn *WriterDaxConn) RollbackTo(name string) sabi.Err {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	n, ok := conn.savepoints[name]
+	if ok {
+		conn.buf.Truncate(n)
+		delete(conn.savepoints, name)
+	}
+	return sabi.Ok()
+}
+
+// Release is a method which discards a savepoint with a specified name.
+func (conn *WriterDaxConn) Release(name string) sabi.Err {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	delete(conn.savepoints, name)
+	return sabi.Ok()
+}
+
+// WriterDax is a structure type which is embedded in a dax structure
+// outputting texts, and provides a method to get a WriterDaxConn.
+type WriterDax struct {
+	sabi.Dax
+}
+
+// NewWriterDax is a function which creates a new WriterDax with a specified
+// Dax.
+func NewWriterDax(dax sabi.Dax) WriterDax {
+	return WriterDax{Dax: dax}
+}
+
+// GetWriterDaxConn is a method which gets a WriterDaxConn by a specified
+// name.
+func (dax WriterDax) GetWriterDaxConn(name string) (*WriterDaxConn, sabi.Err) {
+	return sabi.GetDaxConn[*WriterDaxConn](dax.Dax, name)
+}
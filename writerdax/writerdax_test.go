@@ -0,0 +1,127 @@
+package writerdax
+
+import (
+	"bytes"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/sttk-go/sabi"
+	"testing"
+)
+
+type FailToRun struct{}
+
+type SayDax struct {
+	WriterDax
+}
+
+func (dax SayDax) Say(text string) sabi.Err {
+	conn, err := dax.GetWriterDaxConn("console")
+	if !err.IsOk() {
+		return err
+	}
+	return conn.Println(text)
+}
+
+func newSayProc(ds WriterDaxSrc) (sabi.Proc[SayDax], *sabi.DaxBase) {
+	base := sabi.NewDaxBase()
+	base.AddLocalDaxSrc("console", ds)
+	return sabi.NewProc[SayDax](base, SayDax{NewWriterDax(base)}), base
+}
+
+func TestWriterDaxConn_flushOnCommit(t *testing.T) {
+	var buf bytes.Buffer
+	proc, _ := newSayProc(NewWriterDaxSrc(&buf))
+
+	err := proc.RunTxn(func(dax SayDax) sabi.Err {
+		err := dax.Say("Hello")
+		if !err.IsOk() {
+			return err
+		}
+		assert.Equal(t, buf.String(), "")
+
+		conn, err := dax.GetWriterDaxConn("console")
+		if !err.IsOk() {
+			return err
+		}
+		err = conn.Printf("%s, %d", "World", 1)
+		if !err.IsOk() {
+			return err
+		}
+		return conn.Print("!")
+	})
+	assert.True(t, err.IsOk())
+	assert.Equal(t, buf.String(), "Hello\nWorld, 1!")
+}
+
+func TestWriterDaxConn_discardOnRollback(t *testing.T) {
+	var buf bytes.Buffer
+	proc, _ := newSayProc(NewWriterDaxSrc(&buf))
+
+	err := proc.RunTxn(func(dax SayDax) sabi.Err {
+		err := dax.Say("Hello")
+		if !err.IsOk() {
+			return err
+		}
+		return sabi.ErrBy(FailToRun{})
+	})
+	assert.Equal(t, err.ReasonName(), "FailToRun")
+	assert.Equal(t, buf.String(), "")
+
+	err = proc.RunTxn(func(dax SayDax) sabi.Err {
+		return dax.Say("Bye")
+	})
+	assert.True(t, err.IsOk())
+	assert.Equal(t, buf.String(), "Bye\n")
+}
+
+func TestWriterDaxConn_Savepoint(t *testing.T) {
+	var buf bytes.Buffer
+	proc, base := newSayProc(NewWriterDaxSrc(&buf))
+
+	err := proc.RunTxn(func(dax SayDax) sabi.Err {
+		err := dax.Say("a")
+		if !err.IsOk() {
+			return err
+		}
+		err = sabi.Savepoint(base, func() sabi.Err {
+			err := dax.Say("b")
+			if !err.IsOk() {
+				return err
+			}
+			return sabi.ErrBy(FailToRun{})
+		})
+		assert.Equal(t, err.ReasonName(), "FailToRun")
+		return sabi.Savepoint(base, func() sabi.Err {
+			return dax.Say("c")
+		})
+	})
+	assert.True(t, err.IsOk())
+	assert.Equal(t, buf.String(), "a\nc\n")
+}
+
+type errWriter struct{}
+
+func (w errWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("closed")
+}
+
+func TestWriterDaxConn_Commit_failToWrite(t *testing.T) {
+	proc, _ := newSayProc(NewWriterDaxSrc(errWriter{}))
+
+	err := proc.RunTxn(func(dax SayDax) sabi.Err {
+		return dax.Say("Hello")
+	})
+	switch err.Reason().(type) {
+	case sabi.FailToCommitDaxConn:
+		errs := err.Get("Errors").(map[string]sabi.Err)
+		assert.Equal(t, errs["console"].ReasonName(), "FailToWrite")
+		assert.Equal(t, errs["console"].Cause().Error(), "closed")
+	default:
+		assert.Fail(t, err.Error())
+	}
+}
+
+func TestNewConsoleDaxSrc(t *testing.T) {
+	var ds sabi.OrderedDaxSrc = NewConsoleDaxSrc()
+	assert.Equal(t, ds.CommitOrder(), sabi.LateCommitOrder)
+}